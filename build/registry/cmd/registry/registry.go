@@ -20,15 +20,22 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/falcosecurity/plugins/build/registry/cmd/validateRegistry"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
 
+	"github.com/falcosecurity/plugins/build/registry/internal/logfile"
 	"github.com/falcosecurity/plugins/build/registry/internal/options"
 	"github.com/falcosecurity/plugins/build/registry/pkg/check"
+	"github.com/falcosecurity/plugins/build/registry/pkg/diff"
 	"github.com/falcosecurity/plugins/build/registry/pkg/distribution"
 	"github.com/falcosecurity/plugins/build/registry/pkg/oci"
 	"github.com/falcosecurity/plugins/build/registry/pkg/table"
@@ -36,6 +43,7 @@ import (
 
 const (
 	defaultTableSubTag = "<!-- REGISTRY -->"
+	overlayFlagUsage   = "Registry file merged into the registry file, whose plugins replace the ones with the same name or source ID and add the other ones. Can be repeated, the overlays being merged in order"
 )
 
 var (
@@ -50,45 +58,68 @@ func main() {
 		options.WithOutput(out),
 	)
 
+	var checkOverlays []string
 	checkCmd := &cobra.Command{
 		Use:                   "check <filename>",
 		Short:                 "Verify the correctness of a plugin registry YAML file",
 		Args:                  cobra.ExactArgs(1),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return check.DoCheck(args[0])
+			return check.DoCheck(args[0], checkOverlays...)
 		},
 	}
+	checkCmd.Flags().StringSliceVar(&checkOverlays, "overlay", nil, overlayFlagUsage)
 
 	var tableSubFileName string
 	var tableSubTab string
+	var tableOverlays []string
 	tableCmd := &cobra.Command{
 		Use:   "table <filename>",
 		Short: "Format a plugin registry YAML file in a MarkDown table",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(c *cobra.Command, args []string) error {
-			return table.DoTable(args[0], tableSubFileName, tableSubTab)
+			return table.DoTable(args[0], tableSubFileName, tableSubTab, tableOverlays...)
 		},
 	}
 	tableFlags := tableCmd.Flags()
 	tableFlags.StringVar(&tableSubTab, "subtag", defaultTableSubTag, "A tag that delimits the start and the end of the text section to substitute with the generated table.")
+	tableFlags.StringSliceVar(&tableOverlays, "overlay", nil, overlayFlagUsage)
 	tableFlags.StringVar(&tableSubFileName, "subfile", "", "If specified, the table will be written inside the file at this path, inserting it between the first two instances of the substitution tag.")
 
+	var diffJSON bool
+	var diffOverlays []string
+	diffCmd := &cobra.Command{
+		Use:   "diff <oldRegistryFilename> <newRegistryFilename>",
+		Short: "Show the plugin entries added, removed or modified between two registry YAML files",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(c *cobra.Command, args []string) error {
+			return diff.DoDiff(args[0], args[1], diffJSON, opts.Output, diffOverlays...)
+		},
+	}
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "Print the differences in JSON format")
+	diffCmd.Flags().StringSliceVar(&diffOverlays, "overlay", nil, overlayFlagUsage+". The overlays are merged into both registry files")
+
+	var updateIndexOverlays []string
 	updateIndexCmd := &cobra.Command{
 		Use:                   "update-index <registryFilename> <indexFilename>",
 		Short:                 "Update an index file for artifacts distribution using registry data",
 		Args:                  cobra.ExactArgs(2),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return distribution.DoUpdateIndex(args[0], args[1])
+			return distribution.DoUpdateIndex(args[0], args[1], updateIndexOverlays...)
 		},
 	}
+	updateIndexCmd.Flags().StringSliceVar(&updateIndexOverlays, "overlay", nil, overlayFlagUsage)
 
 	var (
-		pluginsAMD64Path string
-		pluginsARM64Path string
-		rulesfilesPath   string
-		devTag           string
+		updateOpts       oci.UpdateOptions
+		digestsFile      string
+		runTimeout       time.Duration
+		versionExtractor string
+		versionPattern   string
+		metricsPush      string
+		metricsFormat    string
+		attachments      []string
 	)
 	updateOCIRegistry := &cobra.Command{
 		Use:                   "update-oci-registry <registryFilename>",
@@ -96,7 +127,59 @@ func main() {
 		Args:                  cobra.ExactArgs(1),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			status, err := oci.DoUpdateOCIRegistry(opts.Context, args[0], pluginsAMD64Path, pluginsARM64Path, rulesfilesPath, devTag)
+			extractor, err := oci.NewVersionExtractor(versionExtractor, versionPattern)
+			if err != nil {
+				return err
+			}
+			updateOpts.VersionExtractor = extractor
+			for _, a := range attachments {
+				attachment, err := oci.ParseAttachment(a)
+				if err != nil {
+					return err
+				}
+				updateOpts.Attachments = append(updateOpts.Attachments, attachment)
+			}
+			if metricsFormat != oci.MetricsFormatPrometheus && metricsFormat != oci.MetricsFormatJSON {
+				return fmt.Errorf("unknown metrics format %q", metricsFormat)
+			}
+
+			ctx := opts.Context
+			if runTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, runTimeout)
+				defer cancel()
+			}
+			if metricsPush != "" {
+				updateOpts.Metrics = &oci.UpdateMetrics{}
+			}
+
+			updateOpts.RegistryFile = args[0]
+			status, err := oci.DoUpdateOCIRegistry(ctx, &updateOpts)
+			if metricsPush != "" {
+				// the metrics of a failed update are pushed as well, with a
+				// context that is not affected by the run timeout
+				if pushErr := oci.PushUpdateMetrics(opts.Context, metricsPush, metricsFormat, updateOpts.Metrics); pushErr != nil {
+					if err != nil {
+						klog.Error(pushErr)
+					} else {
+						err = pushErr
+					}
+				}
+			}
+			if digestsFile != "" && len(status) > 0 {
+				// record what has been pushed even if the update failed midway
+				if lockErr := oci.UpdateDigestsLock(digestsFile, status); lockErr != nil {
+					return fmt.Errorf("unable to update digests file %q: %w", digestsFile, lockErr)
+				}
+			}
+			var missingErr *oci.MissingPlatformsError
+			if errors.As(err, &missingErr) {
+				// the partial push is reported, and still fails the command
+				if printErr := oci.PrintUpdateStatus(status, opts.Output); printErr != nil {
+					klog.Error(printErr)
+				}
+				return err
+			}
 			if err != nil {
 				return err
 			}
@@ -106,23 +189,180 @@ func main() {
 	}
 
 	ociFlags := updateOCIRegistry.Flags()
-	ociFlags.StringVar(&pluginsAMD64Path, "plugins-amd64-path", "", "Path to plugins for the amd64 architecture")
-	ociFlags.StringVar(&pluginsARM64Path, "plugins-arm64-path", "", "Path to plugins for the arm64 architecture")
-	ociFlags.StringVar(&rulesfilesPath, "rulesfiles-path", "", "Path to rulesfiles")
-	ociFlags.StringVar(&devTag, "dev-tag", "", "Tag for devel versions")
+	ociFlags.StringSliceVar(&updateOpts.Overlays, "overlay", nil, overlayFlagUsage)
+	ociFlags.StringVar(&updateOpts.PluginsAMD64Path, "plugins-amd64-path", "", "Path to plugins for the amd64 architecture")
+	ociFlags.StringVar(&updateOpts.PluginsARM64Path, "plugins-arm64-path", "", "Path to plugins for the arm64 architecture")
+	ociFlags.StringVar(&updateOpts.RulesfilesPath, "rulesfiles-path", "", "Path to rulesfiles")
+	ociFlags.StringVar(&updateOpts.DevTag, "dev-tag", "", "Tag for devel versions")
+	ociFlags.StringVar(&updateOpts.LatestTag, "latest-tag", oci.DefaultLatestTag, "Moving tag applied along with the version tags of stable versions (e.g. stable)")
+	ociFlags.StringSliceVar(&updateOpts.ExcludedPlatforms, "exclude-platform", nil, "Platform (e.g. linux/arm64) whose builds must not be pushed, can be repeated")
+	ociFlags.StringSliceVar(&updateOpts.Match, "match", nil, "Glob pattern (e.g. 'k8s*') restricting the update to the matching plugin names, can be repeated")
+	ociFlags.BoolVar(&updateOpts.VerifyArchitecture, "verify-architecture", false, "Check that the binaries of each plugin build match the architecture of the platform they are pushed for")
+	ociFlags.BoolVar(&updateOpts.PartialOK, "partial-ok", false, "Push the builds of the platforms passing the architecture check even if others fail it, recording the missing platforms in the output. Partial pushes only get the full version tag. The command still exits with an error")
+	ociFlags.BoolVar(&updateOpts.SkipPlugins, "skip-plugins", false, "Skip the plugin builds, only pushing the rulesfiles")
+	ociFlags.BoolVar(&updateOpts.SkipRules, "skip-rules", false, "Skip the rulesfiles, only pushing the plugin builds")
+	ociFlags.BoolVar(&updateOpts.SkipInvalidBuilds, "skip-invalid-builds", false, "Skip the plugins having build objects whose version can't be determined, instead of failing before pushing anything. All the invalid build objects are reported in both cases")
+	ociFlags.StringVar(&versionExtractor, "version-extractor", oci.VersionExtractorFilename, fmt.Sprintf("Strategy used to extract the versions from the build names, one of %q or %q", oci.VersionExtractorFilename, oci.VersionExtractorRegexp))
+	ociFlags.StringVar(&versionPattern, "version-pattern", "", fmt.Sprintf("Regular expression whose first capturing group matches the version in the build names, used by the %q version extractor", oci.VersionExtractorRegexp))
+	ociFlags.StringVar(&digestsFile, "digests-file", "", "If specified, the digests of the pushed artifacts are recorded in the YAML file at this path")
+	ociFlags.DurationVar(&runTimeout, "run-timeout", 0, "Maximum duration of the whole update (e.g. 30m), after which the in-flight operations are canceled. The artifacts already pushed are kept. Zero means no timeout")
+	ociFlags.StringVar(&metricsPush, "metrics-push", "", "If specified, the metrics of the update (pushed, skipped and failed versions, pushed bytes and duration) are sent with a POST request to this URL on completion, e.g. the one of a Prometheus Pushgateway job")
+	ociFlags.StringVar(&metricsFormat, "metrics-format", oci.MetricsFormatPrometheus, fmt.Sprintf("Format of the metrics sent to the --metrics-push URL, one of %q or %q", oci.MetricsFormatPrometheus, oci.MetricsFormatJSON))
+	ociFlags.StringVar(&updateOpts.PluginMediaTypes.ArtifactType, "plugin-artifact-type", "", "If specified, set as the artifactType of the pushed plugin manifests and indexes")
+	ociFlags.StringVar(&updateOpts.PluginMediaTypes.ConfigMediaType, "plugin-config-media-type", "", "If specified, replaces the media type of the config layer of the pushed plugin manifests")
+	ociFlags.StringVar(&updateOpts.RulesfileMediaTypes.ArtifactType, "rulesfile-artifact-type", "", "If specified, set as the artifactType of the pushed rulesfile manifests")
+	ociFlags.StringVar(&updateOpts.RulesfileMediaTypes.ConfigMediaType, "rulesfile-config-media-type", "", "If specified, replaces the media type of the config layer of the pushed rulesfile manifests")
+	ociFlags.StringSliceVar(&attachments, "attach", nil, "Attachment in the <suffix>=<artifactType> form (e.g. .sig=application/vnd.example.signature): the file next to each pushed build with the same name plus suffix, if any, is attached to the pushed artifact (or to the manifest of its platform for multi-platform artifacts) through the OCI Referrers API. The files missing from the artifacts already pushed are attached as well. Can be repeated")
+	addRegistryClientFlags(updateOCIRegistry, &updateOpts.ClientOptions)
+
+	var (
+		validateOpts             oci.UpdateOptions
+		validateVersionExtractor string
+		validateVersionPattern   string
+	)
+	validateRules := &cobra.Command{
+		Use:   "validate-rules <registryFilename>",
+		Short: "Validate the structure of the rulesfiles of the plugins in the registry file, without pushing them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			extractor, err := oci.NewVersionExtractor(validateVersionExtractor, validateVersionPattern)
+			if err != nil {
+				return err
+			}
+			validateOpts.VersionExtractor = extractor
+			validateOpts.RegistryFile = args[0]
+			return oci.DoValidateRules(&validateOpts, opts.Output)
+		},
+	}
+	validateFlags := validateRules.Flags()
+	validateFlags.StringSliceVar(&validateOpts.Overlays, "overlay", nil, overlayFlagUsage)
+	validateFlags.StringVar(&validateOpts.RulesfilesPath, "rulesfiles-path", "", "Path to rulesfiles")
+	validateFlags.StringVar(&validateOpts.DevTag, "dev-tag", "", "Tag for devel versions")
+	validateFlags.StringSliceVar(&validateOpts.Match, "match", nil, "Glob pattern (e.g. 'k8s*') restricting the validation to the matching plugin names, can be repeated")
+	validateFlags.StringVar(&validateVersionExtractor, "version-extractor", oci.VersionExtractorFilename, fmt.Sprintf("Strategy used to extract the versions from the build names, one of %q or %q", oci.VersionExtractorFilename, oci.VersionExtractorRegexp))
+	validateFlags.StringVar(&validateVersionPattern, "version-pattern", "", fmt.Sprintf("Regular expression whose first capturing group matches the version in the build names, used by the %q version extractor", oci.VersionExtractorRegexp))
+
+	var (
+		latestOpts             oci.UpdateOptions
+		latestVersionExtractor string
+		latestVersionPattern   string
+	)
+	latestCmd := &cobra.Command{
+		Use:   "latest <registryFilename>",
+		Short: "Print the versions of the plugins and rulesfiles in the registry file that would be pushed, and whether the latest tag would be moved to them, without pushing them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			extractor, err := oci.NewVersionExtractor(latestVersionExtractor, latestVersionPattern)
+			if err != nil {
+				return err
+			}
+			latestOpts.VersionExtractor = extractor
+			latestOpts.RegistryFile = args[0]
+			return oci.DoResolveVersions(&latestOpts, opts.Output)
+		},
+	}
+	latestFlags := latestCmd.Flags()
+	latestFlags.StringSliceVar(&latestOpts.Overlays, "overlay", nil, overlayFlagUsage)
+	latestFlags.StringVar(&latestOpts.PluginsAMD64Path, "plugins-amd64-path", "", "Path to plugins for the amd64 architecture")
+	latestFlags.StringVar(&latestOpts.PluginsARM64Path, "plugins-arm64-path", "", "Path to plugins for the arm64 architecture")
+	latestFlags.StringVar(&latestOpts.RulesfilesPath, "rulesfiles-path", "", "Path to rulesfiles")
+	latestFlags.StringVar(&latestOpts.DevTag, "dev-tag", "", "Tag for devel versions")
+	latestFlags.StringVar(&latestOpts.LatestTag, "latest-tag", oci.DefaultLatestTag, "Moving tag applied along with the version tags of stable versions (e.g. stable)")
+	latestFlags.StringSliceVar(&latestOpts.ExcludedPlatforms, "exclude-platform", nil, "Platform (e.g. linux/arm64) whose builds must not be considered, can be repeated")
+	latestFlags.StringSliceVar(&latestOpts.Match, "match", nil, "Glob pattern (e.g. 'k8s*') restricting the output to the matching plugin names, can be repeated")
+	latestFlags.BoolVar(&latestOpts.SkipPlugins, "skip-plugins", false, "Skip the plugin builds, only printing the rulesfiles")
+	latestFlags.BoolVar(&latestOpts.SkipRules, "skip-rules", false, "Skip the rulesfiles, only printing the plugin builds")
+	latestFlags.StringVar(&latestVersionExtractor, "version-extractor", oci.VersionExtractorFilename, fmt.Sprintf("Strategy used to extract the versions from the build names, one of %q or %q", oci.VersionExtractorFilename, oci.VersionExtractorRegexp))
+	latestFlags.StringVar(&latestVersionPattern, "version-pattern", "", fmt.Sprintf("Regular expression whose first capturing group matches the version in the build names, used by the %q version extractor", oci.VersionExtractorRegexp))
+
+	var (
+		listRulesfile  bool
+		listClientOpts oci.ClientOptions
+	)
+	listOCIArtifacts := &cobra.Command{
+		Use:   "list-oci-artifacts <name>",
+		Short: "List the tags, digests and platforms published in the oci registry for a plugin or rulesfile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return oci.DoListOCIArtifacts(opts.Context, args[0], listRulesfile, listClientOpts, opts.Output)
+		},
+	}
+	listOCIArtifacts.Flags().BoolVar(&listRulesfile, "rulesfile", false, "List the rulesfile artifacts instead of the plugin ones")
+	addRegistryClientFlags(listOCIArtifacts, &listClientOpts)
 
 	rootCmd := &cobra.Command{
 		Use:     "registry",
 		Version: "0.2.0",
+		Long: `Tools to manage the plugin registry and the related artifacts.
+
+Logs are written to stderr, or to the file set with --log-file, which is
+rotated when it grows beyond --log-file-max-size. Their verbosity is
+controlled by -v:
+  0  the handled plugins, the pushed and skipped artifacts, warnings and errors (default)
+  2  the plugins and builds that are filtered out, and the steps of each push
+  4  debugging details, such as the digests compared with the OCI registry`,
 	}
+	addLogFlags(rootCmd)
 	rootCmd.AddCommand(checkCmd)
 	rootCmd.AddCommand(tableCmd)
+	rootCmd.AddCommand(diffCmd)
 	rootCmd.AddCommand(updateIndexCmd)
 	rootCmd.AddCommand(updateOCIRegistry)
+	rootCmd.AddCommand(listOCIArtifacts)
+	rootCmd.AddCommand(validateRules)
+	rootCmd.AddCommand(latestCmd)
 	rootCmd.AddCommand(validateRegistry.NewValidateRegistry(context.Background()))
 
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	klog.Flush()
+	if err != nil {
+		// note: os.Exit skips the deferred flush, and the output of failing
+		// commands (e.g. validate-rules) must still be printed
+		out.Flush()
 		fmt.Printf("error: %s\n", err)
 		os.Exit(1)
 	}
 }
+
+// addLogFlags adds to cmd the klog flag controlling the logs verbosity, as
+// both -v and --verbose, and the flags redirecting the logs to a file.
+func addLogFlags(cmd *cobra.Command) {
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	v := pflag.PFlagFromGoFlag(klogFlags.Lookup("v"))
+	v.Name = "verbose"
+	v.Shorthand = "v"
+	cmd.PersistentFlags().AddFlag(v)
+
+	var (
+		logFile        string
+		logFileMaxSize int64
+	)
+	cmd.PersistentFlags().StringVar(&logFile, "log-file", "", "If specified, logs are written to the file at this path instead of stderr")
+	cmd.PersistentFlags().Int64Var(&logFileMaxSize, "log-file-max-size", 100, "Size in MB beyond which the log file is rotated, keeping a single backup with the \".1\" suffix. Zero means no rotation")
+	cmd.PersistentPreRunE = func(c *cobra.Command, args []string) error {
+		if logFile == "" {
+			return nil
+		}
+		if logFileMaxSize < 0 {
+			return fmt.Errorf("invalid log file max size %d", logFileMaxSize)
+		}
+		w, err := logfile.New(logFile, logFileMaxSize*1024*1024)
+		if err != nil {
+			return fmt.Errorf("unable to open log file %q: %w", logFile, err)
+		}
+		klog.LogToStderr(false)
+		klog.SetOutput(w)
+		return nil
+	}
+}
+
+// addRegistryClientFlags adds to cmd the flags configuring the client of
+// the OCI registry. When set, they take precedence over the equivalent
+// environment variables read by the oci package.
+func addRegistryClientFlags(cmd *cobra.Command, clientOpts *oci.ClientOptions) {
+	flags := cmd.Flags()
+	flags.StringVar(&clientOpts.CAFile, "registry-ca", "", fmt.Sprintf("Path to a PEM bundle of CAs to trust for the OCI registry, in addition to the system ones (env: %s)", oci.RegistryCAFile))
+	flags.BoolVar(&clientOpts.Insecure, "registry-insecure", false, fmt.Sprintf("Skip the verification of the OCI registry certificate, only meant for testing. The registry is still reached over HTTPS (env: %s)", oci.RegistryInsecure))
+	flags.StringVar(&clientOpts.UserAgent, "user-agent", "", fmt.Sprintf("User agent sent to the OCI registry (default \"falco-plugins-oci-sync/<revision>\", env: %s)", oci.RegistryUserAgent))
+}
@@ -13,21 +13,23 @@ import (
 )
 
 func NewValidateRegistry(ctx context.Context) *cobra.Command {
+	var overlays []string
 	updateOCIRegistry := &cobra.Command{
 		Use:                   "validate-registry <registryFilename>",
 		Short:                 "Check that an OCI repo exists for each plugin in the registry file",
 		Args:                  cobra.ExactArgs(1),
 		DisableFlagsInUseLine: true,
 		RunE: func(c *cobra.Command, args []string) error {
-			return validateRegistry(ctx, args[0])
+			return validateRegistry(ctx, args[0], overlays...)
 		},
 	}
+	updateOCIRegistry.Flags().StringSliceVar(&overlays, "overlay", nil, "Registry file merged into the registry file, as for the check command. Can be repeated")
 
 	return updateOCIRegistry
 }
 
-func validateRegistry(ctx context.Context, registryFile string) error {
-	reg, err := registry.LoadRegistryFromFile(registryFile)
+func validateRegistry(ctx context.Context, registryFile string, overlays ...string) error {
+	reg, err := registry.LoadRegistryWithOverlays(registryFile, overlays...)
 	if err != nil {
 		return fmt.Errorf("an error occurred while loading registry entries from file %q: %v", registryFile, err)
 	}
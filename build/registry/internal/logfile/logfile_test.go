@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sync.log")
+	assert.NoError(t, os.WriteFile(path, []byte("previous run\n"), 0o644))
+
+	w, err := New(path, 40)
+	assert.NoError(t, err)
+	defer w.Close()
+
+	// the existing content counts towards the maximum size
+	line := strings.Repeat("x", 19) + "\n"
+	_, err = w.Write([]byte(line))
+	assert.NoError(t, err)
+	_, err = os.Stat(path + ".1")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = w.Write([]byte(line))
+	assert.NoError(t, err)
+	backup, err := os.ReadFile(path + ".1")
+	assert.NoError(t, err)
+	assert.Equal(t, "previous run\n"+line, string(backup))
+
+	_, err = w.Write([]byte(line))
+	assert.NoError(t, err)
+	current, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, line+line, string(current))
+
+	// writes larger than the maximum size are not split
+	big := strings.Repeat("y", 100) + "\n"
+	_, err = w.Write([]byte(big))
+	assert.NoError(t, err)
+	current, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, big, string(current))
+}
@@ -2,9 +2,10 @@ package check
 
 import "github.com/falcosecurity/plugins/build/registry/pkg/registry"
 
-// DoCheck loads the registry.yaml file from disk and validates it.
-func DoCheck(fileName string) error {
-	registry, err := registry.LoadRegistryFromFile(fileName)
+// DoCheck loads the registry.yaml file from disk, merged with the given
+// overlay files if any, and validates it.
+func DoCheck(fileName string, overlays ...string) error {
+	registry, err := registry.LoadRegistryWithOverlays(fileName, overlays...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+// FieldChange describes a field of a plugin entry that differs between two registries.
+type FieldChange struct {
+	// Field is the path of the field, using the names of the registry file (e.g. capabilities.sourcing.id).
+	Field string `json:"field"`
+	// Old is the value in the old registry, empty if the field is not set.
+	Old string `json:"old"`
+	// New is the value in the new registry, empty if the field is not set.
+	New string `json:"new"`
+}
+
+// PluginChange describes a plugin entry that differs between two registries.
+type PluginChange struct {
+	Name    string        `json:"name"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// Result is the difference between two registries. Plugins are sorted by name.
+type Result struct {
+	Added    []string       `json:"added"`
+	Removed  []string       `json:"removed"`
+	Modified []PluginChange `json:"modified"`
+}
+
+// Empty returns true if the registries have the same plugin entries.
+func (r *Result) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0
+}
+
+// DoDiff loads two registry files and prints the differences between their
+// plugin entries, either in a human-readable format or in JSON. The given
+// overlay files, if any, are merged into both registries, so that the
+// registries in effect for a given environment are compared.
+func DoDiff(oldFile, newFile string, jsonOutput bool, output io.Writer, overlays ...string) error {
+	oldReg, err := registry.LoadRegistryWithOverlays(oldFile, overlays...)
+	if err != nil {
+		return fmt.Errorf("an error occurred while loading registry entries from file %q: %v", oldFile, err)
+	}
+	newReg, err := registry.LoadRegistryWithOverlays(newFile, overlays...)
+	if err != nil {
+		return fmt.Errorf("an error occurred while loading registry entries from file %q: %v", newFile, err)
+	}
+
+	res := Diff(oldReg, newReg)
+	if jsonOutput {
+		enc := json.NewEncoder(output)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+	return printResult(res, output)
+}
+
+// Diff returns the differences between the plugin entries of two registries,
+// matched by name.
+func Diff(oldReg, newReg *registry.Registry) *Result {
+	res := &Result{Added: []string{}, Removed: []string{}, Modified: []PluginChange{}}
+	oldPlugins := pluginsByName(oldReg)
+	newPlugins := pluginsByName(newReg)
+
+	for name, oldPlugin := range oldPlugins {
+		newPlugin, ok := newPlugins[name]
+		if !ok {
+			res.Removed = append(res.Removed, name)
+			continue
+		}
+		if changes := diffPlugins(oldPlugin, newPlugin); len(changes) > 0 {
+			res.Modified = append(res.Modified, PluginChange{Name: name, Changes: changes})
+		}
+	}
+	for name := range newPlugins {
+		if _, ok := oldPlugins[name]; !ok {
+			res.Added = append(res.Added, name)
+		}
+	}
+
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	sort.Slice(res.Modified, func(i, j int) bool {
+		return res.Modified[i].Name < res.Modified[j].Name
+	})
+	return res
+}
+
+func pluginsByName(r *registry.Registry) map[string]*registry.Plugin {
+	res := make(map[string]*registry.Plugin, len(r.Plugins))
+	for i := range r.Plugins {
+		res[r.Plugins[i].Name] = &r.Plugins[i]
+	}
+	return res
+}
+
+// diffPlugins returns the fields that differ between two plugin entries, in
+// the order in which they appear in the registry file.
+func diffPlugins(oldPlugin, newPlugin *registry.Plugin) []FieldChange {
+	var oldFields, newFields fields
+	oldFields.flatten("", reflect.ValueOf(oldPlugin).Elem())
+	newFields.flatten("", reflect.ValueOf(newPlugin).Elem())
+
+	var changes []FieldChange
+	for _, name := range oldFields.names {
+		if oldFields.values[name] != newFields.values[name] {
+			changes = append(changes, FieldChange{Field: name, Old: oldFields.values[name], New: newFields.values[name]})
+		}
+	}
+	for _, name := range newFields.names {
+		if _, ok := oldFields.values[name]; !ok {
+			changes = append(changes, FieldChange{Field: name, New: newFields.values[name]})
+		}
+	}
+	return changes
+}
+
+// fields are the formatted values of the leaf fields of a structure, keyed by their path.
+type fields struct {
+	names  []string
+	values map[string]string
+}
+
+func (f *fields) set(name, value string) {
+	if f.values == nil {
+		f.values = make(map[string]string)
+	}
+	f.names = append(f.names, name)
+	f.values[name] = value
+}
+
+func (f *fields) flatten(path string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			f.flatten(path, v.Elem())
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = strings.ToLower(t.Field(i).Name)
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			f.flatten(name, v.Field(i))
+		}
+	case reflect.Slice:
+		if v.Len() > 0 && v.Type().Elem().Kind() == reflect.Struct {
+			for i := 0; i < v.Len(); i++ {
+				f.flatten(fmt.Sprintf("%s[%d]", path, i), v.Index(i))
+			}
+			return
+		}
+		var elems []string
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, formatValue(v.Index(i)))
+		}
+		if len(elems) > 0 {
+			f.set(path, "["+strings.Join(elems, ", ")+"]")
+		}
+	default:
+		if !v.IsZero() {
+			f.set(path, formatValue(v))
+		}
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+func printResult(res *Result, output io.Writer) error {
+	if res.Empty() {
+		_, err := fmt.Fprintln(output, "no changes")
+		return err
+	}
+	for _, name := range res.Added {
+		if _, err := fmt.Fprintf(output, "+ %s\n", name); err != nil {
+			return err
+		}
+	}
+	for _, name := range res.Removed {
+		if _, err := fmt.Fprintf(output, "- %s\n", name); err != nil {
+			return err
+		}
+	}
+	for _, p := range res.Modified {
+		if _, err := fmt.Fprintf(output, "~ %s\n", p.Name); err != nil {
+			return err
+		}
+		for _, c := range p.Changes {
+			if _, err := fmt.Fprintf(output, "    %s: %s -> %s\n", c.Field, orUnset(c.Old), orUnset(c.New)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func orUnset(value string) string {
+	if value == "" {
+		return "(unset)"
+	}
+	return value
+}
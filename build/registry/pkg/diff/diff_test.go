@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+func loadTestRegistry(t *testing.T, content string) *registry.Registry {
+	r := &registry.Registry{}
+	assert.NoError(t, r.Decode(strings.NewReader(content)))
+	return r
+}
+
+const testOldRegistry = `
+plugins:
+  - name: k8saudit
+    description: Read Kubernetes Audit Events
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit
+    keywords: [audit, kubernetes]
+    capabilities:
+      sourcing:
+        supported: true
+        id: 1
+        source: k8s_audit
+  - name: cloudtrail
+    description: Reads Cloudtrail JSON logs
+    capabilities:
+      sourcing:
+        supported: true
+        id: 2
+        source: aws_cloudtrail
+  - name: dummy
+    description: Reference plugin
+`
+
+const testNewRegistry = `
+plugins:
+  - name: k8saudit
+    description: Read Kubernetes Audit Events and monitor Kubernetes Clusters
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit
+    keywords: [audit, kubernetes, security]
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit/rules
+    capabilities:
+      sourcing:
+        supported: true
+        id: 1
+        source: k8s_audit
+  - name: cloudtrail
+    description: Reads Cloudtrail JSON logs
+    capabilities:
+      sourcing:
+        supported: true
+        id: 2
+        source: aws_cloudtrail
+  - name: okta
+    description: Okta Log Events
+`
+
+func TestDiff(t *testing.T) {
+	res := Diff(loadTestRegistry(t, testOldRegistry), loadTestRegistry(t, testNewRegistry))
+	assert.Equal(t, []string{"okta"}, res.Added)
+	assert.Equal(t, []string{"dummy"}, res.Removed)
+	assert.Equal(t, []PluginChange{{
+		Name: "k8saudit",
+		Changes: []FieldChange{
+			{
+				Field: "description",
+				Old:   `"Read Kubernetes Audit Events"`,
+				New:   `"Read Kubernetes Audit Events and monitor Kubernetes Clusters"`,
+			},
+			{Field: "keywords", Old: `["audit", "kubernetes"]`, New: `["audit", "kubernetes", "security"]`},
+			{Field: "rules_url", New: `"https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit/rules"`},
+		},
+	}}, res.Modified)
+
+	// removed and nested fields
+	res = Diff(loadTestRegistry(t, testNewRegistry), loadTestRegistry(t, strings.Replace(testOldRegistry, "id: 2", "id: 3", 1)))
+	assert.Equal(t, "cloudtrail", res.Modified[0].Name)
+	assert.Equal(t, []FieldChange{{Field: "capabilities.sourcing.id", Old: "2", New: "3"}}, res.Modified[0].Changes)
+	assert.Equal(t, "rules_url", res.Modified[1].Changes[2].Field)
+	assert.Empty(t, res.Modified[1].Changes[2].New)
+
+	res = Diff(loadTestRegistry(t, testOldRegistry), loadTestRegistry(t, testOldRegistry))
+	assert.True(t, res.Empty())
+}
+
+func TestDoDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldFile, newFile := filepath.Join(dir, "old.yaml"), filepath.Join(dir, "new.yaml")
+	assert.NoError(t, os.WriteFile(oldFile, []byte(testOldRegistry), 0o600))
+	assert.NoError(t, os.WriteFile(newFile, []byte(testNewRegistry), 0o600))
+
+	var out bytes.Buffer
+	assert.NoError(t, DoDiff(oldFile, newFile, false, &out))
+	assert.Equal(t, `+ okta
+- dummy
+~ k8saudit
+    description: "Read Kubernetes Audit Events" -> "Read Kubernetes Audit Events and monitor Kubernetes Clusters"
+    keywords: ["audit", "kubernetes"] -> ["audit", "kubernetes", "security"]
+    rules_url: (unset) -> "https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit/rules"
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, DoDiff(oldFile, newFile, true, &out))
+	var res Result
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, []string{"okta"}, res.Added)
+	assert.Equal(t, []string{"dummy"}, res.Removed)
+	assert.Len(t, res.Modified, 1)
+
+	out.Reset()
+	assert.NoError(t, DoDiff(oldFile, oldFile, false, &out))
+	assert.Equal(t, "no changes\n", out.String())
+
+	// the overlays are merged into both registries
+	overlayFile := filepath.Join(dir, "overlay.yaml")
+	assert.NoError(t, os.WriteFile(overlayFile, []byte(`
+plugins:
+  - name: k8saudit
+    description: Read Kubernetes Audit Events
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit
+    capabilities:
+      sourcing:
+        supported: true
+        id: 1
+        source: k8s_audit
+  - name: dummy
+    description: Reference plugin
+`), 0o600))
+	out.Reset()
+	assert.NoError(t, DoDiff(oldFile, newFile, false, &out, overlayFile))
+	assert.Equal(t, "+ okta\n", out.String())
+}
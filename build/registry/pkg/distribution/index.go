@@ -105,7 +105,9 @@ func upsertIndex(r *registry.Registry, ociArtifacts map[string]string, indexPath
 	return i.Write(indexPath)
 }
 
-func DoUpdateIndex(registryFile, indexFile string) error {
+// DoUpdateIndex updates the index file with the plugins of the registry file,
+// merged with the given overlay files if any.
+func DoUpdateIndex(registryFile, indexFile string, overlays ...string) error {
 	var user, reg string
 	var found bool
 	if user, found = os.LookupEnv(oci.RegistryUser); !found {
@@ -116,7 +118,7 @@ func DoUpdateIndex(registryFile, indexFile string) error {
 		return fmt.Errorf("environment variable with key %q not found, please set it before running this tool", oci.RegistryOCI)
 	}
 
-	registryEntries, err := registry.LoadRegistryFromFile(registryFile)
+	registryEntries, err := registry.LoadRegistryWithOverlays(registryFile, overlays...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/common"
+)
+
+// binaryArch returns the GOARCH-style architecture of the ELF, Mach-O or PE
+// binary at the given path. An empty string is returned for files that are
+// not binaries, and an error for binaries whose architecture is not known.
+func binaryArch(path string) (string, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		switch f.Machine {
+		case elf.EM_X86_64:
+			return "amd64", nil
+		case elf.EM_AARCH64:
+			return "arm64", nil
+		}
+		return "", fmt.Errorf("unknown architecture %q of binary %q", f.Machine, filepath.Base(path))
+	}
+
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		switch f.Cpu {
+		case macho.CpuAmd64:
+			return "amd64", nil
+		case macho.CpuArm64:
+			return "arm64", nil
+		}
+		return "", fmt.Errorf("unknown architecture %q of binary %q", f.Cpu, filepath.Base(path))
+	}
+
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		switch f.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			return "amd64", nil
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			return "arm64", nil
+		}
+		return "", fmt.Errorf("unknown architecture %#x of binary %q", f.Machine, filepath.Base(path))
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// verifyBuildArchitecture checks that the binaries contained in the build
+// archive at filePath have been built for the architecture of the given
+// platform (e.g. linux/arm64). Files that are not binaries, such as README
+// or LICENSE files, are skipped.
+func verifyBuildArchitecture(filePath, platform string) error {
+	expected := platform[strings.LastIndex(platform, "/")+1:]
+
+	tmpDir, err := os.MkdirTemp("", "registry-oci-")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary dir while preparing to extract build %q: %v", filePath, err)
+	}
+	defer os.RemoveAll(tmpDir)
+	files, err := common.ExtractTarGz(filePath, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		arch, err := binaryArch(file)
+		if err != nil {
+			return err
+		}
+		if arch != "" && arch != expected {
+			return fmt.Errorf("build %q contains %q built for %q, which does not match platform %q",
+				filepath.Base(filePath), filepath.Base(file), arch, platform)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/falcoctl/pkg/oci/repository"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/klog/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// Attachment describes the files attached to the pushed artifacts through the
+// OCI Referrers API, such as signatures or SBOMs. The file attached to the
+// artifact of a build is the one next to the build with the same name plus
+// Suffix (e.g. beta-rules-0.1.0.tar.gz.sig for the .sig suffix).
+type Attachment struct {
+	// Suffix is appended to the path of the builds to find the attached files.
+	Suffix string
+	// ArtifactType is the artifactType of the referrer artifacts.
+	ArtifactType string
+}
+
+// ParseAttachment parses an attachment in the <suffix>=<artifactType> form
+// (e.g. .sig=application/vnd.example.signature).
+func ParseAttachment(s string) (Attachment, error) {
+	suffix, artifactType, ok := strings.Cut(s, "=")
+	if !ok || suffix == "" || artifactType == "" {
+		return Attachment{}, fmt.Errorf("invalid attachment %q: expected <suffix>=<artifactType>", s)
+	}
+	return Attachment{Suffix: suffix, ArtifactType: artifactType}, nil
+}
+
+// pushAttachments pushes the files attached to the builds at the given paths
+// as referrers of the artifact with the given digest in the remote repository
+// identified by ref. For multi-platform artifacts, the files attached to each
+// build refer to the manifest of its platform rather than to the index, so that
+// they can be found from the manifest pulled for the platform. The platforms
+// are optional. Builds with no attached file are skipped, and so are the files
+// already attached, so that the attachments missing from an artifact pushed by
+// a previous run can be pushed later. On registries lacking the Referrers API,
+// the referrers are indexed with the referrers tag schema instead. It returns
+// the digests of the referrers of the attached files.
+func pushAttachments(ctx context.Context, client remote.Client, ref, dgst string, filepaths, platforms []string,
+	attachments []Attachment) ([]string, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := attachmentSubjects(ctx, repo, ref, dgst, len(filepaths), platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []string
+	existing := map[digest.Digest]bool{}
+	for i, fp := range filepaths {
+		subject := subjects[i]
+		if err := repo.Referrers(ctx, subject, "", func(referrers []v1.Descriptor) error {
+			for _, r := range referrers {
+				existing[r.Digest] = true
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("unable to list the referrers of %q: %w", ref+"@"+string(subject.Digest), err)
+		}
+		for _, attachment := range attachments {
+			path := fp + attachment.Suffix
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				klog.V(2).Infof("no file attached to build %q with suffix %q", fp, attachment.Suffix)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			desc, pushed, err := pushReferrer(ctx, repo, subject, filepath.Base(path), data, attachment.ArtifactType, existing)
+			if err != nil {
+				return nil, fmt.Errorf("unable to attach %q to %q: %w", path, ref+"@"+string(subject.Digest), err)
+			}
+			if pushed {
+				klog.Infof("attached %q to %q with artifact type %q, digest %q", path, ref+"@"+string(subject.Digest),
+					attachment.ArtifactType, desc.Digest)
+			} else {
+				klog.V(2).Infof("%q already attached to %q", path, ref+"@"+string(subject.Digest))
+			}
+			digests = append(digests, string(desc.Digest))
+		}
+	}
+	return digests, nil
+}
+
+// pushMissingAttachments pushes the files attached to the builds that are
+// missing from the artifact already pushed with the given tag, see
+// pushAttachments. This covers the attachments that failed to be pushed by
+// a previous run, and the ones added after the artifact was pushed.
+func pushMissingAttachments(ctx context.Context, client remote.Client, ref, tag string, filepaths, platforms []string,
+	attachments []Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return err
+	}
+	desc, err := repo.Resolve(ctx, tag)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %q: %w", ref+":"+tag, err)
+	}
+	_, err = pushAttachments(ctx, client, ref, string(desc.Digest), filepaths, platforms, attachments)
+	return err
+}
+
+// attachmentSubjects returns the descriptors of the artifacts the files
+// attached to each of the n builds refer to: the manifest of the platform
+// of the build for indexes, and the artifact with the given digest otherwise.
+func attachmentSubjects(ctx context.Context, repo *repository.Repository, ref, dgst string, n int,
+	platforms []string) ([]v1.Descriptor, error) {
+	desc, rc, err := repo.FetchReference(ctx, dgst)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve %q: %w", ref+"@"+dgst, err)
+	}
+	data, err := content.ReadAll(rc, desc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %w", ref+"@"+dgst, err)
+	}
+
+	subjects := make([]v1.Descriptor, n)
+	for i := range subjects {
+		subjects[i] = desc
+	}
+	if desc.MediaType != v1.MediaTypeImageIndex || len(platforms) == 0 {
+		return subjects, nil
+	}
+
+	var index v1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("unable to decode index %q: %w", ref+"@"+dgst, err)
+	}
+	for i := 0; i < n && i < len(platforms); i++ {
+		found := false
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.OS+"/"+m.Platform.Architecture == platforms[i] {
+				subjects[i] = m
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no manifest for platform %q in index %q", platforms[i], ref+"@"+dgst)
+		}
+	}
+	return subjects, nil
+}
+
+// pushReferrer pushes an artifact made of a single layer with the given data
+// and referring to subject, unless its manifest digest is in existing, and
+// returns its descriptor along with whether it was pushed.
+func pushReferrer(ctx context.Context, repo *repository.Repository, subject v1.Descriptor, title string,
+	data []byte, artifactType string, existing map[digest.Digest]bool) (v1.Descriptor, bool, error) {
+	layer := content.NewDescriptorFromBytes("application/octet-stream", data)
+	layer.Annotations = map[string]string{v1.AnnotationTitle: title}
+	// the artifact type is set as the config media type as well, since some
+	// registries and clients still identify the referrers by it
+	configData := []byte("{}")
+	config := content.NewDescriptorFromBytes(artifactType, configData)
+
+	manifest := v1.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    v1.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       config,
+		Layers:       []v1.Descriptor{layer},
+		Subject:      &v1.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	desc := content.NewDescriptorFromBytes(v1.MediaTypeImageManifest, manifestData)
+	if existing[desc.Digest] {
+		return desc, false, nil
+	}
+
+	if err := repo.Push(ctx, layer, bytes.NewReader(data)); err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	if err := repo.Push(ctx, config, bytes.NewReader(configData)); err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	err = repo.Push(ctx, desc, bytes.NewReader(manifestData))
+	var referrersErr *remote.ReferrersError
+	if errors.As(err, &referrersErr) && referrersErr.IsReferrersIndexDelete() {
+		// the referrer is pushed and indexed, but the outdated referrers
+		// index could not be deleted, which some registries don't allow
+		klog.Warningf("unable to delete outdated referrers index of %q: %v", subject.Digest, err)
+		err = nil
+	}
+	if err != nil {
+		return v1.Descriptor{}, false, err
+	}
+	return desc, true, nil
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+
+	"github.com/falcosecurity/falcoctl/pkg/oci/authn"
+	"k8s.io/klog/v2"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// userAgentName is the name of the tool in the default user agent sent to
+// the OCI registry.
+const userAgentName = "falco-plugins-oci-sync"
+
+// defaultUserAgent returns the user agent sent to the OCI registry when none
+// is configured. It contains the VCS revision the tool has been built from,
+// when available.
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && setting.Value != "" {
+				version = setting.Value
+				if len(version) > 12 {
+					version = version[:12]
+				}
+				break
+			}
+		}
+	}
+	return userAgentName + "/" + version
+}
+
+// ClientOptions configures the client of the OCI registry. When set, they take
+// precedence over the equivalent environment variables.
+type ClientOptions struct {
+	// CAFile is the path to a PEM bundle of CAs to trust for the OCI registry,
+	// in addition to the system ones.
+	CAFile string
+	// Insecure skips the verification of the OCI registry certificate. Registries
+	// are still reached over HTTPS.
+	Insecure bool
+	// UserAgent is the user agent sent to the OCI registry.
+	UserAgent string
+}
+
+// withClientOptions returns a copy of cfg with the given client options applied.
+func (cfg config) withClientOptions(opts ClientOptions) *config {
+	if opts.CAFile != "" {
+		cfg.registryCAFile = opts.CAFile
+	}
+	if opts.Insecure {
+		cfg.registryInsecure = true
+	}
+	if opts.UserAgent != "" {
+		cfg.registryUserAgent = opts.UserAgent
+	}
+	return &cfg
+}
+
+// newOCIClient returns a client authenticated with the registry credentials.
+// The registry certificate is verified against the system roots and, if
+// configured, the CAs in cfg.registryCAFile. The given options take precedence
+// over cfg.
+func newOCIClient(cfg *config, opts ClientOptions) (*auth.Client, error) {
+	cfg = cfg.withClientOptions(opts)
+	client := authn.NewClient(authn.WithCredentials(&auth.Credential{
+		Username: cfg.registryUser,
+		Password: cfg.registryToken,
+	}))
+
+	userAgent := cfg.registryUserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent()
+	}
+	client.SetUserAgent(userAgent)
+
+	if cfg.registryCAFile == "" && !cfg.registryInsecure {
+		return client, nil
+	}
+
+	tlsConfig, err := registryTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, ok := client.Client.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unable to configure TLS for the OCI client: unexpected transport %T", client.Client.Transport)
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return client, nil
+}
+
+func registryTLSConfig(cfg *config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.registryCAFile != "" {
+		pem, err := os.ReadFile(cfg.registryCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read registry CA file %q: %w", cfg.registryCAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in registry CA file %q", cfg.registryCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.registryInsecure {
+		klog.Warningf("the certificate of the OCI registry %q will not be verified, this must only be used for testing", cfg.registryHost)
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
@@ -40,9 +40,13 @@ const (
 	RegistryUser       = "REGISTRY_USER"
 	RegistryOCI        = "REGISTRY"
 	RepoGithub         = "REPO_GITHUB"
+	RegistryCAFile     = "REGISTRY_CA_FILE"
+	RegistryInsecure   = "REGISTRY_INSECURE"
+	RegistryUserAgent  = "REGISTRY_USER_AGENT"
 	FalcoAuthors       = "The Falco Authors"
 	PluginsRepo        = "https://github.com/falcosecurity/plugins"
 	archiveSuffix      = ".tar.gz"
 	amd64Platform      = "linux/amd64"
 	arm64Platform      = "linux/arm64"
+	DefaultLatestTag   = "latest"
 )
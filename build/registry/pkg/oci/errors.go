@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VersionParseError is returned when the version of an artifact can't be
+// determined from the name of its build object.
+type VersionParseError struct {
+	// BuildName is the name of the build object.
+	BuildName string
+	Err       error
+}
+
+func (e *VersionParseError) Error() string {
+	return fmt.Sprintf("unable to parse version for %q: %v", e.BuildName, e.Err)
+}
+
+func (e *VersionParseError) Unwrap() error {
+	return e.Err
+}
+
+// PushError is returned when an artifact can't be pushed to the OCI registry.
+type PushError struct {
+	// Name is the name of the pushed plugin or rulesfile.
+	Name string
+	// Ref is the reference of the OCI repository.
+	Ref string
+	// Rulesfile is true if the artifact is a rulesfile, false if it's a plugin.
+	Rulesfile bool
+	Err       error
+}
+
+func (e *PushError) Error() string {
+	kind := "plugin"
+	if e.Rulesfile {
+		kind = "rulesfile"
+	}
+	return fmt.Sprintf("an error occurred while pushing %s %q: %v", kind, e.Name, e.Err)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// InterruptedError is returned when the update of the OCI registry is
+// interrupted because its context is done, for example when its deadline
+// is exceeded. The artifacts of the completed plugins have been pushed.
+type InterruptedError struct {
+	// Completed are the names of the plugins whose artifacts have been handled.
+	Completed []string
+	// Pending are the names of the plugins that have not been handled yet.
+	Pending []string
+	Err     error
+}
+
+func (e *InterruptedError) Error() string {
+	reason := "run interrupted"
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		reason = "run timed out"
+	}
+	return fmt.Sprintf("%s: %d plugins completed %q, %d plugins pending %q",
+		reason, len(e.Completed), e.Completed, len(e.Pending), e.Pending)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// MissingPlatformsError is returned when some plugins have been pushed without
+// the builds of some of their platforms, see UpdateOptions.PartialOK. The
+// other artifacts have been pushed.
+type MissingPlatformsError struct {
+	// Missing are the platforms missing from the pushed artifacts, by reference.
+	Missing map[string][]string
+}
+
+func (e *MissingPlatformsError) Error() string {
+	var refs []string
+	for ref := range e.Missing {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	var missing []string
+	for _, ref := range refs {
+		missing = append(missing, fmt.Sprintf("%s %q", ref, e.Missing[ref]))
+	}
+	return fmt.Sprintf("artifacts pushed with missing platforms: %s", strings.Join(missing, ", "))
+}
+
+// InvalidBuildsError is returned before pushing any artifact when the
+// versions of some build objects can't be determined, see
+// UpdateOptions.SkipInvalidBuilds.
+type InvalidBuildsError struct {
+	// Errs are the errors of all the invalid build objects.
+	Errs []error
+}
+
+func (e *InvalidBuildsError) Error() string {
+	var errs []string
+	for _, err := range e.Errs {
+		errs = append(errs, err.Error())
+	}
+	return fmt.Sprintf("%d invalid build objects: %s", len(e.Errs), strings.Join(errs, ", "))
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/falcosecurity/falcoctl/pkg/oci"
+	"github.com/falcosecurity/falcoctl/pkg/oci/repository"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/klog/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// latestTagAttempts is the number of attempts at moving the latest tag.
+const latestTagAttempts = 3
+
+// latestTagRetryDelay is the delay before the first retry of moving the latest
+// tag, doubled at each retry.
+var latestTagRetryDelay = time.Second
+
+// splitLatestTag returns the given tags of a version without the latest moving
+// tag (DefaultLatestTag if empty), and the latter if they contain it.
+func splitLatestTag(tags []string, latestTag string) ([]string, string) {
+	if latestTag == "" {
+		latestTag = DefaultLatestTag
+	}
+	var versionTags []string
+	found := false
+	for _, tag := range tags {
+		if tag == latestTag {
+			found = true
+			continue
+		}
+		versionTags = append(versionTags, tag)
+	}
+	if !found {
+		return tags, ""
+	}
+	return versionTags, latestTag
+}
+
+// moveLatestTag points the latest tag of the remote repository identified by
+// ref to the artifact referenced by target (a tag or a digest), which must be
+// fully pushed already. Nothing is done if the latest tag already points to
+// it, so that moving the tag can safely be retried and repeated by later runs.
+// Failures are retried up to latestTagAttempts times.
+func moveLatestTag(ctx context.Context, client remote.Client, ref, target, latestTag string) error {
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return err
+	}
+
+	delay := latestTagRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = ensureTag(ctx, repo, target, latestTag)
+		if err == nil || attempt == latestTagAttempts || ctx.Err() != nil {
+			break
+		}
+		klog.Warningf("unable to move tag %q of %q to %q (attempt %d/%d), retrying in %s: %v",
+			latestTag, ref, target, attempt, latestTagAttempts, delay, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return fmt.Errorf("unable to move tag %q of %q to %q: %w", latestTag, ref, target, err)
+	}
+	return nil
+}
+
+// recoverLatestTag moves the latest tag of the remote repository identified by
+// ref to the already pushed artifact referenced by target, whose version is
+// version, in case a previous run failed before moving it. This is only done
+// if the latest tag is missing or points to an older version, so that
+// re-running on older versions never moves the latest tag back to them.
+func recoverLatestTag(ctx context.Context, client remote.Client, ref, target, version, latestTag string) error {
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return err
+	}
+
+	current, err := taggedVersion(ctx, repo, latestTag)
+	switch {
+	case errors.Is(err, errdef.ErrNotFound):
+		klog.Infof("tag %q of %q is missing, recovering it", latestTag, ref)
+	case err != nil:
+		return fmt.Errorf("unable to read the version tagged %q of %q: %w", latestTag, ref, err)
+	default:
+		v, err := semver.Parse(version)
+		if err != nil {
+			return err
+		}
+		if !v.GT(current) {
+			klog.V(2).Infof("tag %q of %q points to version %q, not moving it to %q", latestTag, ref, current, version)
+			return nil
+		}
+		klog.Infof("tag %q of %q points to older version %q, recovering it", latestTag, ref, current)
+	}
+	return moveLatestTag(ctx, client, ref, target, latestTag)
+}
+
+// taggedVersion returns the version in the config layer of the artifact tagged
+// with tag in repo. For multi-platform artifacts, the config layer of the first
+// platform is read, all of them having the same version.
+func taggedVersion(ctx context.Context, repo *repository.Repository, tag string) (semver.Version, error) {
+	desc, err := repo.Resolve(ctx, tag)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	data, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	if desc.MediaType == v1.MediaTypeImageIndex {
+		var index v1.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return semver.Version{}, err
+		}
+		if len(index.Manifests) == 0 {
+			return semver.Version{}, fmt.Errorf("empty index %q", desc.Digest)
+		}
+		if data, err = content.FetchAll(ctx, repo, index.Manifests[0]); err != nil {
+			return semver.Version{}, err
+		}
+	}
+
+	var manifest v1.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return semver.Version{}, err
+	}
+	data, err = content.FetchAll(ctx, repo, manifest.Config)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	var cfg oci.ArtifactConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return semver.Version{}, err
+	}
+	return semver.Parse(cfg.Version)
+}
+
+// ensureTag tags the artifact referenced by target with tag, unless the tag
+// already points to it.
+func ensureTag(ctx context.Context, repo *repository.Repository, target, tag string) error {
+	desc, err := repo.Resolve(ctx, target)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %q: %w", target, err)
+	}
+	current, err := repo.Resolve(ctx, tag)
+	if err == nil && current.Digest == desc.Digest {
+		klog.V(2).Infof("tag %q already points to %q", tag, desc.Digest)
+		return nil
+	}
+	if err != nil && !errors.Is(err, errdef.ErrNotFound) {
+		return fmt.Errorf("unable to resolve %q: %w", tag, err)
+	}
+	klog.Infof("moving tag %q to %q", tag, desc.Digest)
+	return repo.Tag(ctx, desc, tag)
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/falcosecurity/falcoctl/pkg/oci/repository"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry/remote/auth"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+// TagInfo describes a tag published in a remote OCI repository.
+type TagInfo struct {
+	Tag       string
+	Digest    string
+	Platforms []string
+}
+
+// DoListOCIArtifacts prints the tags currently published in the OCI repository
+// of the given plugin or rulesfile, along with their digests and platforms.
+// It is a read-only operation.
+func DoListOCIArtifacts(ctx context.Context, name string, rulesfile bool, clientOpts ClientOptions, output io.Writer) error {
+	cfg, err := lookupConfig()
+	if err != nil {
+		return err
+	}
+
+	ociClient, err := newOCIClient(cfg, clientOpts)
+	if err != nil {
+		return err
+	}
+
+	ref := refFromPluginEntry(cfg, &registry.Plugin{Name: name}, rulesfile)
+	infos, err := listTags(ctx, ociClient, ref)
+	if err != nil {
+		return err
+	}
+
+	return printTags(infos, output)
+}
+
+// listTags returns the tags of the repository identified by ref, each
+// resolved to its digest and, for multi-platform artifacts, to its platforms.
+func listTags(ctx context.Context, client *auth.Client, ref string) ([]TagInfo, error) {
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := repo.Tags(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tags for %q: %w", ref, err)
+	}
+	sort.Strings(tags)
+
+	infos := make([]TagInfo, 0, len(tags))
+	for _, tag := range tags {
+		desc, rc, err := repo.FetchReference(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch %q: %w", ref+":"+tag, err)
+		}
+		data, err := content.ReadAll(rc, desc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read manifest of %q: %w", ref+":"+tag, err)
+		}
+
+		info := TagInfo{Tag: tag, Digest: string(desc.Digest)}
+		if desc.MediaType == v1.MediaTypeImageIndex {
+			var index v1.Index
+			if err := json.Unmarshal(data, &index); err != nil {
+				return nil, fmt.Errorf("unable to decode index of %q: %w", ref+":"+tag, err)
+			}
+			for _, m := range index.Manifests {
+				if m.Platform != nil {
+					info.Platforms = append(info.Platforms, m.Platform.OS+"/"+m.Platform.Architecture)
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func printTags(infos []TagInfo, output io.Writer) error {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tDIGEST\tPLATFORMS")
+	for _, info := range infos {
+		platforms := "-"
+		if len(info.Platforms) > 0 {
+			platforms = strings.Join(info.Platforms, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", info.Tag, info.Digest, platforms)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+// DigestsLock records the digests of the artifacts pushed to the OCI registry,
+// so that what has been published can be verified later on.
+type DigestsLock struct {
+	// Digests maps each artifact reference to the digests of its versions.
+	Digests map[string]map[string]string `yaml:"digests"`
+}
+
+// LoadDigestsLock reads a digests lock file. An empty lock is returned if
+// the file does not exist.
+func LoadDigestsLock(path string) (*DigestsLock, error) {
+	lock := &DigestsLock{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return lock, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("unable to decode digests lock file %q: %w", path, err)
+	}
+	return lock, nil
+}
+
+// Record adds the digests of the given pushed artifacts to the lock. The
+// version of each artifact is its most specific tag.
+func (l *DigestsLock) Record(status registry.ArtifactsPushStatus) {
+	if l.Digests == nil {
+		l.Digests = make(map[string]map[string]string)
+	}
+	for _, s := range status {
+		if len(s.Artifact.Tags) == 0 {
+			continue
+		}
+		version := s.Artifact.Tags[len(s.Artifact.Tags)-1]
+		if l.Digests[s.Repository.Ref] == nil {
+			l.Digests[s.Repository.Ref] = make(map[string]string)
+		}
+		l.Digests[s.Repository.Ref][version] = s.Artifact.Digest
+	}
+}
+
+// Write atomically writes the lock to the given path.
+func (l *DigestsLock) Write(path string) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(l); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// UpdateDigestsLock records the digests of the given pushed artifacts in the
+// lock file at the given path, preserving the ones already recorded.
+func UpdateDigestsLock(path string, status registry.ArtifactsPushStatus) error {
+	lock, err := LoadDigestsLock(path)
+	if err != nil {
+		return err
+	}
+	lock.Record(status)
+	return lock.Write(path)
+}
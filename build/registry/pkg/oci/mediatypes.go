@@ -0,0 +1,203 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/falcosecurity/falcoctl/pkg/oci"
+	ocipusher "github.com/falcosecurity/falcoctl/pkg/oci/pusher"
+	"github.com/falcosecurity/falcoctl/pkg/oci/repository"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/klog/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// MediaTypes overrides the media types of the pushed artifacts of a kind
+// (plugins or rulesfiles). Empty values keep the ones set by the pusher.
+type MediaTypes struct {
+	// ArtifactType is set as the artifactType of the manifests and, for
+	// multi-platform artifacts, of the index.
+	ArtifactType string
+	// ConfigMediaType replaces the media type of the config layer of the manifests.
+	ConfigMediaType string
+}
+
+func (m MediaTypes) isEmpty() bool {
+	return m.ArtifactType == "" && m.ConfigMediaType == ""
+}
+
+// pushArtifact pushes the given build objects as an artifact of the given type
+// to the remote repository identified by ref, with the given tags. Platforms are
+// only used for plugins. Unless there are media types to override, the falcoctl
+// pusher is used.
+func pushArtifact(ctx context.Context, client remote.Client, artifactType oci.ArtifactType, ref string,
+	tags, filepaths, platforms []string, config *oci.ArtifactConfig, annotationSource string,
+	mediaTypes MediaTypes) (*oci.RegistryResult, error) {
+	if mediaTypes.isEmpty() {
+		pusher := ocipusher.NewPusher(client, false, nil)
+		files := ocipusher.WithFilepaths(filepaths)
+		if artifactType == oci.Plugin {
+			files = ocipusher.WithFilepathsAndPlatforms(filepaths, platforms)
+		}
+		return pusher.Push(ctx, artifactType, ref,
+			ocipusher.WithTags(tags...),
+			files,
+			ocipusher.WithArtifactConfig(*config),
+			ocipusher.WithAnnotationSource(annotationSource))
+	}
+	return pushWithMediaTypes(ctx, client, artifactType, ref, tags, filepaths, platforms, config,
+		annotationSource, mediaTypes)
+}
+
+// pushWithMediaTypes pushes the given build objects as the falcoctl pusher does,
+// but with the given media types. The final manifests are pushed before any tag
+// is applied, so that the tags never point to an artifact without the media types
+// and no intermediate artifact is left in the repository.
+func pushWithMediaTypes(ctx context.Context, client remote.Client, artifactType oci.ArtifactType, ref string,
+	tags, filepaths, platforms []string, config *oci.ArtifactConfig, annotationSource string,
+	mediaTypes MediaTypes) (*oci.RegistryResult, error) {
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return nil, err
+	}
+
+	layerMediaType, configMediaType := oci.FalcoPluginLayerMediaType, oci.FalcoPluginConfigMediaType
+	if artifactType == oci.Rulesfile {
+		layerMediaType, configMediaType = oci.FalcoRulesfileLayerMediaType, oci.FalcoRulesfileConfigMediaType
+	}
+	if mediaTypes.ConfigMediaType != "" {
+		configMediaType = mediaTypes.ConfigMediaType
+	}
+	var annotations map[string]string
+	if annotationSource != "" {
+		annotations = map[string]string{v1.AnnotationSource: annotationSource}
+	}
+
+	configDesc, err := pushJSON(ctx, repo, configMediaType, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to push config layer: %w", err)
+	}
+	manifests := make([]v1.Descriptor, 0, len(filepaths))
+	for i, fp := range filepaths {
+		layerDesc, err := pushFile(ctx, repo, layerMediaType, fp)
+		if err != nil {
+			return nil, fmt.Errorf("unable to push %q: %w", fp, err)
+		}
+		manifestDesc, err := pushJSON(ctx, repo, v1.MediaTypeImageManifest, v1.Manifest{
+			Versioned:    specs.Versioned{SchemaVersion: 2},
+			MediaType:    v1.MediaTypeImageManifest,
+			ArtifactType: mediaTypes.ArtifactType,
+			Config:       configDesc,
+			Layers:       []v1.Descriptor{layerDesc},
+			Annotations:  annotations,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to push manifest of %q: %w", fp, err)
+		}
+		manifestDesc.ArtifactType = mediaTypes.ArtifactType
+		if artifactType == oci.Plugin {
+			goos, goarch, ok := strings.Cut(platforms[i], "/")
+			if !ok {
+				return nil, fmt.Errorf("invalid platform %q", platforms[i])
+			}
+			manifestDesc.Platform = &v1.Platform{OS: goos, Architecture: goarch}
+		}
+		manifests = append(manifests, manifestDesc)
+	}
+
+	root := manifests[0]
+	if artifactType == oci.Plugin {
+		root, err = pushJSON(ctx, repo, v1.MediaTypeImageIndex, v1.Index{
+			Versioned:    specs.Versioned{SchemaVersion: 2},
+			MediaType:    v1.MediaTypeImageIndex,
+			ArtifactType: mediaTypes.ArtifactType,
+			Manifests:    manifests,
+			Annotations:  annotations,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to push index: %w", err)
+		}
+	}
+	for _, tag := range tags {
+		if err := repo.Tag(ctx, root, tag); err != nil {
+			return nil, fmt.Errorf("unable to tag %q: %w", ref+":"+tag, err)
+		}
+	}
+	klog.V(2).Infof("pushed %q with artifact type %q and config media type %q: digest %q",
+		ref, mediaTypes.ArtifactType, configMediaType, root.Digest)
+	return &oci.RegistryResult{Digest: string(root.Digest)}, nil
+}
+
+// pushJSON pushes the JSON encoding of v to repo with the given media type,
+// and returns its descriptor.
+func pushJSON(ctx context.Context, repo *repository.Repository, mediaType string, v interface{}) (v1.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	desc := content.NewDescriptorFromBytes(mediaType, data)
+	if err := repo.Push(ctx, desc, bytes.NewReader(data)); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return v1.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// pushFile pushes the content of the file at path to repo as a layer with the
+// given media type, unless it's already there, and returns its descriptor.
+func pushFile(ctx context.Context, repo *repository.Repository, mediaType, path string) (v1.Descriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	defer f.Close()
+	dgst, err := digest.SHA256.FromReader(f)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	desc := v1.Descriptor{
+		MediaType:   mediaType,
+		Digest:      dgst,
+		Size:        size,
+		Annotations: map[string]string{v1.AnnotationTitle: filepath.Base(path)},
+	}
+
+	if exists, err := repo.Exists(ctx, desc); err != nil || exists {
+		return desc, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return v1.Descriptor{}, err
+	}
+	return desc, repo.Push(ctx, desc, f)
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// MetricsFormatPrometheus and MetricsFormatJSON are the supported formats
+	// of the metrics pushed by PushUpdateMetrics.
+	MetricsFormatPrometheus = "prometheus"
+	MetricsFormatJSON       = "json"
+
+	metricsPrefix = "falco_plugins_oci_sync_"
+)
+
+// UpdateMetrics counts what happened during an update of the OCI registry.
+// The zero value is ready to use, and its methods can be called on a nil
+// pointer, in which case nothing is counted.
+type UpdateMetrics struct {
+	// Pushed is the number of versions pushed to the OCI registry.
+	Pushed int `json:"pushed"`
+	// Skipped is the number of versions not pushed because the OCI registry
+	// already has the same content.
+	Skipped int `json:"skipped"`
+	// Failed is the number of versions whose push failed.
+	Failed int `json:"failed"`
+	// BytesPushed is the total size of the files of the pushed versions.
+	BytesPushed int64 `json:"bytesPushed"`
+	// Duration is the duration of the whole update.
+	Duration time.Duration `json:"durationNanoseconds"`
+}
+
+func (m *UpdateMetrics) recordPushed(filepaths []string) {
+	if m == nil {
+		return
+	}
+	m.Pushed++
+	for _, fp := range filepaths {
+		if info, err := os.Stat(fp); err == nil {
+			m.BytesPushed += info.Size()
+		}
+	}
+}
+
+func (m *UpdateMetrics) recordSkipped() {
+	if m != nil {
+		m.Skipped++
+	}
+}
+
+func (m *UpdateMetrics) recordFailed() {
+	if m != nil {
+		m.Failed++
+	}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format,
+// as accepted by a Prometheus Pushgateway.
+func (m *UpdateMetrics) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name  string
+		help  string
+		value string
+	}{
+		{"versions_pushed", "Number of versions pushed to the OCI registry.", fmt.Sprint(m.Pushed)},
+		{"versions_skipped", "Number of versions already up to date in the OCI registry.", fmt.Sprint(m.Skipped)},
+		{"versions_failed", "Number of versions whose push failed.", fmt.Sprint(m.Failed)},
+		{"pushed_bytes", "Total size of the files of the pushed versions.", fmt.Sprint(m.BytesPushed)},
+		{"duration_seconds", "Duration of the whole update.", fmt.Sprint(m.Duration.Seconds())},
+	}
+	for _, metric := range metrics {
+		name := metricsPrefix + metric.name
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n",
+			name, metric.help, name, name, metric.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PushUpdateMetrics sends the metrics to the given URL with a POST request,
+// in the given format. For a Prometheus Pushgateway, the URL is the one of
+// the job grouping (e.g. http://pushgateway:9091/metrics/job/plugins-oci-sync).
+func PushUpdateMetrics(ctx context.Context, url, format string, m *UpdateMetrics) error {
+	var (
+		body        bytes.Buffer
+		contentType string
+	)
+	switch format {
+	case MetricsFormatPrometheus:
+		contentType = "text/plain; version=0.0.4"
+		if err := m.WritePrometheus(&body); err != nil {
+			return err
+		}
+	case MetricsFormatJSON:
+		contentType = "application/json"
+		if err := json.NewEncoder(&body).Encode(m); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown metrics format %q", format)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to push metrics to %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unable to push metrics to %q: unexpected status %s", url, resp.Status)
+	}
+	return nil
+}
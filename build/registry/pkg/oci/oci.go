@@ -24,7 +24,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk/plugins"
 
@@ -32,12 +35,9 @@ import (
 
 	"github.com/blang/semver"
 	"github.com/falcosecurity/falcoctl/pkg/oci"
-	"github.com/falcosecurity/falcoctl/pkg/oci/authn"
-	ocipusher "github.com/falcosecurity/falcoctl/pkg/oci/pusher"
 	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
 	"k8s.io/klog/v2"
 	"oras.land/oras-go/v2/registry/remote"
-	"oras.land/oras-go/v2/registry/remote/auth"
 )
 
 type config struct {
@@ -49,6 +49,12 @@ type config struct {
 	registryHost string
 	// pluginsRepo the Ref of the git repository associated with the OCI artifacts.
 	pluginsRepo string
+	// registryCAFile optional path to a PEM bundle of CAs trusted for the OCI registry.
+	registryCAFile string
+	// registryInsecure disables the verification of the OCI registry certificate.
+	registryInsecure bool
+	// registryUserAgent optional user agent sent to the OCI registry.
+	registryUserAgent string
 }
 
 func lookupConfig() (*config, error) {
@@ -71,6 +77,15 @@ func lookupConfig() (*config, error) {
 		return nil, fmt.Errorf("environment variable with key %q not found, please set it before running this tool", RepoGithub)
 	}
 
+	cfg.registryCAFile = os.Getenv(RegistryCAFile)
+	cfg.registryUserAgent = os.Getenv(RegistryUserAgent)
+	if insecure, found := os.LookupEnv(RegistryInsecure); found {
+		var err error
+		if cfg.registryInsecure, err = strconv.ParseBool(insecure); err != nil {
+			return nil, fmt.Errorf("environment variable with key %q must be a boolean: %w", RegistryInsecure, err)
+		}
+	}
+
 	return cfg, nil
 }
 
@@ -93,40 +108,167 @@ func currentPlatform() string {
 	return fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
 }
 
+// UpdateOptions configures an update of the OCI registry.
+type UpdateOptions struct {
+	// RegistryFile is the registry file listing the plugins, see registry.LoadRegistryFromFile.
+	RegistryFile string
+	// Overlays are the registry files merged into the registry file, see registry.LoadRegistryWithOverlays.
+	Overlays []string
+	// PluginsAMD64Path is the folder containing the plugin builds for the amd64 architecture.
+	PluginsAMD64Path string
+	// PluginsARM64Path is the folder containing the plugin builds for the arm64 architecture.
+	PluginsARM64Path string
+	// RulesfilesPath is the folder containing the rulesfiles.
+	RulesfilesPath string
+	// DevTag is the tag used for devel versions.
+	DevTag string
+	// LatestTag is the moving tag applied along with the version tags of stable versions.
+	// If empty, DefaultLatestTag is used.
+	LatestTag string
+	// ExcludedPlatforms are the platforms whose builds are not pushed.
+	ExcludedPlatforms []string
+	// Match restricts the update to the plugins whose name matches at least one
+	// of these glob patterns (see filepath.Match). All the plugins are updated if empty.
+	Match []string
+	// VerifyArchitecture requires the binaries of each plugin build to match the architecture
+	// of the platform they are pushed for.
+	VerifyArchitecture bool
+	// PartialOK pushes the plugin builds of the platforms that pass the checks even if the
+	// ones of other platforms don't, instead of failing the push of the plugin. The missing
+	// platforms are recorded in the push metadata, and reported by a MissingPlatformsError
+	// returned once all the plugins have been handled. Partial pushes only get the full
+	// version tag: the floating major, minor and latest tags are not moved.
+	PartialOK bool
+	// SkipPlugins skips the plugin builds, so that only the rulesfiles are pushed.
+	SkipPlugins bool
+	// SkipRules skips the rulesfiles, so that only the plugin builds are pushed.
+	SkipRules bool
+	// SkipInvalidBuilds skips the plugins having build objects whose versions can't be
+	// determined, instead of failing the update. In both cases, the versions of all the
+	// build objects are checked before pushing anything, and all the invalid ones are reported.
+	SkipInvalidBuilds bool
+	// VersionExtractor extracts the versions from the names of the build objects. If nil, the
+	// VersionExtractorFilename strategy is used.
+	VersionExtractor VersionExtractor
+	// Client is the client used to interact with the OCI registry. If nil, a client is created
+	// using the credentials found in the environment.
+	Client remote.Client
+	// ClientOptions configures the client created when Client is nil.
+	ClientOptions ClientOptions
+	// Metrics, if not nil, counts the pushed, skipped and failed versions, and the duration
+	// of the update, including when it fails.
+	Metrics *UpdateMetrics
+	// PluginMediaTypes overrides the media types of the pushed plugin artifacts.
+	PluginMediaTypes MediaTypes
+	// RulesfileMediaTypes overrides the media types of the pushed rulesfile artifacts.
+	RulesfileMediaTypes MediaTypes
+	// Attachments are the files attached to the pushed plugin and rulesfile artifacts as referrers.
+	Attachments []Attachment
+}
+
 // DoUpdateOCIRegistry publishes new plugins with related rules to be released.
 // For each plugin in the registry index, it looks for new versions, since the latest version fetched from the remote OCI
 // repository, as tags on the local Git repository.
 // For each new version, it downloads the related plugin and rule set from the Falco distribution and updates the OCI
-// repository accordingly.
-func DoUpdateOCIRegistry(ctx context.Context, registryFile, pluginsAMD4, pluginsARM64, rulesfiles, devTag string) ([]registry.ArtifactPushMetadata, error) {
+// repository accordingly. Artifacts whose files are already published under the same version tag are not
+// pushed again, so that re-running the update is a no-op.
+func DoUpdateOCIRegistry(ctx context.Context, opts *UpdateOptions) ([]registry.ArtifactPushMetadata, error) {
 	var (
 		cfg *config
 		err error
 	)
 
+	if opts.Metrics != nil {
+		start := time.Now()
+		defer func() { opts.Metrics.Duration = time.Since(start) }()
+	}
+
 	// Load the configuration from env variables.
 	if cfg, err = lookupConfig(); err != nil {
 		return nil, err
 	}
 
-	cred := &auth.Credential{
-		Username: cfg.registryUser,
-		Password: cfg.registryToken,
+	ociClient := opts.Client
+	if ociClient == nil {
+		if ociClient, err = newOCIClient(cfg, opts.ClientOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateLatestTag(opts.LatestTag); err != nil {
+		return nil, err
+	}
+
+	if opts.SkipPlugins && opts.SkipRules {
+		return nil, fmt.Errorf("invalid options: the plugins and the rulesfiles can't be both skipped")
 	}
 
-	ociClient := authn.NewClient(authn.WithCredentials(cred))
+	for _, pattern := range opts.Match {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid plugin name pattern %q: %w", pattern, err)
+		}
+	}
 
-	reg, err := registry.LoadRegistryFromFile(registryFile)
+	reg, err := registry.LoadRegistryWithOverlays(opts.RegistryFile, opts.Overlays...)
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred while loading registry entries from file %q: %v", registryFile, err)
+		return nil, fmt.Errorf("an error occurred while loading registry entries from file %q: %v", opts.RegistryFile, err)
 	}
 
 	artifacts := []registry.ArtifactPushMetadata{}
 
-	// For each plugin in the registry index, look for new ones to be released, and publish them.
+	var plugins []registry.Plugin
 	for _, plugin := range reg.Plugins {
-		pa, ra, err := handleArtifact(ctx, cfg, &plugin, ociClient, pluginsAMD4, pluginsARM64, rulesfiles, devTag)
+		if matchPluginName(plugin.Name, opts.Match) {
+			plugins = append(plugins, plugin)
+		}
+	}
+
+	// Check the build objects of all the plugins before pushing anything.
+	invalid, errs := checkBuildVersions(plugins, opts)
+	if len(errs) > 0 {
+		if !opts.SkipInvalidBuilds {
+			return artifacts, &InvalidBuildsError{Errs: errs}
+		}
+		for _, err := range errs {
+			klog.Errorf("skipping invalid build object: %v", err)
+		}
+		var valid []registry.Plugin
+		for _, plugin := range plugins {
+			if !invalid[plugin.Name] {
+				valid = append(valid, plugin)
+			}
+		}
+		plugins = valid
+	}
+
+	// interrupted returns the error reporting the plugins handled before the
+	// context got done, if it is
+	interrupted := func(i int) error {
+		if ctx.Err() == nil {
+			return nil
+		}
+		ierr := &InterruptedError{Err: ctx.Err()}
+		for j, plugin := range plugins {
+			if j < i {
+				ierr.Completed = append(ierr.Completed, plugin.Name)
+			} else {
+				ierr.Pending = append(ierr.Pending, plugin.Name)
+			}
+		}
+		return ierr
+	}
+
+	// For each plugin in the registry index, look for new ones to be released, and publish them.
+	for i, plugin := range plugins {
+		if err := interrupted(i); err != nil {
+			return artifacts, err
+		}
+
+		pa, ra, err := handleArtifact(ctx, cfg, &plugin, ociClient, opts)
 		if err != nil {
+			if ierr := interrupted(i); ierr != nil {
+				return artifacts, ierr
+			}
 			return artifacts, err
 		}
 
@@ -139,10 +281,79 @@ func DoUpdateOCIRegistry(ctx context.Context, registryFile, pluginsAMD4, plugins
 		}
 	}
 
-	return artifacts, nil
+	return artifacts, missingPlatformsError(artifacts)
 }
 
-func tagsFromVersion(version *semver.Version) []string {
+// checkBuildVersions determines the versions of the build objects of the given
+// plugins as their handlers do, without pushing anything. It returns the names
+// of the plugins having build objects whose versions can't be determined, along
+// with the errors of all of them.
+func checkBuildVersions(plugins []registry.Plugin, opts *UpdateOptions) (map[string]bool, []error) {
+	invalid := map[string]bool{}
+	var errs []error
+	for i := range plugins {
+		if _, perrs := resolveVersions(&plugins[i], opts); len(perrs) > 0 {
+			invalid[plugins[i].Name] = true
+			errs = append(errs, perrs...)
+		}
+	}
+	return invalid, errs
+}
+
+// missingPlatformsError returns the error reporting the pushed artifacts
+// with missing platforms, if any.
+func missingPlatformsError(artifacts []registry.ArtifactPushMetadata) error {
+	var merr *MissingPlatformsError
+	for _, a := range artifacts {
+		if len(a.Artifact.MissingPlatforms) == 0 {
+			continue
+		}
+		if merr == nil {
+			merr = &MissingPlatformsError{Missing: map[string][]string{}}
+		}
+		merr.Missing[a.Repository.Ref] = a.Artifact.MissingPlatforms
+	}
+	if merr == nil {
+		return nil
+	}
+	return merr
+}
+
+// matchPluginName returns true if the plugin name matches at least one of the
+// given glob patterns, or if no pattern is given. Patterns are expected
+// to be valid.
+func matchPluginName(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tagRegexp matches the valid tags of OCI artifacts.
+var tagRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// validateLatestTag returns an error if tag can't be used as the moving tag
+// of the last pushed version. An empty tag is valid, and stands for DefaultLatestTag.
+func validateLatestTag(tag string) error {
+	if tag == "" {
+		return nil
+	}
+	if !tagRegexp.MatchString(tag) {
+		return fmt.Errorf("invalid latest tag %q: not a valid OCI tag", tag)
+	}
+	// the version tags (e.g. 1, 1.2 and 1.2.3) would be overwritten
+	if tag[0] >= '0' && tag[0] <= '9' {
+		return fmt.Errorf("invalid latest tag %q: it must not start with a digit, as version tags do", tag)
+	}
+	return nil
+}
+
+func tagsFromVersion(version *semver.Version, latestTag string) []string {
 	var tags []string
 
 	// If we are not handling a release candidate then add floating tags.
@@ -151,7 +362,10 @@ func tagsFromVersion(version *semver.Version) []string {
 		minorVer := fmt.Sprintf("%d.%d", version.Major, version.Minor)
 		fullVer := version.String()
 
-		tags = append(tags, "latest", majorVer, minorVer, fullVer)
+		if latestTag == "" {
+			latestTag = DefaultLatestTag
+		}
+		tags = append(tags, latestTag, majorVer, minorVer, fullVer)
 	} else {
 		tags = append(tags, version.String())
 	}
@@ -163,27 +377,33 @@ func tagsFromVersion(version *semver.Version) []string {
 // It could happen that for a given plugin no artifacts such as builds and rulesets are available.
 // Consider the case when we release a single plugin.
 func handleArtifact(ctx context.Context, cfg *config, plugin *registry.Plugin, ociClient remote.Client,
-	pluginsAMD64, pluginsARM64, rulesfiles, devTag string) ([]registry.ArtifactPushMetadata, []registry.ArtifactPushMetadata, error) {
+	opts *UpdateOptions) ([]registry.ArtifactPushMetadata, []registry.ArtifactPushMetadata, error) {
 	// Filter out plugins that are not owned by falcosecurity.
 	if !strings.HasPrefix(plugin.URL, PluginsRepo) {
 		sepString := strings.Repeat("#", 15)
-		klog.Info("%s %s %s", sepString, plugin.Name, sepString)
-		klog.Infof("skipping plugin %q with authors %q: it is not maintained by %q",
+		klog.V(2).Infof("%s %s %s", sepString, plugin.Name, sepString)
+		klog.V(2).Infof("skipping plugin %q with authors %q: it is not maintained by %q",
 			plugin.Name, plugin.Authors, FalcoAuthors)
 		return nil, nil, nil
 	}
 
+	var err error
+
 	// Handle the plugin.
-	newPluginArtifacts, err := handlePlugin(ctx, cfg, plugin, ociClient, pluginsAMD64, pluginsARM64, devTag)
-	if err != nil {
-		return nil, nil, err
+	newPluginArtifacts := []registry.ArtifactPushMetadata{}
+
+	if !opts.SkipPlugins {
+		newPluginArtifacts, err = handlePlugin(ctx, cfg, plugin, ociClient, opts)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// Handle the rules.
 	newRuleArtifacts := []registry.ArtifactPushMetadata{}
 
-	if plugin.RulesURL != "" {
-		newRuleArtifacts, err = handleRule(ctx, cfg, plugin, ociClient, rulesfiles, devTag)
+	if plugin.RulesURL != "" && !opts.SkipRules {
+		newRuleArtifacts, err = handleRule(ctx, cfg, plugin, ociClient, opts)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -195,7 +415,8 @@ func handleArtifact(ctx context.Context, cfg *config, plugin *registry.Plugin, o
 // handlePlugin for a given plugin it checks if there exists build artifacts in the given folders, and
 // if found packs them as an OCI artifact and pushes them to the registry.
 func handlePlugin(ctx context.Context, cfg *config, plugin *registry.Plugin, ociClient remote.Client,
-	pluginsAMD64, pluginsARM64 string, devTag string) ([]registry.ArtifactPushMetadata, error) {
+	opts *UpdateOptions) ([]registry.ArtifactPushMetadata, error) {
+	pluginsAMD64, pluginsARM64 := opts.PluginsAMD64Path, opts.PluginsARM64Path
 	var configLayer *oci.ArtifactConfig
 	var err error
 	var filepaths, platforms, tags []string
@@ -209,7 +430,7 @@ func handlePlugin(ctx context.Context, cfg *config, plugin *registry.Plugin, oci
 	metadata := []registry.ArtifactPushMetadata{}
 
 	// Get the name of the build object for the amd64 architecture.
-	amd64Build, err := buildName(plugin.Name, pluginsAMD64, false)
+	amd64Build, err := buildName(opts.VersionExtractor, plugin.Name, pluginsAMD64, false)
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +453,7 @@ func handlePlugin(ctx context.Context, cfg *config, plugin *registry.Plugin, oci
 	}
 
 	// Get the name of the build object for the arm64 architecture.
-	arm64Build, err := buildName(plugin.Name, pluginsARM64, false)
+	arm64Build, err := buildName(opts.VersionExtractor, plugin.Name, pluginsARM64, false)
 	if err != nil {
 		return nil, err
 	}
@@ -242,20 +463,30 @@ func handlePlugin(ctx context.Context, cfg *config, plugin *registry.Plugin, oci
 		platforms = append(platforms, arm64Platform)
 	}
 
-	if arm64Build == "" && amd64Build == "" {
+	filepaths, platforms = excludePlatforms(filepaths, platforms, opts.ExcludedPlatforms)
+	if len(filepaths) == 0 {
 		return nil, nil
 	}
 
+	var missingPlatforms, referrers []string
+	if opts.VerifyArchitecture {
+		filepaths, platforms, missingPlatforms, err = verifyPlatforms(filepaths, platforms, opts.PartialOK)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	sepString := strings.Repeat("#", 15)
 	klog.Infof("%s %s %s", sepString, plugin.Name, sepString)
 
 	// Extract version from build object.
-	klog.Infof("generating plugin's config layer")
+	klog.V(2).Infof("generating plugin's config layer")
 
-	version, tags, err = versionAndTags(plugin.Name, filepath.Base(filepaths[0]), devTag)
+	version, tags, err = buildsVersionAndTags(opts, plugin.Name, filepaths)
 	if err != nil {
 		return nil, err
 	}
+	tags = partialPushTags(tags, missingPlatforms)
 
 	if infoP == nil {
 		klog.Warningf("no config layer generated for plugin %q: the plugins has not been build for the current platform %q", plugin.Name, currentPlatform())
@@ -268,24 +499,65 @@ func handlePlugin(ctx context.Context, cfg *config, plugin *registry.Plugin, oci
 		return nil, err
 	}
 
-	klog.Infof("pushing plugin to remote repo with ref %q and tags %q", ref, tags)
-	pusher := ocipusher.NewPusher(ociClient, false, nil)
-	res, err := pusher.Push(ctx, oci.Plugin, ref,
-		ocipusher.WithTags(tags...),
-		ocipusher.WithFilepathsAndPlatforms(filepaths, platforms),
-		ocipusher.WithArtifactConfig(*configLayer),
-		ocipusher.WithAnnotationSource(cfg.pluginsRepo))
+	// The latest tag is moved once the version is fully pushed, so that it
+	// never points to partial content.
+	versionTags, latestTag := splitLatestTag(tags, opts.LatestTag)
+
+	files, err := buildFiles(filepaths, platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	if alreadyPushed(ctx, ociClient, ref, versionTags, files) {
+		// a previous run may have failed before pushing the attachments or
+		// moving the latest tag, and files may have been attached since
+		if err := pushMissingAttachments(ctx, ociClient, ref, versionTags[len(versionTags)-1], filepaths, platforms,
+			opts.Attachments); err != nil {
+			opts.Metrics.recordFailed()
+			return nil, &PushError{Name: plugin.Name, Ref: ref, Err: err}
+		}
+		if latestTag != "" {
+			if err := recoverLatestTag(ctx, ociClient, ref, versionTags[len(versionTags)-1], version, latestTag); err != nil {
+				opts.Metrics.recordFailed()
+				return nil, &PushError{Name: plugin.Name, Ref: ref, Err: err}
+			}
+		}
+		opts.Metrics.recordSkipped()
+		return nil, nil
+	}
+
+	klog.Infof("pushing plugin to remote repo with ref %q and tags %q", ref, versionTags)
+	res, err := pushArtifact(ctx, ociClient, oci.Plugin, ref, versionTags, filepaths, platforms, configLayer,
+		cfg.pluginsRepo, opts.PluginMediaTypes)
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred while pushing plugin %q: %w", plugin.Name, err)
+		opts.Metrics.recordFailed()
+		return nil, &PushError{Name: plugin.Name, Ref: ref, Err: err}
 	}
+	if res != nil {
+		referrers, err = pushAttachments(ctx, ociClient, ref, res.Digest, filepaths, platforms, opts.Attachments)
+		if err != nil {
+			opts.Metrics.recordFailed()
+			return nil, &PushError{Name: plugin.Name, Ref: ref, Err: err}
+		}
+		if latestTag != "" {
+			if err := moveLatestTag(ctx, ociClient, ref, res.Digest, latestTag); err != nil {
+				opts.Metrics.recordFailed()
+				return nil, &PushError{Name: plugin.Name, Ref: ref, Err: err}
+			}
+		}
+	}
+	opts.Metrics.recordPushed(filepaths)
 	if res != nil {
 		metadata = append(metadata, registry.ArtifactPushMetadata{
 			registry.RepositoryMetadata{
 				Ref: ref,
 			},
 			registry.ArtifactMetadata{
-				Digest: res.Digest,
-				Tags:   tags,
+				Digest:           res.Digest,
+				Tags:             tags,
+				MissingPlatforms: missingPlatforms,
+				Referrers:        referrers,
+				Files:            files,
 			},
 		})
 	}
@@ -293,12 +565,49 @@ func handlePlugin(ctx context.Context, cfg *config, plugin *registry.Plugin, oci
 	return metadata, nil
 }
 
+// verifyPlatforms checks that the binaries of each build match the architecture
+// of its platform. If partialOK is true, the builds failing the check are filtered
+// out and their platforms returned as missing, unless none passes it.
+func verifyPlatforms(filepaths, platforms []string, partialOK bool) ([]string, []string, []string, error) {
+	var resFilepaths, resPlatforms, missing []string
+	var err error
+	for i, fp := range filepaths {
+		if err = verifyBuildArchitecture(fp, platforms[i]); err != nil {
+			if !partialOK {
+				return nil, nil, nil, err
+			}
+			klog.Errorf("skipping build %q: %v", fp, err)
+			missing = append(missing, platforms[i])
+			continue
+		}
+		resFilepaths = append(resFilepaths, fp)
+		resPlatforms = append(resPlatforms, platforms[i])
+	}
+	if len(resFilepaths) == 0 && err != nil {
+		return nil, nil, nil, err
+	}
+	return resFilepaths, resPlatforms, missing, nil
+}
+
+// partialPushTags returns the tags to push a plugin build with, given the
+// platforms missing from it. Partial pushes only get the most specific tag, so
+// that the floating major, minor and latest tags keep pointing to a version
+// available for all the platforms.
+func partialPushTags(tags, missingPlatforms []string) []string {
+	if len(missingPlatforms) == 0 || len(tags) <= 1 {
+		return tags
+	}
+	klog.Warningf("platforms %q are missing, only pushing tag %q", missingPlatforms, tags[len(tags)-1])
+	return tags[len(tags)-1:]
+}
+
 // handleRule for a given plugin it checks if there exists rulesfiles in the given folder, and
 // if found packs them as an OCI artifact and pushes it to the registry.
 func handleRule(ctx context.Context, cfg *config, plugin *registry.Plugin,
-	ociClient remote.Client, rulesfiles, devTag string) ([]registry.ArtifactPushMetadata, error) {
+	ociClient remote.Client, opts *UpdateOptions) ([]registry.ArtifactPushMetadata, error) {
+	rulesfiles := opts.RulesfilesPath
 	var err error
-	var filepaths, tags []string
+	var filepaths, tags, referrers []string
 	var version string
 
 	// Build the reference for the artifact.
@@ -308,7 +617,7 @@ func handleRule(ctx context.Context, cfg *config, plugin *registry.Plugin,
 	metadata := []registry.ArtifactPushMetadata{}
 
 	// Get the name of the build object for the amd64 architecture.
-	rulesfileBuild, err := buildName(plugin.Name, rulesfiles, true)
+	rulesfileBuild, err := buildName(opts.VersionExtractor, plugin.Name, rulesfiles, true)
 	if err != nil {
 		return nil, err
 	}
@@ -322,9 +631,9 @@ func handleRule(ctx context.Context, cfg *config, plugin *registry.Plugin,
 	sepString := strings.Repeat("#", 15)
 	klog.Infof("%s %s %s", sepString, rulesfileNameFromPlugin(plugin.Name), sepString)
 
-	klog.Infof("generating rulesfile's config layer")
+	klog.V(2).Infof("generating rulesfile's config layer")
 
-	version, tags, err = versionAndTags(plugin.Name, filepath.Base(filepaths[0]), devTag)
+	version, tags, err = versionAndTags(opts.VersionExtractor, plugin.Name, filepath.Base(filepaths[0]), opts.DevTag, opts.LatestTag)
 	if err != nil {
 		return nil, err
 	}
@@ -335,25 +644,65 @@ func handleRule(ctx context.Context, cfg *config, plugin *registry.Plugin,
 		return nil, err
 	}
 
-	klog.Infof("pushing rulesfile to remote repo with ref %q and tags %q", ref, tags)
-	pusher := ocipusher.NewPusher(ociClient, false, nil)
-	res, err := pusher.Push(ctx, oci.Rulesfile, ref,
-		ocipusher.WithTags(tags...),
-		ocipusher.WithFilepaths(filepaths),
-		ocipusher.WithArtifactConfig(*configLayer),
-		ocipusher.WithAnnotationSource(cfg.pluginsRepo))
+	// The latest tag is moved once the version is fully pushed, so that it
+	// never points to partial content.
+	versionTags, latestTag := splitLatestTag(tags, opts.LatestTag)
 
+	files, err := buildFiles(filepaths, nil)
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred while pushing rulesfile %q: %w", plugin.Name, err)
+		return nil, err
+	}
+
+	if alreadyPushed(ctx, ociClient, ref, versionTags, files) {
+		// a previous run may have failed before pushing the attachments or
+		// moving the latest tag, and files may have been attached since
+		if err := pushMissingAttachments(ctx, ociClient, ref, versionTags[len(versionTags)-1], filepaths, nil,
+			opts.Attachments); err != nil {
+			opts.Metrics.recordFailed()
+			return nil, &PushError{Name: plugin.Name, Ref: ref, Rulesfile: true, Err: err}
+		}
+		if latestTag != "" {
+			if err := recoverLatestTag(ctx, ociClient, ref, versionTags[len(versionTags)-1], version, latestTag); err != nil {
+				opts.Metrics.recordFailed()
+				return nil, &PushError{Name: plugin.Name, Ref: ref, Rulesfile: true, Err: err}
+			}
+		}
+		opts.Metrics.recordSkipped()
+		return nil, nil
+	}
+
+	klog.Infof("pushing rulesfile to remote repo with ref %q and tags %q", ref, versionTags)
+	res, err := pushArtifact(ctx, ociClient, oci.Rulesfile, ref, versionTags, filepaths, nil, configLayer,
+		cfg.pluginsRepo, opts.RulesfileMediaTypes)
+
+	if err != nil {
+		opts.Metrics.recordFailed()
+		return nil, &PushError{Name: plugin.Name, Ref: ref, Rulesfile: true, Err: err}
 	}
+	if res != nil {
+		referrers, err = pushAttachments(ctx, ociClient, ref, res.Digest, filepaths, nil, opts.Attachments)
+		if err != nil {
+			opts.Metrics.recordFailed()
+			return nil, &PushError{Name: plugin.Name, Ref: ref, Rulesfile: true, Err: err}
+		}
+		if latestTag != "" {
+			if err := moveLatestTag(ctx, ociClient, ref, res.Digest, latestTag); err != nil {
+				opts.Metrics.recordFailed()
+				return nil, &PushError{Name: plugin.Name, Ref: ref, Rulesfile: true, Err: err}
+			}
+		}
+	}
+	opts.Metrics.recordPushed(filepaths)
 	if res != nil {
 		metadata = append(metadata, registry.ArtifactPushMetadata{
 			registry.RepositoryMetadata{
 				Ref: ref,
 			},
 			registry.ArtifactMetadata{
-				Digest: res.Digest,
-				Tags:   tags,
+				Digest:    res.Digest,
+				Tags:      tags,
+				Referrers: referrers,
+				Files:     files,
 			},
 		})
 	}
@@ -361,63 +710,94 @@ func handleRule(ctx context.Context, cfg *config, plugin *registry.Plugin,
 	return metadata, nil
 }
 
+// excludePlatforms filters out the build objects for the given excluded platforms.
+func excludePlatforms(filepaths, platforms, excluded []string) ([]string, []string) {
+	var resFilepaths, resPlatforms []string
+	for i, platform := range platforms {
+		if slices.Contains(excluded, platform) {
+			klog.V(2).Infof("skipping build %q: platform %q is excluded", filepaths[i], platform)
+			continue
+		}
+		resFilepaths = append(resFilepaths, filepaths[i])
+		resPlatforms = append(resPlatforms, platform)
+	}
+	return resFilepaths, resPlatforms
+}
+
 func rulesfileNameFromPlugin(name string) string {
 	return fmt.Sprintf("%s%s", name, common.RulesArtifactSuffix)
 }
 
 // buildName returns the name of the build object for a given object name.
-// It searches in the given folder if build artifact exists that has the same
-// prefix as the object. If we are searching for a rulesfiles object then, the
-// rulefiles variable needs to be set to true.
-func buildName(objName, dirPath string, rulesfile bool) (string, error) {
+// It searches in the given folder for a build object of the object, as
+// matched by the extractor (the filename one if nil). If we are searching for
+// a rulesfiles object then, the rulefiles variable needs to be set to true.
+func buildName(extractor VersionExtractor, objName, dirPath string, rulesfile bool) (string, error) {
 	if dirPath == "" {
 		return "", nil
 	}
+	if extractor == nil {
+		extractor = filenameVersionExtractor{}
+	}
 	// Get the entries
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return "", fmt.Errorf("unable to get build object for %q: %w", objName, err)
 	}
 
+	if rulesfile {
+		objName = rulesfileNameFromPlugin(objName)
+	}
+
 	for _, entry := range entries {
-		name := entry.Name()
-		if rulesfile {
-			if strings.HasPrefix(name, objName+"-rules") {
-				return name, nil
-			}
-		} else {
-			if strings.HasPrefix(name, objName) && !strings.Contains(name, "rules") {
-				return name, nil
-			}
+		if extractor.IsBuildOf(objName, entry.Name()) {
+			return entry.Name(), nil
 		}
 	}
 	return "", nil
 }
 
-func versionAndTags(pluginName, buildName, devTag string) (string, []string, error) {
-	var version string
-	var tags []string
-	var err error
-
-	if strings.Contains(buildName, "-rules") {
-		version = strings.TrimPrefix(buildName, pluginName+"-rules-")
-		version = strings.TrimSuffix(version, archiveSuffix)
-	} else {
-		regexPattern := `\b-linux\S*`
-		regex := regexp.MustCompile(regexPattern)
-		// Replace all substrings starting with "linux" with an empty string
-		version = regex.ReplaceAllString(buildName, "")
-		version = strings.TrimPrefix(version, pluginName+"-")
+// versionAndTags returns the version of a build object and the tags it must be pushed with, including the
+// latestTag moving tag (DefaultLatestTag if empty) for stable versions. Versions are normalized, so that
+// equivalent representations (e.g. v1.2.3 and 1.2.3) result in the same version.
+func versionAndTags(extractor VersionExtractor, pluginName, buildName, devTag, latestTag string) (string, []string, error) {
+	if extractor == nil {
+		extractor = filenameVersionExtractor{}
 	}
-
-	if devTag != "" {
-		return version, append(tags, devTag), nil
+	version, err := extractor.ExtractVersion(pluginName, buildName)
+	if err != nil {
+		return "", nil, &VersionParseError{BuildName: buildName, Err: err}
 	}
 
 	// If not a dev version, we expect to but be semver compatible.
-	semVer, err := semver.Parse(version)
+	semVer, err := semver.ParseTolerant(version)
+	if devTag != "" {
+		if err == nil {
+			version = semVer.String()
+		}
+		return version, []string{devTag}, nil
+	}
 	if err != nil {
-		return "", nil, fmt.Errorf("unable to parse version for %q: %w", buildName, err)
+		return "", nil, &VersionParseError{BuildName: buildName, Err: err}
+	}
+	return semVer.String(), tagsFromVersion(&semVer, latestTag), nil
+}
+
+// buildsVersionAndTags is the same as versionAndTags, but for all the build objects of a plugin, which are
+// required to have the same version.
+func buildsVersionAndTags(opts *UpdateOptions, pluginName string, filepaths []string) (string, []string, error) {
+	var version string
+	var tags []string
+	for i, fp := range filepaths {
+		v, t, err := versionAndTags(opts.VersionExtractor, pluginName, filepath.Base(fp), opts.DevTag, opts.LatestTag)
+		if err != nil {
+			return "", nil, err
+		}
+		if i > 0 && v != version {
+			return "", nil, fmt.Errorf("build objects of plugin %q have different versions: %q (%s) and %q (%s)",
+				pluginName, version, filepath.Base(filepaths[0]), v, filepath.Base(fp))
+		}
+		version, tags = v, t
 	}
-	return version, tagsFromVersion(&semVer), nil
+	return version, tags, nil
 }
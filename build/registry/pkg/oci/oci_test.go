@@ -0,0 +1,1355 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/falcoctl/pkg/oci"
+	"github.com/falcosecurity/falcoctl/pkg/oci/repository"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+func TestBuildNameOverlappingPlugins(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"k8saudit-eks-0.5.0-linux-x86_64.tar.gz",
+		"k8saudit-eks-rules-0.5.0.tar.gz",
+		"k8saudit-gke-0.1.0-linux-x86_64.tar.gz",
+		"k8saudit-rules-0.10.1.tar.gz",
+		"k8saudit-0.10.1-linux-x86_64.tar.gz",
+		"rulesgen-1.0.0-linux-x86_64.tar.gz",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+
+	name, err := buildName(nil, "k8saudit", dir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "k8saudit-0.10.1-linux-x86_64.tar.gz", name)
+
+	name, err = buildName(nil, "k8saudit", dir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "k8saudit-rules-0.10.1.tar.gz", name)
+
+	name, err = buildName(nil, "k8saudit-eks", dir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "k8saudit-eks-0.5.0-linux-x86_64.tar.gz", name)
+
+	name, err = buildName(nil, "k8saudit-eks", dir, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "k8saudit-eks-rules-0.5.0.tar.gz", name)
+
+	// plugins with "rules" in their name are not mistaken for rulesfiles
+	name, err = buildName(nil, "rulesgen", dir, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "rulesgen-1.0.0-linux-x86_64.tar.gz", name)
+
+	name, err = buildName(nil, "k8saudit-gke", dir, true)
+	assert.NoError(t, err)
+	assert.Empty(t, name)
+
+	name, err = buildName(nil, "k8s", dir, false)
+	assert.NoError(t, err)
+	assert.Empty(t, name)
+}
+
+func TestVersionAndTagsParseError(t *testing.T) {
+	_, _, err := versionAndTags(nil, "k8saudit", "k8saudit-latest-linux-x86_64.tar.gz", "", "")
+	var parseErr *VersionParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "k8saudit-latest-linux-x86_64.tar.gz", parseErr.BuildName)
+
+	version, tags, err := versionAndTags(nil, "k8saudit", "k8saudit-0.10.1-linux-x86_64.tar.gz", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.10.1", version)
+	assert.Equal(t, []string{"latest", "0", "0.10", "0.10.1"}, tags)
+}
+
+func TestVersionAndTagsLatestTag(t *testing.T) {
+	_, tags, err := versionAndTags(nil, "k8saudit", "k8saudit-0.10.1-linux-x86_64.tar.gz", "", "stable")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stable", "0", "0.10", "0.10.1"}, tags)
+
+	// release candidates and devel versions have no moving tag
+	_, tags, err = versionAndTags(nil, "k8saudit", "k8saudit-0.11.0-rc1-linux-x86_64.tar.gz", "", "stable")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0.11.0-rc1"}, tags)
+	_, tags, err = versionAndTags(nil, "k8saudit", "k8saudit-0.11.0-linux-x86_64.tar.gz", "dev", "stable")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev"}, tags)
+
+	assert.NoError(t, validateLatestTag(""))
+	assert.NoError(t, validateLatestTag("current"))
+	assert.ErrorContains(t, validateLatestTag("1"), "digit")
+	assert.ErrorContains(t, validateLatestTag("stable:1"), "not a valid OCI tag")
+}
+
+func TestVersionNormalization(t *testing.T) {
+	for _, buildName := range []string{
+		"k8saudit-0.10.1-linux-x86_64.tar.gz",
+		"k8saudit-v0.10.1-linux-aarch64.tar.gz",
+		"k8saudit-rules-v0.10.1.tar.gz",
+	} {
+		version, tags, err := versionAndTags(nil, "k8saudit", buildName, "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "0.10.1", version, buildName)
+		assert.Equal(t, []string{"latest", "0", "0.10", "0.10.1"}, tags, buildName)
+	}
+
+	assert.True(t, filenameVersionExtractor{}.IsBuildOf("k8saudit", "k8saudit-v0.10.1-linux-x86_64.tar.gz"))
+	assert.False(t, filenameVersionExtractor{}.IsBuildOf("k8saudit", "k8saudit-vpc-0.1.0-linux-x86_64.tar.gz"))
+
+	// equivalent versions of the builds of a plugin unify
+	opts := &UpdateOptions{}
+	version, _, err := buildsVersionAndTags(opts, "k8saudit", []string{
+		"amd64/k8saudit-0.10.1-linux-x86_64.tar.gz",
+		"arm64/k8saudit-v0.10.1-linux-aarch64.tar.gz",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "0.10.1", version)
+
+	_, _, err = buildsVersionAndTags(opts, "k8saudit", []string{
+		"amd64/k8saudit-0.10.1-linux-x86_64.tar.gz",
+		"arm64/k8saudit-0.10.2-linux-aarch64.tar.gz",
+	})
+	assert.ErrorContains(t, err, "different versions")
+}
+
+func TestVersionExtractors(t *testing.T) {
+	extractor, err := NewVersionExtractor(VersionExtractorFilename, "")
+	assert.NoError(t, err)
+	version, err := extractor.ExtractVersion("k8saudit", "k8saudit-0.10.1-linux-x86_64.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.10.1", version)
+	version, err = extractor.ExtractVersion("k8saudit", "k8saudit-rules-0.10.1.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.10.1", version)
+
+	extractor, err = NewVersionExtractor(VersionExtractorRegexp, `_v(\d+\.\d+\.\d+[^_]*)_`)
+	assert.NoError(t, err)
+	version, tags, err := versionAndTags(extractor, "k8saudit", "k8saudit_v0.11.0-rc1_linux_amd64.tar.gz", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.11.0-rc1", version)
+	assert.Equal(t, []string{"0.11.0-rc1"}, tags)
+
+	_, _, err = versionAndTags(extractor, "k8saudit", "k8saudit-0.10.1-linux-x86_64.tar.gz", "", "")
+	var parseErr *VersionParseError
+	assert.ErrorAs(t, err, &parseErr)
+
+	assert.True(t, extractor.IsBuildOf("k8saudit", "k8saudit_v0.11.0-rc1_linux_amd64.tar.gz"))
+	assert.False(t, extractor.IsBuildOf("k8saudit", "k8saudit-eks_v0.11.0_linux_amd64.tar.gz"))
+	assert.False(t, extractor.IsBuildOf("k8saudit", "k8saudit-0.10.1-linux-x86_64.tar.gz"))
+
+	_, err = NewVersionExtractor(VersionExtractorRegexp, `_v\d+_`)
+	assert.ErrorContains(t, err, "capturing group")
+	_, err = NewVersionExtractor("path", "")
+	assert.ErrorContains(t, err, "unknown version extraction strategy")
+}
+
+func TestDoUpdateOCIRegistryVersionExtractor(t *testing.T) {
+	reg, srv := newTestRegistry(t)
+	setTestRegistryEnv(t, srv.Listener.Addr().String())
+
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: k8saudit
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit/rules
+`)
+	extractor, err := NewVersionExtractor(VersionExtractorRegexp, `_v(\d+\.\d+\.\d+(?:-[0-9A-Za-z]+)?)[_.]`)
+	assert.NoError(t, err)
+
+	// the builds of other objects sharing a prefix are not picked
+	plugins, rulesfiles := t.TempDir(), t.TempDir()
+	writeTestBuild(t, filepath.Join(plugins, "k8saudit-eks_v0.2.0_linux_amd64.tar.gz"), map[string][]byte{
+		"libk8saudit-eks.so": nil,
+	})
+	writeTestBuild(t, filepath.Join(plugins, "k8saudit_v0.11.0-rc1_linux_amd64.tar.gz"), map[string][]byte{
+		"README.md": nil,
+	})
+	writeTestBuild(t, filepath.Join(rulesfiles, "k8saudit-eks-rules_v0.2.0.tar.gz"), map[string][]byte{
+		"k8saudit_eks_rules.yaml": []byte("- required_engine_version: 15\n"),
+	})
+	writeTestBuild(t, filepath.Join(rulesfiles, "k8saudit-rules_v0.11.0-rc1.tar.gz"), map[string][]byte{
+		"k8saudit_rules.yaml": []byte("- required_engine_version: 15\n- required_plugin_versions:\n  - name: k8saudit\n    version: 0.11.0-rc1\n"),
+	})
+
+	status, err := DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: plugins,
+		PluginsARM64Path: t.TempDir(),
+		RulesfilesPath:   rulesfiles,
+		Client:           srv.Client(),
+		VersionExtractor: extractor,
+		SkipPlugins:      true,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		assert.Equal(t, []string{"0.11.0-rc1"}, status[0].Artifact.Tags)
+		if assert.Len(t, status[0].Artifact.Files, 1) {
+			assert.Equal(t, "k8saudit-rules_v0.11.0-rc1.tar.gz", status[0].Artifact.Files[0].Name)
+		}
+	}
+	assert.Contains(t, reg.manifests, "/v2/falcosecurity/plugins/ruleset/k8saudit:0.11.0-rc1")
+
+	// the plugin build is picked too, but is not a valid plugin
+	_, err = DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: plugins,
+		PluginsARM64Path: t.TempDir(),
+		RulesfilesPath:   rulesfiles,
+		Client:           srv.Client(),
+		VersionExtractor: extractor,
+	})
+	assert.ErrorContains(t, err, "no plugin found in archive")
+	assert.ErrorContains(t, err, "k8saudit_v0.11.0-rc1_linux_amd64.tar.gz")
+}
+
+func TestUpdateDigestsLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digests.yaml")
+	ref := "ghcr.io/falcosecurity/plugins/plugin/k8saudit"
+
+	assert.NoError(t, UpdateDigestsLock(path, registry.ArtifactsPushStatus{
+		{
+			Repository: registry.RepositoryMetadata{Ref: ref},
+			Artifact:   registry.ArtifactMetadata{Digest: "sha256:aaa", Tags: []string{"latest", "0", "0.10", "0.10.0"}},
+		},
+	}))
+	assert.NoError(t, UpdateDigestsLock(path, registry.ArtifactsPushStatus{
+		{
+			Repository: registry.RepositoryMetadata{Ref: ref},
+			Artifact:   registry.ArtifactMetadata{Digest: "sha256:bbb", Tags: []string{"latest", "0", "0.10", "0.10.1"}},
+		},
+	}))
+
+	lock, err := LoadDigestsLock(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]string{
+		ref: {"0.10.0": "sha256:aaa", "0.10.1": "sha256:bbb"},
+	}, lock.Digests)
+}
+
+func TestBuildFiles(t *testing.T) {
+	files, err := buildFiles([]string{"testdata/rules-valid.yaml", "testdata/rules-malformed.yaml"}, []string{amd64Platform})
+	assert.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, registry.FileMetadata{
+			Name:     "rules-valid.yaml",
+			Platform: amd64Platform,
+			SHA256:   "c84c289e1e8f210af0ea8ab8d030cdcbad02311e6986242a821efae646d5f243",
+		}, files[0])
+		assert.Equal(t, "rules-malformed.yaml", files[1].Name)
+		assert.Empty(t, files[1].Platform)
+	}
+
+	_, err = buildFiles([]string{"testdata/missing.tar.gz"}, nil)
+	assert.Error(t, err)
+}
+
+func TestExcludePlatforms(t *testing.T) {
+	filepaths := []string{"amd64/k8saudit-0.10.1-linux-x86_64.tar.gz", "arm64/k8saudit-0.10.1-linux-aarch64.tar.gz"}
+	platforms := []string{amd64Platform, arm64Platform}
+
+	f, p := excludePlatforms(filepaths, platforms, []string{arm64Platform})
+	assert.Equal(t, filepaths[:1], f)
+	assert.Equal(t, platforms[:1], p)
+
+	f, p = excludePlatforms(filepaths, platforms, nil)
+	assert.Equal(t, filepaths, f)
+	assert.Equal(t, platforms, p)
+
+	f, p = excludePlatforms(filepaths, platforms, []string{amd64Platform, arm64Platform})
+	assert.Empty(t, f)
+	assert.Empty(t, p)
+}
+
+func TestNewOCIClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	get := func(cfg *config, opts ClientOptions) error {
+		client, err := newOCIClient(cfg, opts)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	// the self-signed certificate is rejected with the system roots only
+	assert.Error(t, get(&config{}, ClientOptions{}))
+	assert.NoError(t, get(&config{registryCAFile: caFile}, ClientOptions{}))
+	assert.NoError(t, get(&config{registryInsecure: true}, ClientOptions{}))
+	assert.NoError(t, get(&config{}, ClientOptions{CAFile: caFile}))
+	assert.NoError(t, get(&config{}, ClientOptions{Insecure: true}))
+
+	_, err := newOCIClient(&config{registryCAFile: filepath.Join(t.TempDir(), "missing.pem")}, ClientOptions{})
+	assert.Error(t, err)
+
+	invalidFile := filepath.Join(t.TempDir(), "invalid.pem")
+	assert.NoError(t, os.WriteFile(invalidFile, []byte("not a certificate"), 0o600))
+	_, err = newOCIClient(&config{registryCAFile: invalidFile}, ClientOptions{})
+	assert.Error(t, err)
+}
+
+// testELFHeader returns a minimal 64-bit little-endian ELF shared object
+// header for the given machine.
+func testELFHeader(t *testing.T, machine elf.Machine) []byte {
+	hdr := elf.Header64{
+		Type:    uint16(elf.ET_DYN),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  uint16(binary.Size(elf.Header64{})),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	assert.NoError(t, binary.Write(&buf, binary.LittleEndian, &hdr))
+	return buf.Bytes()
+}
+
+// writeTestBuild writes a build archive containing the given files.
+func writeTestBuild(t *testing.T, path string, files map[string][]byte) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, data := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+}
+
+func TestVerifyBuildArchitecture(t *testing.T) {
+	dir := t.TempDir()
+	amd64Build := filepath.Join(dir, "dummy-0.1.0-linux-x86_64.tar.gz")
+	writeTestBuild(t, amd64Build, map[string][]byte{
+		"libdummy.so": testELFHeader(t, elf.EM_X86_64),
+		"README.md":   []byte("# dummy"),
+		"LICENSE":     []byte("Apache-2.0"),
+	})
+	arm64Build := filepath.Join(dir, "dummy-0.1.0-linux-aarch64.tar.gz")
+	writeTestBuild(t, arm64Build, map[string][]byte{
+		"libdummy.so": testELFHeader(t, elf.EM_AARCH64),
+	})
+
+	assert.NoError(t, verifyBuildArchitecture(amd64Build, amd64Platform))
+	assert.NoError(t, verifyBuildArchitecture(arm64Build, arm64Platform))
+	assert.ErrorContains(t, verifyBuildArchitecture(amd64Build, arm64Platform), `built for "amd64"`)
+	assert.ErrorContains(t, verifyBuildArchitecture(arm64Build, amd64Platform), `built for "arm64"`)
+
+	// binaries of unknown architectures can't be verified
+	unknownBuild := filepath.Join(dir, "dummy-0.1.0-linux-riscv64.tar.gz")
+	writeTestBuild(t, unknownBuild, map[string][]byte{
+		"libdummy.so": testELFHeader(t, elf.EM_RISCV),
+	})
+	assert.ErrorContains(t, verifyBuildArchitecture(unknownBuild, amd64Platform), `unknown architecture "EM_RISCV"`)
+}
+
+func TestVerifyPlatformsPartialOK(t *testing.T) {
+	dir := t.TempDir()
+	amd64Build := filepath.Join(dir, "dummy-0.1.0-linux-x86_64.tar.gz")
+	writeTestBuild(t, amd64Build, map[string][]byte{
+		"libdummy.so": testELFHeader(t, elf.EM_X86_64),
+	})
+	// the arm64 build is broken, and contains an amd64 binary
+	arm64Build := filepath.Join(dir, "dummy-0.1.0-linux-aarch64.tar.gz")
+	writeTestBuild(t, arm64Build, map[string][]byte{
+		"libdummy.so": testELFHeader(t, elf.EM_X86_64),
+	})
+	filepaths := []string{amd64Build, arm64Build}
+	platforms := []string{amd64Platform, arm64Platform}
+
+	_, _, _, err := verifyPlatforms(filepaths, platforms, false)
+	assert.ErrorContains(t, err, `built for "amd64"`)
+
+	resFilepaths, resPlatforms, missing, err := verifyPlatforms(filepaths, platforms, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{amd64Build}, resFilepaths)
+	assert.Equal(t, []string{amd64Platform}, resPlatforms)
+	assert.Equal(t, []string{arm64Platform}, missing)
+
+	// nothing to push if all the platforms fail
+	_, _, _, err = verifyPlatforms([]string{arm64Build}, []string{arm64Platform}, true)
+	assert.ErrorContains(t, err, `built for "amd64"`)
+
+	err = missingPlatformsError([]registry.ArtifactPushMetadata{
+		{Repository: registry.RepositoryMetadata{Ref: "ghcr.io/falcosecurity/plugins/plugin/alpha"},
+			Artifact: registry.ArtifactMetadata{Digest: "sha256:1"}},
+		{Repository: registry.RepositoryMetadata{Ref: "ghcr.io/falcosecurity/plugins/plugin/dummy"},
+			Artifact: registry.ArtifactMetadata{Digest: "sha256:2", MissingPlatforms: missing}},
+	})
+	var merr *MissingPlatformsError
+	if assert.ErrorAs(t, err, &merr) {
+		assert.Equal(t, map[string][]string{"ghcr.io/falcosecurity/plugins/plugin/dummy": {arm64Platform}}, merr.Missing)
+	}
+	assert.EqualError(t, err, `artifacts pushed with missing platforms: ghcr.io/falcosecurity/plugins/plugin/dummy ["linux/arm64"]`)
+	assert.NoError(t, missingPlatformsError(nil))
+}
+
+func TestPartialPushTags(t *testing.T) {
+	tags := []string{"latest", "1", "1.2", "1.2.3"}
+	assert.Equal(t, tags, partialPushTags(tags, nil))
+	assert.Equal(t, []string{"1.2.3"}, partialPushTags(tags, []string{arm64Platform}))
+	assert.Equal(t, []string{"dev"}, partialPushTags([]string{"dev"}, []string{arm64Platform}))
+}
+
+type unreachableClient struct{}
+
+func (unreachableClient) Do(*http.Request) (*http.Response, error) {
+	return nil, errors.New("unexpected request to the OCI registry")
+}
+
+func TestDoUpdateOCIRegistryOptions(t *testing.T) {
+	setTestRegistryEnv(t, "ghcr.io")
+
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: external
+    url: https://github.com/example/external
+    authors: Example
+`)
+
+	// plugins not maintained by falcosecurity are skipped without
+	// contacting the registry
+	status, err := DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: t.TempDir(),
+		PluginsARM64Path: t.TempDir(),
+		RulesfilesPath:   t.TempDir(),
+		Client:           unreachableClient{},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+
+	_, err = DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile: filepath.Join(t.TempDir(), "missing.yaml"),
+		Client:       unreachableClient{},
+	})
+	assert.Error(t, err)
+}
+
+func TestDoUpdateOCIRegistrySkip(t *testing.T) {
+	reg, srv, registryFile, rulesfiles := newTestBetaFixture(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	// the handlers fail on missing folders, unless skipped
+	status, err := DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: missing,
+		PluginsARM64Path: missing,
+		RulesfilesPath:   missing,
+		Client:           unreachableClient{},
+		SkipRules:        true,
+	})
+	assert.ErrorContains(t, err, "unable to get build object")
+	assert.Empty(t, status)
+
+	status, err = DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: missing,
+		PluginsARM64Path: missing,
+		RulesfilesPath:   rulesfiles,
+		Client:           srv.Client(),
+		SkipPlugins:      true,
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		assert.Contains(t, status[0].Repository.Ref, "ruleset/beta")
+	}
+	assert.Contains(t, reg.manifests, "/v2/falcosecurity/plugins/ruleset/beta:latest")
+
+	status, err = DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: t.TempDir(),
+		PluginsARM64Path: t.TempDir(),
+		RulesfilesPath:   missing,
+		Client:           unreachableClient{},
+		SkipRules:        true,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+
+	_, err = DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile: registryFile,
+		Client:       unreachableClient{},
+		SkipPlugins:  true,
+		SkipRules:    true,
+	})
+	assert.ErrorContains(t, err, "can't be both skipped")
+}
+
+func TestDoUpdateOCIRegistryInvalidBuilds(t *testing.T) {
+	reg, srv := newTestRegistry(t)
+	setTestRegistryEnv(t, srv.Listener.Addr().String())
+
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: alpha
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/alpha
+  - name: beta
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta/rules
+  - name: gamma
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/gamma
+  - name: delta
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/delta
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/delta/rules
+`)
+
+	amd64, arm64, rulesfiles := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, build := range []string{
+		filepath.Join(amd64, "alpha-1.x-linux-x86_64.tar.gz"),
+		filepath.Join(rulesfiles, "beta-rules-1.2.3.4.tar.gz"),
+		filepath.Join(amd64, "gamma-0.1.0-linux-x86_64.tar.gz"),
+		filepath.Join(arm64, "gamma-0.2.0-linux-aarch64.tar.gz"),
+	} {
+		assert.NoError(t, os.WriteFile(build, nil, 0o600))
+	}
+	writeTestBuild(t, filepath.Join(rulesfiles, "delta-rules-0.1.0.tar.gz"), map[string][]byte{
+		"delta_rules.yaml": []byte("- required_engine_version: 15\n- required_plugin_versions:\n  - name: delta\n    version: 0.1.0\n"),
+	})
+	opts := UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: amd64,
+		PluginsARM64Path: arm64,
+		RulesfilesPath:   rulesfiles,
+		Client:           unreachableClient{},
+	}
+
+	// all the invalid builds are reported before pushing anything
+	_, err := DoUpdateOCIRegistry(context.Background(), &opts)
+	var invalidErr *InvalidBuildsError
+	if assert.ErrorAs(t, err, &invalidErr) && assert.Len(t, invalidErr.Errs, 3) {
+		var parseErr *VersionParseError
+		assert.ErrorAs(t, invalidErr.Errs[0], &parseErr)
+		assert.Equal(t, "alpha-1.x-linux-x86_64.tar.gz", parseErr.BuildName)
+		assert.ErrorAs(t, invalidErr.Errs[1], &parseErr)
+		assert.Equal(t, "beta-rules-1.2.3.4.tar.gz", parseErr.BuildName)
+		assert.ErrorContains(t, invalidErr.Errs[2], "different versions")
+	}
+
+	// the plugins with invalid builds can be skipped
+	opts.Client = srv.Client()
+	opts.SkipInvalidBuilds = true
+	status, err := DoUpdateOCIRegistry(context.Background(), &opts)
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		assert.Contains(t, status[0].Repository.Ref, "ruleset/delta")
+	}
+	for key := range reg.manifests {
+		assert.Contains(t, key, "/ruleset/delta")
+	}
+}
+
+func TestMatchPluginName(t *testing.T) {
+	assert.True(t, matchPluginName("k8saudit", nil))
+	assert.True(t, matchPluginName("k8saudit", []string{"k8s*"}))
+	assert.True(t, matchPluginName("k8saudit-eks", []string{"k8s*"}))
+	assert.True(t, matchPluginName("cloudtrail", []string{"k8s*", "cloud?rail"}))
+	assert.True(t, matchPluginName("json", []string{"json"}))
+	assert.False(t, matchPluginName("json", []string{"k8s*"}))
+	assert.False(t, matchPluginName("okta", []string{"k8s*", "cloud*"}))
+	assert.False(t, matchPluginName("jsonx", []string{"json"}))
+	assert.False(t, matchPluginName("k8saudit", []string{"*eks"}))
+}
+
+func TestDoUpdateOCIRegistryMatch(t *testing.T) {
+	setTestRegistryEnv(t, "ghcr.io")
+
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: k8saudit
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/k8saudit
+`)
+
+	// non-matching plugins are skipped without contacting the registry
+	status, err := DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile: registryFile,
+		Match:        []string{"cloud*"},
+		Client:       unreachableClient{},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+
+	_, err = DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile: registryFile,
+		Match:        []string{"k8s["},
+		Client:       unreachableClient{},
+	})
+	assert.ErrorContains(t, err, "invalid plugin name pattern")
+}
+
+func TestNewOCIClientUserAgent(t *testing.T) {
+	userAgents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	get := func(cfg *config, opts ClientOptions) string {
+		client, err := newOCIClient(cfg, opts)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/", http.NoBody)
+		assert.NoError(t, err)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return <-userAgents
+	}
+
+	assert.Equal(t, "my-syncer/1.0", get(&config{registryUserAgent: "my-syncer/1.0"}, ClientOptions{}))
+	assert.Regexp(t, `^falco-plugins-oci-sync/\S+$`, get(&config{}, ClientOptions{}))
+	// the options take precedence over the environment
+	assert.Equal(t, "my-flag/2.0", get(&config{registryUserAgent: "my-syncer/1.0"}, ClientOptions{UserAgent: "my-flag/2.0"}))
+}
+
+// slowClient is a remote.Client whose requests never complete before
+// their context is done
+type slowClient struct{}
+
+func (slowClient) Do(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestDoUpdateOCIRegistryTimeout(t *testing.T) {
+	setTestRegistryEnv(t, "ghcr.io")
+
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: alpha
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/alpha
+  - name: beta
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta/rules
+  - name: gamma
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/gamma
+`)
+
+	// alpha has nothing to push, while pushing the beta rulesfile stalls
+	rulesfiles := t.TempDir()
+	writeTestBetaRules(t, rulesfiles, "0.1.0", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := DoUpdateOCIRegistry(ctx, &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: t.TempDir(),
+		PluginsARM64Path: t.TempDir(),
+		RulesfilesPath:   rulesfiles,
+		Client:           slowClient{},
+	})
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	var ierr *InterruptedError
+	if assert.ErrorAs(t, err, &ierr) {
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, []string{"alpha"}, ierr.Completed)
+		assert.Equal(t, []string{"beta", "gamma"}, ierr.Pending)
+		assert.Contains(t, err.Error(), "run timed out")
+	}
+}
+
+// testRegistry is a minimal in-memory OCI registry, counting the uploads
+// of blobs and manifests it receives
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	types     map[string]string
+	uploads   int
+	// referrers enables the OCI Referrers API
+	referrers bool
+	// failTags is the number of times pushing a manifest with each tag fails
+	failTags map[string]int
+}
+
+func newTestRegistry(t *testing.T) (*testRegistry, *httptest.Server) {
+	reg := &testRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewTLSServer(reg)
+	t.Cleanup(srv.Close)
+	return reg, srv
+}
+
+// setTestRegistryEnv sets the credentials and the repository used to push
+// to the registry at addr
+func setTestRegistryEnv(t *testing.T, addr string) {
+	t.Setenv(RegistryToken, "token")
+	t.Setenv(RegistryUser, "falcosecurity")
+	t.Setenv(RegistryOCI, addr)
+	t.Setenv(RepoGithub, "falcosecurity/plugins")
+}
+
+// writeTestRegistryFile writes a registry.yaml with the given content, and
+// returns its path
+func writeTestRegistryFile(t *testing.T, content string) string {
+	registryFile := filepath.Join(t.TempDir(), "registry.yaml")
+	assert.NoError(t, os.WriteFile(registryFile, []byte(content), 0o600))
+	return registryFile
+}
+
+// writeTestBetaRules writes the build of the given version of the beta
+// rulesfile in dir, with extra appended to its rules.
+func writeTestBetaRules(t *testing.T, dir, version, extra string) {
+	writeTestBuild(t, filepath.Join(dir, "beta-rules-"+version+".tar.gz"), map[string][]byte{
+		"beta_rules.yaml": []byte("- required_engine_version: 15\n- required_plugin_versions:\n  - name: beta\n    version: " + version + "\n" + extra),
+	})
+}
+
+// newTestBetaFixture starts a test registry and sets the environment to
+// push to it. It returns the path of a registry.yaml listing the beta
+// plugin, and the folder containing the build of its 0.1.0 rulesfile.
+func newTestBetaFixture(t *testing.T) (*testRegistry, *httptest.Server, string, string) {
+	reg, srv := newTestRegistry(t)
+	setTestRegistryEnv(t, srv.Listener.Addr().String())
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: beta
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta/rules
+`)
+	rulesfiles := t.TempDir()
+	writeTestBetaRules(t, rulesfiles, "0.1.0", "")
+	return reg, srv, registryFile, rulesfiles
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		if r.Method == http.MethodPost {
+			w.Header().Set("Location", path+"upload")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		reg.blobs[r.URL.Query().Get("digest")] = data
+		reg.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		dgst := path[strings.LastIndex(path, "/")+1:]
+		reg.serve(w, r, dgst, reg.blobs[dgst], "application/octet-stream")
+	case strings.Contains(path, "/referrers/"):
+		if !reg.referrers {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		repo, subject := path[:strings.LastIndex(path, "/referrers/")], path[strings.LastIndex(path, "/")+1:]
+		index := v1.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: v1.MediaTypeImageIndex, Manifests: []v1.Descriptor{}}
+		for key, data := range reg.manifests {
+			var manifest v1.Manifest
+			if !strings.HasPrefix(key, repo+":sha256:") || json.Unmarshal(data, &manifest) != nil ||
+				manifest.Subject == nil || manifest.Subject.Digest.String() != subject {
+				continue
+			}
+			index.Manifests = append(index.Manifests, v1.Descriptor{MediaType: v1.MediaTypeImageManifest,
+				Digest: digest.FromBytes(data), Size: int64(len(data)), ArtifactType: manifest.ArtifactType})
+		}
+		data, _ := json.Marshal(index)
+		reg.serve(w, r, digest.FromBytes(data).String(), data, v1.MediaTypeImageIndex)
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		repo, ref := path[:i], path[i+len("/manifests/"):]
+		if r.Method == http.MethodPut {
+			if reg.failTags[ref] > 0 {
+				reg.failTags[ref]--
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			dgst := digest.FromBytes(data).String()
+			for _, key := range []string{repo + ":" + ref, repo + ":" + dgst} {
+				reg.manifests[key] = data
+				reg.types[key] = r.Header.Get("Content-Type")
+			}
+			reg.uploads++
+			w.Header().Set("Docker-Content-Digest", dgst)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		key := repo + ":" + ref
+		data := reg.manifests[key]
+		reg.serve(w, r, digest.FromBytes(data).String(), data, reg.types[key])
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (reg *testRegistry) serve(w http.ResponseWriter, r *http.Request, dgst string, data []byte, mediaType string) {
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Docker-Content-Digest", dgst)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func TestDoUpdateOCIRegistrySkipsUpToDate(t *testing.T) {
+	reg, srv, registryFile, rulesfiles := newTestBetaFixture(t)
+	var metrics *UpdateMetrics
+	update := func() []registry.ArtifactPushMetadata {
+		metrics = &UpdateMetrics{}
+		status, err := DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+			RegistryFile:     registryFile,
+			PluginsAMD64Path: t.TempDir(),
+			PluginsARM64Path: t.TempDir(),
+			RulesfilesPath:   rulesfiles,
+			Client:           srv.Client(),
+			Metrics:          metrics,
+		})
+		assert.NoError(t, err)
+		return status
+	}
+
+	status := update()
+	assert.NotZero(t, reg.uploads)
+	files, err := buildFiles([]string{filepath.Join(rulesfiles, "beta-rules-0.1.0.tar.gz")}, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		// the pushed files are recorded along with their SHA256
+		assert.Equal(t, files, status[0].Artifact.Files)
+	}
+	info, err := os.Stat(filepath.Join(rulesfiles, "beta-rules-0.1.0.tar.gz"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, metrics.Pushed)
+	assert.Equal(t, 0, metrics.Skipped)
+	assert.Equal(t, info.Size(), metrics.BytesPushed)
+	assert.NotZero(t, metrics.Duration)
+
+	// the second run finds the same content in the registry
+	reg.uploads = 0
+	assert.Empty(t, update())
+	assert.Zero(t, reg.uploads)
+	assert.Equal(t, 0, metrics.Pushed)
+	assert.Equal(t, 1, metrics.Skipped)
+
+	// a different content for the same version is pushed again
+	writeTestBetaRules(t, rulesfiles, "0.1.0", "- rule: beta\n")
+	assert.Len(t, update(), 1)
+	assert.NotZero(t, reg.uploads)
+}
+
+func TestPushUpdateMetrics(t *testing.T) {
+	var contentType, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		contentType, body = r.Header.Get("Content-Type"), string(data)
+		if r.URL.Path != "/metrics/job/sync" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	metrics := &UpdateMetrics{Pushed: 2, Skipped: 1, BytesPushed: 1024, Duration: 1500 * time.Millisecond}
+	ctx := context.Background()
+
+	assert.NoError(t, PushUpdateMetrics(ctx, srv.URL+"/metrics/job/sync", MetricsFormatPrometheus, metrics))
+	assert.Equal(t, "text/plain; version=0.0.4", contentType)
+	assert.Contains(t, body, "# TYPE falco_plugins_oci_sync_versions_pushed gauge\nfalco_plugins_oci_sync_versions_pushed 2\n")
+	assert.Contains(t, body, "falco_plugins_oci_sync_versions_skipped 1\n")
+	assert.Contains(t, body, "falco_plugins_oci_sync_versions_failed 0\n")
+	assert.Contains(t, body, "falco_plugins_oci_sync_pushed_bytes 1024\n")
+	assert.Contains(t, body, "falco_plugins_oci_sync_duration_seconds 1.5\n")
+
+	assert.NoError(t, PushUpdateMetrics(ctx, srv.URL+"/metrics/job/sync", MetricsFormatJSON, metrics))
+	assert.Equal(t, "application/json", contentType)
+	assert.JSONEq(t, `{"pushed":2,"skipped":1,"failed":0,"bytesPushed":1024,"durationNanoseconds":1500000000}`, body)
+
+	assert.Error(t, PushUpdateMetrics(ctx, srv.URL+"/other", MetricsFormatJSON, metrics))
+	assert.Error(t, PushUpdateMetrics(ctx, srv.URL+"/metrics/job/sync", "xml", metrics))
+}
+
+func TestValidateRulesfileEnabled(t *testing.T) {
+	// items only enabling or disabling a rule don't need the other keys
+	problems, err := validateRulesfile("testdata/rules-enabled.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`12: rule "Unknown Rule" is missing the required key "desc"`,
+		`12: rule "Unknown Rule" is missing the required key "output"`,
+		`12: rule "Unknown Rule" is missing the required key "priority"`,
+	}, problems)
+}
+
+func TestDoValidateRules(t *testing.T) {
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: good
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/good
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/good/rules
+  - name: bad
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/bad
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/bad/rules
+`)
+
+	rulesfiles := t.TempDir()
+	for name, fixture := range map[string]string{
+		"good-rules-0.1.0.tar.gz": "rules-valid.yaml",
+		"bad-rules-0.2.0.tar.gz":  "rules-malformed.yaml",
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		assert.NoError(t, err)
+		writeTestBuild(t, filepath.Join(rulesfiles, name), map[string][]byte{strings.SplitN(name, "-", 2)[0] + "_rules.yaml": data})
+	}
+
+	var out bytes.Buffer
+	opts := &UpdateOptions{RegistryFile: registryFile, RulesfilesPath: rulesfiles}
+	assert.ErrorIs(t, DoValidateRules(opts, &out), ErrInvalidRulesfiles)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 7)
+	assert.Regexp(t, `^good-rules\s+0\.1\.0\s+ok$`, lines[1])
+	assert.Regexp(t, `^bad-rules\s+0\.2\.0\s+bad_rules\.yaml:6: rule "Bad Rule" is missing the required key "output"$`, lines[2])
+	assert.Regexp(t, `bad_rules\.yaml:6: rule "Bad Rule" is missing the required key "priority"$`, lines[3])
+	assert.Regexp(t, `bad_rules\.yaml:10: expected exactly one of .*, found 2$`, lines[4])
+	assert.Regexp(t, `bad_rules\.yaml:14: expected an item in the form of a mapping$`, lines[5])
+	assert.Regexp(t, `unable to generate config layer: no dependencies or requirements found`, lines[6])
+
+	// only the valid rulesfile
+	out.Reset()
+	opts.Match = []string{"good"}
+	assert.NoError(t, DoValidateRules(opts, &out))
+
+	// not even YAML
+	writeTestBuild(t, filepath.Join(rulesfiles, "bad-rules-0.2.0.tar.gz"), map[string][]byte{"bad_rules.yaml": []byte("- rule: [")})
+	out.Reset()
+	opts.Match = []string{"bad"}
+	assert.ErrorIs(t, DoValidateRules(opts, &out), ErrInvalidRulesfiles)
+	assert.Contains(t, out.String(), "bad_rules.yaml:0: invalid YAML")
+}
+
+func TestDoUpdateOCIRegistryMediaTypes(t *testing.T) {
+	reg, srv, registryFile, rulesfiles := newTestBetaFixture(t)
+
+	status, err := DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: t.TempDir(),
+		PluginsARM64Path: t.TempDir(),
+		RulesfilesPath:   rulesfiles,
+		Client:           srv.Client(),
+		RulesfileMediaTypes: MediaTypes{
+			ArtifactType:    "application/vnd.example.rulesfile.v1",
+			ConfigMediaType: "application/vnd.example.rulesfile.config.v1+json",
+		},
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, status, 1) {
+		return
+	}
+
+	// all the tags point to the manifest with the media types, whose digest is reported
+	repo := "/v2/falcosecurity/plugins/ruleset/beta"
+	for _, tag := range status[0].Artifact.Tags {
+		data := reg.manifests[repo+":"+tag]
+		assert.Equal(t, status[0].Artifact.Digest, digest.FromBytes(data).String(), tag)
+
+		var manifest v1.Manifest
+		assert.NoError(t, json.Unmarshal(data, &manifest))
+		assert.Equal(t, "application/vnd.example.rulesfile.v1", manifest.ArtifactType)
+		assert.Equal(t, "application/vnd.example.rulesfile.config.v1+json", manifest.Config.MediaType)
+		if assert.Len(t, manifest.Layers, 1) {
+			assert.Equal(t, "application/vnd.cncf.falco.rulesfile.layer.v1+tar.gz", manifest.Layers[0].MediaType)
+		}
+	}
+	// no manifest without the media types is left in the repository
+	for key, data := range reg.manifests {
+		var manifest v1.Manifest
+		assert.NoError(t, json.Unmarshal(data, &manifest))
+		assert.Equal(t, "application/vnd.example.rulesfile.v1", manifest.ArtifactType, key)
+	}
+}
+
+func TestPushWithMediaTypesIndex(t *testing.T) {
+	reg, srv := newTestRegistry(t)
+	ref := srv.Listener.Addr().String() + "/falcosecurity/plugins/plugin/beta"
+	dir := t.TempDir()
+	filepaths := []string{filepath.Join(dir, "beta-0.1.0-linux-x86_64.tar.gz"), filepath.Join(dir, "beta-0.1.0-linux-aarch64.tar.gz")}
+	for _, fp := range filepaths {
+		writeTestBuild(t, fp, map[string][]byte{filepath.Base(fp) + ".so": nil})
+	}
+
+	res, err := pushArtifact(context.Background(), srv.Client(), oci.Plugin, ref, []string{"0", "0.1", "0.1.0"},
+		filepaths, []string{amd64Platform, arm64Platform}, &oci.ArtifactConfig{Name: "beta", Version: "0.1.0"},
+		"https://github.com/falcosecurity/plugins", MediaTypes{ArtifactType: "application/vnd.example.plugin.v1"})
+	assert.NoError(t, err)
+
+	repo := "/v2/falcosecurity/plugins/plugin/beta"
+	for _, tag := range []string{"0", "0.1", "0.1.0"} {
+		assert.Equal(t, res.Digest, digest.FromBytes(reg.manifests[repo+":"+tag]).String(), tag)
+	}
+	var index v1.Index
+	assert.NoError(t, json.Unmarshal(reg.manifests[repo+":0.1.0"], &index))
+	assert.Equal(t, "application/vnd.example.plugin.v1", index.ArtifactType)
+	assert.Equal(t, "https://github.com/falcosecurity/plugins", index.Annotations[v1.AnnotationSource])
+	if assert.Len(t, index.Manifests, 2) {
+		assert.Equal(t, &v1.Platform{OS: "linux", Architecture: "amd64"}, index.Manifests[0].Platform)
+		assert.Equal(t, &v1.Platform{OS: "linux", Architecture: "arm64"}, index.Manifests[1].Platform)
+		for i, m := range index.Manifests {
+			assert.Equal(t, "application/vnd.example.plugin.v1", m.ArtifactType)
+
+			var manifest v1.Manifest
+			assert.NoError(t, json.Unmarshal(reg.manifests[repo+":"+string(m.Digest)], &manifest))
+			assert.Equal(t, "application/vnd.example.plugin.v1", manifest.ArtifactType)
+			assert.Equal(t, oci.FalcoPluginConfigMediaType, manifest.Config.MediaType)
+			if assert.Len(t, manifest.Layers, 1) {
+				assert.Equal(t, oci.FalcoPluginLayerMediaType, manifest.Layers[0].MediaType)
+				assert.Equal(t, filepath.Base(filepaths[i]), manifest.Layers[0].Annotations[v1.AnnotationTitle])
+			}
+		}
+	}
+	// only the final manifests and index are pushed
+	assert.Len(t, reg.manifests, 3+3)
+}
+
+func TestDoUpdateOCIRegistryLatestTag(t *testing.T) {
+	reg, srv, registryFile, rulesfiles := newTestBetaFixture(t)
+	update := func(latestTag string) ([]registry.ArtifactPushMetadata, error) {
+		return DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+			RegistryFile:     registryFile,
+			PluginsAMD64Path: t.TempDir(),
+			PluginsARM64Path: t.TempDir(),
+			RulesfilesPath:   rulesfiles,
+			Client:           srv.Client(),
+			LatestTag:        latestTag,
+		})
+	}
+
+	_, err := update("0-latest")
+	assert.ErrorContains(t, err, "invalid latest tag")
+	assert.Zero(t, reg.uploads)
+
+	status, err := update("stable")
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		assert.Equal(t, []string{"stable", "0", "0.1", "0.1.0"}, status[0].Artifact.Tags)
+	}
+	repo := "/v2/falcosecurity/plugins/ruleset/beta"
+	assert.Contains(t, reg.manifests, repo+":stable")
+	assert.NotContains(t, reg.manifests, repo+":latest")
+}
+
+func TestDoUpdateOCIRegistryLatestTagRecovery(t *testing.T) {
+	reg, srv, registryFile, _ := newTestBetaFixture(t)
+	delay := latestTagRetryDelay
+	latestTagRetryDelay = time.Millisecond
+	t.Cleanup(func() { latestTagRetryDelay = delay })
+
+	update := func(version string) ([]registry.ArtifactPushMetadata, error) {
+		rulesfiles := t.TempDir()
+		writeTestBetaRules(t, rulesfiles, version, "")
+		return DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+			RegistryFile:     registryFile,
+			PluginsAMD64Path: t.TempDir(),
+			PluginsARM64Path: t.TempDir(),
+			RulesfilesPath:   rulesfiles,
+			Client:           srv.Client(),
+		})
+	}
+	repo := "/v2/falcosecurity/plugins/ruleset/beta"
+
+	// the latest tag is not moved if the version is not fully pushed
+	reg.failTags = map[string]int{"0.1.0": 1}
+	_, err := update("0.1.0")
+	var pushErr *PushError
+	assert.ErrorAs(t, err, &pushErr)
+	assert.NotContains(t, reg.manifests, repo+":latest")
+
+	// the version is pushed, but moving the latest tag keeps failing
+	reg.failTags = map[string]int{"latest": latestTagAttempts}
+	_, err = update("0.1.0")
+	assert.ErrorAs(t, err, &pushErr)
+	assert.Contains(t, reg.manifests, repo+":0.1.0")
+	assert.NotContains(t, reg.manifests, repo+":latest")
+
+	// re-running moves the latest tag without pushing the version again
+	status, err := update("0.1.0")
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+	assert.Equal(t, reg.manifests[repo+":0.1.0"], reg.manifests[repo+":latest"])
+
+	// moving the latest tag is retried
+	reg.failTags = map[string]int{"latest": latestTagAttempts - 1}
+	status, err = update("0.2.0")
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		assert.Contains(t, status[0].Artifact.Tags, "latest")
+	}
+	assert.Equal(t, reg.manifests[repo+":0.2.0"], reg.manifests[repo+":latest"])
+}
+
+func TestDoUpdateOCIRegistryAttachments(t *testing.T) {
+	for _, referrers := range []bool{true, false} {
+		reg, srv, registryFile, rulesfiles := newTestBetaFixture(t)
+		reg.referrers = referrers
+		build := filepath.Join(rulesfiles, "beta-rules-0.1.0.tar.gz")
+		assert.NoError(t, os.WriteFile(build+".sig", []byte("signature"), 0o600))
+		assert.NoError(t, os.WriteFile(build+".spdx.json", []byte(`{"spdxVersion":"SPDX-2.3"}`), 0o600))
+
+		attachments := []Attachment{
+			{Suffix: ".sig", ArtifactType: "application/vnd.example.signature"},
+			{Suffix: ".spdx.json", ArtifactType: "application/spdx+json"},
+			{Suffix: ".missing", ArtifactType: "application/vnd.example.missing"},
+		}
+		update := func() ([]registry.ArtifactPushMetadata, error) {
+			return DoUpdateOCIRegistry(context.Background(), &UpdateOptions{
+				RegistryFile:     registryFile,
+				PluginsAMD64Path: t.TempDir(),
+				PluginsARM64Path: t.TempDir(),
+				RulesfilesPath:   rulesfiles,
+				Client:           srv.Client(),
+				Attachments:      attachments,
+			})
+		}
+		status, err := update()
+		assert.NoError(t, err)
+		if !assert.Len(t, status, 1) || !assert.Len(t, status[0].Artifact.Referrers, 2) {
+			continue
+		}
+
+		// the referrers are listed by the Referrers API, or indexed with the
+		// referrers tag schema if not supported
+		repo, err := repository.NewRepository(status[0].Repository.Ref, repository.WithClient(srv.Client()))
+		assert.NoError(t, err)
+		subject, err := repo.Resolve(context.Background(), status[0].Artifact.Digest)
+		assert.NoError(t, err)
+		var artifactTypes []string
+		assert.NoError(t, repo.Referrers(context.Background(), subject, "", func(referrers []v1.Descriptor) error {
+			for _, r := range referrers {
+				assert.Contains(t, status[0].Artifact.Referrers, r.Digest.String())
+				artifactTypes = append(artifactTypes, r.ArtifactType)
+			}
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{"application/vnd.example.signature", "application/spdx+json"}, artifactTypes)
+		referrersTag := "/v2/falcosecurity/plugins/ruleset/beta:" + strings.Replace(subject.Digest.String(), ":", "-", 1)
+		if referrers {
+			assert.NotContains(t, reg.manifests, referrersTag)
+		} else {
+			assert.Contains(t, reg.manifests, referrersTag)
+		}
+
+		// the attached files are the layers of the referrers
+		for _, dgst := range status[0].Artifact.Referrers {
+			var manifest v1.Manifest
+			assert.NoError(t, json.Unmarshal(reg.manifests["/v2/falcosecurity/plugins/ruleset/beta:"+dgst], &manifest))
+			assert.Equal(t, subject.Digest, manifest.Subject.Digest)
+			if assert.Len(t, manifest.Layers, 1) {
+				title := manifest.Layers[0].Annotations[v1.AnnotationTitle]
+				assert.Contains(t, []string{"beta-rules-0.1.0.tar.gz.sig", "beta-rules-0.1.0.tar.gz.spdx.json"}, title)
+				data, err := os.ReadFile(filepath.Join(rulesfiles, title))
+				assert.NoError(t, err)
+				assert.Equal(t, data, reg.blobs[manifest.Layers[0].Digest.String()])
+			}
+		}
+
+		// the files attached to the already pushed version are pushed by the
+		// following runs, for example after a failure or when added later
+		assert.NoError(t, os.WriteFile(build+".intoto", []byte("provenance"), 0o600))
+		attachments = append(attachments, Attachment{Suffix: ".intoto", ArtifactType: "application/vnd.in-toto+json"})
+		status, err = update()
+		assert.NoError(t, err)
+		assert.Empty(t, status)
+		artifactTypes = nil
+		assert.NoError(t, repo.Referrers(context.Background(), subject, "", func(referrers []v1.Descriptor) error {
+			for _, r := range referrers {
+				artifactTypes = append(artifactTypes, r.ArtifactType)
+			}
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{"application/vnd.example.signature", "application/spdx+json",
+			"application/vnd.in-toto+json"}, artifactTypes)
+
+		// nothing is pushed once all the files are attached
+		uploads := reg.uploads
+		_, err = update()
+		assert.NoError(t, err)
+		assert.Equal(t, uploads, reg.uploads)
+	}
+}
+
+func TestPushAttachmentsIndex(t *testing.T) {
+	reg, srv := newTestRegistry(t)
+	reg.referrers = true
+	ref := srv.Listener.Addr().String() + "/falcosecurity/plugins/plugin/beta"
+	dir := t.TempDir()
+	filepaths := []string{filepath.Join(dir, "beta-0.1.0-linux-x86_64.tar.gz"), filepath.Join(dir, "beta-0.1.0-linux-aarch64.tar.gz")}
+	platforms := []string{amd64Platform, arm64Platform}
+	for _, fp := range filepaths {
+		writeTestBuild(t, fp, map[string][]byte{filepath.Base(fp) + ".so": nil})
+		assert.NoError(t, os.WriteFile(fp+".sig", []byte("signature of "+filepath.Base(fp)), 0o600))
+	}
+
+	res, err := pushArtifact(context.Background(), srv.Client(), oci.Plugin, ref, []string{"0.1.0"},
+		filepaths, platforms, &oci.ArtifactConfig{Name: "beta", Version: "0.1.0"}, "", MediaTypes{})
+	assert.NoError(t, err)
+	referrers, err := pushAttachments(context.Background(), srv.Client(), ref, res.Digest, filepaths, platforms,
+		[]Attachment{{Suffix: ".sig", ArtifactType: "application/vnd.example.signature"}})
+	assert.NoError(t, err)
+	if !assert.Len(t, referrers, 2) {
+		return
+	}
+
+	// the signature of each build refers to the manifest of its platform
+	repo := "/v2/falcosecurity/plugins/plugin/beta"
+	var index v1.Index
+	assert.NoError(t, json.Unmarshal(reg.manifests[repo+":0.1.0"], &index))
+	subjects := make(map[string]string)
+	for _, m := range index.Manifests {
+		subjects[m.Platform.OS+"/"+m.Platform.Architecture] = string(m.Digest)
+	}
+	for i, dgst := range referrers {
+		var manifest v1.Manifest
+		assert.NoError(t, json.Unmarshal(reg.manifests[repo+":"+dgst], &manifest))
+		assert.Equal(t, subjects[platforms[i]], string(manifest.Subject.Digest))
+		if assert.Len(t, manifest.Layers, 1) {
+			assert.Equal(t, filepath.Base(filepaths[i])+".sig", manifest.Layers[0].Annotations[v1.AnnotationTitle])
+		}
+	}
+}
+
+func TestParseAttachment(t *testing.T) {
+	attachment, err := ParseAttachment(".sig=application/vnd.example.signature")
+	assert.NoError(t, err)
+	assert.Equal(t, Attachment{Suffix: ".sig", ArtifactType: "application/vnd.example.signature"}, attachment)
+	for _, s := range []string{".sig", "=application/vnd.example.signature", ".sig="} {
+		_, err := ParseAttachment(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDoResolveVersions(t *testing.T) {
+	registryFile := writeTestRegistryFile(t, `
+plugins:
+  - name: alpha
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/alpha
+  - name: beta
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta
+    rules_url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta/rules
+  - name: gamma
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/gamma
+  - name: delta
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/delta
+  - name: external
+    url: https://github.com/example/external
+`)
+
+	amd64, arm64, rulesfiles := t.TempDir(), t.TempDir(), t.TempDir()
+	for _, build := range []string{
+		filepath.Join(amd64, "alpha-1.2.3-linux-x86_64.tar.gz"),
+		filepath.Join(arm64, "alpha-1.2.3-linux-aarch64.tar.gz"),
+		filepath.Join(amd64, "beta-0.4.0-rc1-linux-x86_64.tar.gz"),
+		filepath.Join(rulesfiles, "beta-rules-0.3.1.tar.gz"),
+		filepath.Join(amd64, "gamma-0.1.0-12-g1a2b3c4-linux-x86_64.tar.gz"),
+		filepath.Join(amd64, "delta-1.x-linux-x86_64.tar.gz"),
+		filepath.Join(amd64, "external-1.0.0-linux-x86_64.tar.gz"),
+	} {
+		assert.NoError(t, os.WriteFile(build, nil, 0o600))
+	}
+	opts := UpdateOptions{
+		RegistryFile:     registryFile,
+		PluginsAMD64Path: amd64,
+		PluginsARM64Path: arm64,
+		RulesfilesPath:   rulesfiles,
+	}
+
+	// the latest tag only moves to the stable versions, and the invalid builds are reported
+	var out bytes.Buffer
+	err := DoResolveVersions(&opts, &out)
+	var invalidErr *InvalidBuildsError
+	if assert.ErrorAs(t, err, &invalidErr) && assert.Len(t, invalidErr.Errs, 1) {
+		var parseErr *VersionParseError
+		assert.ErrorAs(t, invalidErr.Errs[0], &parseErr)
+		assert.Equal(t, "delta-1.x-linux-x86_64.tar.gz", parseErr.BuildName)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 5) {
+		assert.Equal(t, []string{"NAME", "VERSION", "TAGS", "LATEST"}, strings.Fields(lines[0]))
+		assert.Equal(t, []string{"alpha", "1.2.3", "latest,1,1.2,1.2.3", "true"}, strings.Fields(lines[1]))
+		assert.Equal(t, []string{"beta", "0.4.0-rc1", "0.4.0-rc1", "false"}, strings.Fields(lines[2]))
+		assert.Equal(t, []string{"beta-rules", "0.3.1", "latest,0,0.3,0.3.1", "true"}, strings.Fields(lines[3]))
+		assert.Equal(t, []string{"gamma", "0.1.0-12-g1a2b3c4", "0.1.0-12-g1a2b3c4", "false"}, strings.Fields(lines[4]))
+	}
+
+	// the options filtering the builds are respected
+	out.Reset()
+	opts.Match = []string{"b*"}
+	opts.SkipPlugins = true
+	opts.LatestTag = "stable"
+	assert.NoError(t, DoResolveVersions(&opts, &out))
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, []string{"beta-rules", "0.3.1", "stable,0,0.3,0.3.1", "true"}, strings.Fields(lines[1]))
+	}
+
+	// the devel versions are only tagged with the dev tag
+	out.Reset()
+	opts.Match = []string{"gamma"}
+	opts.SkipPlugins = false
+	opts.DevTag = "dev"
+	assert.NoError(t, DoResolveVersions(&opts, &out))
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, []string{"gamma", "0.1.0-12-g1a2b3c4", "dev", "false"}, strings.Fields(lines[1]))
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+// ResolvedVersion is the version of a plugin or rulesfile that DoUpdateOCIRegistry would push.
+type ResolvedVersion struct {
+	// Name is the name of the plugin or rulesfile.
+	Name string
+	// Version is the version of its build objects.
+	Version string
+	// Tags are the tags the version would be pushed with.
+	Tags []string
+	// Latest is true if the latest tag would be moved to the version, which is not the
+	// case for pre-release and devel versions.
+	Latest bool
+}
+
+// DoResolveVersions determines the versions of the plugins listed in the registry file, and of their rulesfiles,
+// looking for their builds in the same way as DoUpdateOCIRegistry, but without pushing them. The options used are
+// the same as the ones of DoUpdateOCIRegistry, except the ones related to the OCI registry. The resolved versions
+// are printed to output, and an InvalidBuildsError is returned if the versions of some builds can't be determined.
+func DoResolveVersions(opts *UpdateOptions, output io.Writer) error {
+	if err := validateLatestTag(opts.LatestTag); err != nil {
+		return err
+	}
+	if opts.SkipPlugins && opts.SkipRules {
+		return fmt.Errorf("invalid options: the plugins and the rulesfiles can't be both skipped")
+	}
+	for _, pattern := range opts.Match {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid plugin name pattern %q: %w", pattern, err)
+		}
+	}
+
+	reg, err := registry.LoadRegistryWithOverlays(opts.RegistryFile, opts.Overlays...)
+	if err != nil {
+		return fmt.Errorf("an error occurred while loading registry entries from file %q: %v", opts.RegistryFile, err)
+	}
+
+	var results []ResolvedVersion
+	var errs []error
+	for i := range reg.Plugins {
+		if !matchPluginName(reg.Plugins[i].Name, opts.Match) {
+			continue
+		}
+		versions, perrs := resolveVersions(&reg.Plugins[i], opts)
+		results = append(results, versions...)
+		errs = append(errs, perrs...)
+	}
+
+	if err := printResolvedVersions(results, output); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &InvalidBuildsError{Errs: errs}
+	}
+	return nil
+}
+
+// resolveVersions determines the versions of the build objects of a plugin and of its rulesfile as
+// DoUpdateOCIRegistry does, without pushing anything, and returns the errors of the ones whose versions
+// can't be determined. The other errors, such as missing folders, are left to the push.
+func resolveVersions(plugin *registry.Plugin, opts *UpdateOptions) ([]ResolvedVersion, []error) {
+	if !strings.HasPrefix(plugin.URL, PluginsRepo) {
+		return nil, nil
+	}
+
+	latestTag := opts.LatestTag
+	if latestTag == "" {
+		latestTag = DefaultLatestTag
+	}
+	var results []ResolvedVersion
+	var errs []error
+	resolved := func(name, version string, tags []string, err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		_, latest := splitLatestTag(tags, latestTag)
+		results = append(results, ResolvedVersion{Name: name, Version: version, Tags: tags, Latest: latest != ""})
+	}
+
+	if !opts.SkipPlugins {
+		var filepaths, platforms []string
+		for _, b := range []struct{ dir, platform string }{
+			{opts.PluginsAMD64Path, amd64Platform},
+			{opts.PluginsARM64Path, arm64Platform},
+		} {
+			if build, err := buildName(opts.VersionExtractor, plugin.Name, b.dir, false); err == nil && build != "" {
+				filepaths = append(filepaths, filepath.Join(b.dir, build))
+				platforms = append(platforms, b.platform)
+			}
+		}
+		filepaths, _ = excludePlatforms(filepaths, platforms, opts.ExcludedPlatforms)
+		if len(filepaths) > 0 {
+			version, tags, err := buildsVersionAndTags(opts, plugin.Name, filepaths)
+			resolved(plugin.Name, version, tags, err)
+		}
+	}
+
+	if plugin.RulesURL != "" && !opts.SkipRules {
+		if build, err := buildName(opts.VersionExtractor, plugin.Name, opts.RulesfilesPath, true); err == nil && build != "" {
+			version, tags, err := versionAndTags(opts.VersionExtractor, plugin.Name, build, opts.DevTag, opts.LatestTag)
+			resolved(rulesfileNameFromPlugin(plugin.Name), version, tags, err)
+		}
+	}
+	return results, errs
+}
+
+func printResolvedVersions(results []ResolvedVersion, output io.Writer) error {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tTAGS\tLATEST")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", r.Name, r.Version, strings.Join(r.Tags, ","), r.Latest)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/falcosecurity/falcoctl/pkg/oci/repository"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"k8s.io/klog/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+// alreadyPushed returns true if the most specific of the given tags already
+// points to an artifact made of the given files in the remote repository
+// identified by ref, so that the push can be skipped. If the remote artifact
+// can't be compared, a warning is logged and false is returned.
+func alreadyPushed(ctx context.Context, client remote.Client, ref string, tags []string, files []registry.FileMetadata) bool {
+	tag := tags[len(tags)-1]
+	upToDate, err := remoteUpToDate(ctx, client, ref, tag, files)
+	if err != nil {
+		klog.Warningf("unable to compare with the remote artifact, pushing anyway: %v", err)
+		return false
+	}
+	if upToDate {
+		klog.Infof("skipping push to remote repo with ref %q: tag %q already has the same content", ref, tag)
+	}
+	return upToDate
+}
+
+// remoteUpToDate returns true if the artifact tagged with tag in the remote
+// repository identified by ref has exactly the given files as its layers,
+// in which case pushing them again would not change its content.
+// A missing tag is not an error.
+func remoteUpToDate(ctx context.Context, client remote.Client, ref, tag string, files []registry.FileMetadata) (bool, error) {
+	repo, err := repository.NewRepository(ref, repository.WithClient(client))
+	if err != nil {
+		return false, err
+	}
+
+	desc, rc, err := repo.FetchReference(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errdef.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to fetch %q: %w", ref+":"+tag, err)
+	}
+	remoteLayers, err := manifestLayers(ctx, repo, desc, rc)
+	if err != nil {
+		return false, fmt.Errorf("unable to read manifest of %q: %w", ref+":"+tag, err)
+	}
+
+	localLayers := make([]string, 0, len(files))
+	for _, f := range files {
+		localLayers = append(localLayers, string(digest.NewDigestFromEncoded(digest.SHA256, f.SHA256)))
+	}
+
+	slices.Sort(remoteLayers)
+	slices.Sort(localLayers)
+	klog.V(4).Infof("comparing layers of %q: remote %q, local %q", ref+":"+tag, remoteLayers, localLayers)
+	return slices.Equal(remoteLayers, localLayers), nil
+}
+
+// manifestLayers returns the digests of the layers of the manifest read
+// from rc, which gets closed. For indexes, the layers of all the referenced
+// manifests are returned.
+func manifestLayers(ctx context.Context, repo *repository.Repository, desc v1.Descriptor, rc io.ReadCloser) ([]string, error) {
+	data, err := content.ReadAll(rc, desc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []string
+	switch desc.MediaType {
+	case v1.MediaTypeImageIndex:
+		var index v1.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			mrc, err := repo.Fetch(ctx, m)
+			if err != nil {
+				return nil, err
+			}
+			l, err := manifestLayers(ctx, repo, m, mrc)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, l...)
+		}
+	case v1.MediaTypeImageManifest:
+		var manifest v1.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, err
+		}
+		for _, l := range manifest.Layers {
+			layers = append(layers, string(l.Digest))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported media type %q", desc.MediaType)
+	}
+	return layers, nil
+}
+
+// buildFiles returns the metadata of the given build files, reading each of
+// them once to compute its SHA256, which matches the digest of the layer it
+// gets pushed as. The platforms are optional.
+func buildFiles(filepaths, platforms []string) ([]registry.FileMetadata, error) {
+	files := make([]registry.FileMetadata, 0, len(filepaths))
+	for i, fp := range filepaths {
+		f, err := os.Open(fp)
+		if err != nil {
+			return nil, err
+		}
+		d, err := digest.SHA256.FromReader(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		file := registry.FileMetadata{Name: filepath.Base(fp), SHA256: d.Encoded()}
+		if i < len(platforms) {
+			file.Platform = platforms[i]
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
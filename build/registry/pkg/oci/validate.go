@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/falcosecurity/plugins/build/registry/pkg/common"
+	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
+)
+
+// ErrInvalidRulesfiles is returned by DoValidateRules when at least one rulesfile is invalid.
+var ErrInvalidRulesfiles = errors.New("invalid rulesfiles found")
+
+// RulesfileValidation is the result of the validation of the rulesfile of a plugin.
+type RulesfileValidation struct {
+	// Name is the name of the rulesfile.
+	Name string
+	// Version is the version of the validated build of the rulesfile.
+	Version string
+	// Problems lists the problems found in the rulesfile, which is valid if empty.
+	Problems []string
+}
+
+// DoValidateRules validates the structure of the rulesfiles of the plugins listed in the registry file, looking
+// for their builds in the rulesfiles folder in the same way as DoUpdateOCIRegistry, but without pushing them.
+// Only the RegistryFile, RulesfilesPath, DevTag, Match and VersionExtractor options are used. The result of each
+// validation is printed to output, and ErrInvalidRulesfiles is returned if any rulesfile is invalid.
+func DoValidateRules(opts *UpdateOptions, output io.Writer) error {
+	if opts.RulesfilesPath == "" {
+		return fmt.Errorf("no rulesfiles folder specified")
+	}
+	for _, pattern := range opts.Match {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid plugin name pattern %q: %w", pattern, err)
+		}
+	}
+
+	reg, err := registry.LoadRegistryWithOverlays(opts.RegistryFile, opts.Overlays...)
+	if err != nil {
+		return fmt.Errorf("an error occurred while loading registry entries from file %q: %v", opts.RegistryFile, err)
+	}
+
+	var results []RulesfileValidation
+	for _, plugin := range reg.Plugins {
+		if plugin.RulesURL == "" || !strings.HasPrefix(plugin.URL, PluginsRepo) || !matchPluginName(plugin.Name, opts.Match) {
+			continue
+		}
+		result, err := validateRules(opts, plugin.Name)
+		if err != nil {
+			return err
+		}
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	if err := printRulesfileValidations(results, output); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if len(r.Problems) > 0 {
+			return ErrInvalidRulesfiles
+		}
+	}
+	return nil
+}
+
+// validateRules validates the build of the rulesfile of the given plugin, if any.
+func validateRules(opts *UpdateOptions, pluginName string) (*RulesfileValidation, error) {
+	build, err := buildName(opts.VersionExtractor, pluginName, opts.RulesfilesPath, true)
+	if err != nil || build == "" {
+		return nil, err
+	}
+	result := &RulesfileValidation{Name: rulesfileNameFromPlugin(pluginName)}
+	version, _, err := versionAndTags(opts.VersionExtractor, pluginName, build, opts.DevTag, opts.LatestTag)
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result, nil
+	}
+	result.Version = version
+
+	tmpDir, err := os.MkdirTemp("", "registry-rules-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary dir while preparing to extract rulesfile %q: %v", build, err)
+	}
+	defer os.RemoveAll(tmpDir)
+	files, err := common.ExtractTarGz(filepath.Join(opts.RulesfilesPath, build), tmpDir)
+	if err != nil {
+		result.Problems = append(result.Problems, fmt.Sprintf("unable to extract %q: %v", build, err))
+		return result, nil
+	}
+
+	for _, file := range files {
+		if ext := filepath.Ext(file); ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		problems, err := validateRulesfile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range problems {
+			result.Problems = append(result.Problems, filepath.Base(file)+":"+p)
+		}
+	}
+	if _, err := rulesfileConfig(result.Name, version, filepath.Join(opts.RulesfilesPath, build)); err != nil {
+		result.Problems = append(result.Problems, fmt.Sprintf("unable to generate config layer: %v", err))
+	}
+	return result, nil
+}
+
+// rulesfileItemKeys maps the kind of each top-level item of a rulesfile to the keys it requires, unless
+// it appends to or overrides an item with the same name, or only enables or disables a rule.
+var rulesfileItemKeys = map[string][]string{
+	"required_engine_version":  nil,
+	"required_plugin_versions": nil,
+	"rule":                     {"desc", "condition", "output", "priority"},
+	"macro":                    {"condition"},
+	"list":                     {"items"},
+}
+
+// validateRulesfile performs a structural validation of a rulesfile in YAML format: it must be a list of
+// mappings, each having exactly one known kind (e.g. rule or macro) and the keys required by that kind.
+// The problems found are returned in the form "<line>: <problem>".
+func validateRulesfile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []string{fmt.Sprintf("0: invalid YAML: %v", err)}, nil
+	}
+	if len(doc.Content) == 0 {
+		return []string{"0: empty rulesfile"}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.SequenceNode {
+		return []string{fmt.Sprintf("%d: expected a list of items", root.Line)}, nil
+	}
+
+	var problems []string
+	for _, item := range root.Content {
+		if item.Kind != yaml.MappingNode {
+			problems = append(problems, fmt.Sprintf("%d: expected an item in the form of a mapping", item.Line))
+			continue
+		}
+		keys := make(map[string]*yaml.Node)
+		var kinds []string
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			key := item.Content[i].Value
+			keys[key] = item.Content[i+1]
+			if _, ok := rulesfileItemKeys[key]; ok {
+				kinds = append(kinds, key)
+			}
+		}
+		if len(kinds) != 1 {
+			problems = append(problems, fmt.Sprintf("%d: expected exactly one of rule, macro, list, required_engine_version or required_plugin_versions, found %d", item.Line, len(kinds)))
+			continue
+		}
+		kind := kinds[0]
+		if _, ok := keys["override"]; ok {
+			continue
+		}
+		if a, ok := keys["append"]; ok && a.Value == "true" {
+			continue
+		}
+		// rules with only the enabled key enable or disable an existing rule
+		if _, ok := keys["enabled"]; ok && kind == "rule" && len(keys) == 2 {
+			continue
+		}
+		for _, required := range rulesfileItemKeys[kind] {
+			if _, ok := keys[required]; !ok {
+				problems = append(problems, fmt.Sprintf("%d: %s %q is missing the required key %q", item.Line, kind, keys[kind].Value, required))
+			}
+		}
+	}
+	return problems, nil
+}
+
+func printRulesfileValidations(results []RulesfileValidation, output io.Writer) error {
+	w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULESFILE\tVERSION\tRESULT")
+	for _, r := range results {
+		version := r.Version
+		if version == "" {
+			version = "-"
+		}
+		if len(r.Problems) == 0 {
+			fmt.Fprintf(w, "%s\t%s\tok\n", r.Name, version)
+			continue
+		}
+		for _, p := range r.Problems {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, version, p)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// VersionExtractorFilename is the default version extraction strategy, expecting
+	// plugin builds to be named <plugin>-<version>-linux-<arch>.tar.gz and rulesfiles
+	// to be named <plugin>-rules-<version>.tar.gz.
+	VersionExtractorFilename = "filename"
+	// VersionExtractorRegexp is the version extraction strategy using a custom regular
+	// expression, whose first capturing group matches the version in the build name.
+	// The build names are expected to start with the plugin or rulesfile name, followed
+	// by separators and an optional "v" before the version.
+	VersionExtractorRegexp = "regexp"
+)
+
+// VersionExtractor extracts the version of a plugin or rulesfile from the
+// name of its build object.
+type VersionExtractor interface {
+	ExtractVersion(pluginName, buildName string) (string, error)
+	// IsBuildOf returns true if buildName is the name of a build object of
+	// objName, which is either a plugin or a rulesfile name.
+	IsBuildOf(objName, buildName string) bool
+}
+
+// NewVersionExtractor returns the VersionExtractor for the given strategy, which is
+// either VersionExtractorFilename or VersionExtractorRegexp. The pattern is only
+// used by the latter.
+func NewVersionExtractor(strategy, pattern string) (VersionExtractor, error) {
+	switch strategy {
+	case "", VersionExtractorFilename:
+		return filenameVersionExtractor{}, nil
+	case VersionExtractorRegexp:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version pattern %q: %w", pattern, err)
+		}
+		if re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("invalid version pattern %q: expected a capturing group matching the version", pattern)
+		}
+		return &regexpVersionExtractor{re: re}, nil
+	default:
+		return nil, fmt.Errorf("unknown version extraction strategy %q, expected one of %q", strategy,
+			[]string{VersionExtractorFilename, VersionExtractorRegexp})
+	}
+}
+
+type filenameVersionExtractor struct{}
+
+func (filenameVersionExtractor) ExtractVersion(pluginName, buildName string) (string, error) {
+	if strings.Contains(buildName, "-rules") {
+		version := strings.TrimPrefix(buildName, pluginName+"-rules-")
+		return strings.TrimSuffix(version, archiveSuffix), nil
+	}
+
+	// Replace all substrings starting with "linux" with an empty string
+	version := filenameLinuxSuffix.ReplaceAllString(buildName, "")
+	return strings.TrimPrefix(version, pluginName+"-"), nil
+}
+
+// IsBuildOf returns true if the given build name is in the form
+// <objName>-<version>[...] where the version starts with a digit, optionally
+// preceded by a "v". Matching the whole dash-separated name prevents objects
+// sharing a common prefix (e.g. k8saudit and k8saudit-eks, or a plugin and its
+// own rulesfile) from being mixed up.
+func (filenameVersionExtractor) IsBuildOf(objName, buildName string) bool {
+	version := strings.TrimPrefix(buildName, objName+"-")
+	version = strings.TrimPrefix(version, "v")
+	if version == buildName || len(version) == 0 {
+		return false
+	}
+	return version[0] >= '0' && version[0] <= '9'
+}
+
+var filenameLinuxSuffix = regexp.MustCompile(`\b-linux\S*`)
+
+type regexpVersionExtractor struct {
+	re *regexp.Regexp
+}
+
+func (e *regexpVersionExtractor) ExtractVersion(pluginName, buildName string) (string, error) {
+	match := e.re.FindStringSubmatch(buildName)
+	if match == nil || match[1] == "" {
+		return "", fmt.Errorf("build name does not match %q", e.re.String())
+	}
+	return match[1], nil
+}
+
+// IsBuildOf returns true if the pattern matches the given build name, and the
+// part of the latter preceding the version is objName, followed by separators
+// and an optional "v" (e.g. k8saudit_v in k8saudit_v0.11.0_linux_amd64.tar.gz).
+func (e *regexpVersionExtractor) IsBuildOf(objName, buildName string) bool {
+	loc := e.re.FindStringSubmatchIndex(buildName)
+	if loc == nil || loc[2] < 0 || loc[2] == loc[3] {
+		return false
+	}
+	prefix := strings.TrimSuffix(buildName[:loc[2]], "v")
+	return strings.TrimRight(prefix, "-_.") == objName
+}
@@ -18,12 +18,61 @@ limitations under the License.
 package registry
 
 import (
+	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-// LoadRegistryFromFile loads the registry from a file on disk.
+const stdinFileName = "-"
+
+// stdin is the reader used when loading the registry from the standard input.
+var stdin io.Reader = os.Stdin
+
+// LoadRegistryFromFile loads the registry from a file on disk. The file name
+// can also be "-" to read the registry from the standard input, or a http(s)
+// URL to download it. The registry files included by the loaded registry are
+// merged into it, see includeRegistries.
 func LoadRegistryFromFile(fname string) (*Registry, error) {
+	var registry *Registry
+	var err error
+	if fname == stdinFileName {
+		registry, err = load(stdin)
+	} else if strings.HasPrefix(fname, "http://") || strings.HasPrefix(fname, "https://") {
+		registry, err = loadRegistryFromURL(fname)
+		if err == nil && len(registry.Include) > 0 {
+			return nil, fmt.Errorf("unable to include registry files from registry downloaded from %q", fname)
+		}
+	} else {
+		registry, err = loadRegistryFromLocalFile(fname)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	included := make(map[string]bool)
+	if abs, err := filepath.Abs(fname); err == nil && fname != stdinFileName {
+		included[abs] = true
+	}
+
+	// the plugins of the loaded registry are defined by fname
+	sources := make(map[string]string)
+	for _, p := range registry.Plugins {
+		sources[p.Name] = fname
+	}
+	includes := registry.Include
+	registry.Include = nil
+	if err := includeRegistries(registry, fname, includes, sources, included); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// loadRegistryFromLocalFile loads the registry from a file on disk.
+func loadRegistryFromLocalFile(fname string) (*Registry, error) {
 	file, err := os.Open(fname)
 	if err != nil {
 		return nil, err
@@ -32,9 +81,94 @@ func LoadRegistryFromFile(fname string) (*Registry, error) {
 	return load(file)
 }
 
+// includeRegistries merges into registry the plugins and reserved sources of
+// the registry files at the given include paths, which are relative to the
+// directory of the including file from. Including a directory includes all
+// the .yaml and .yml files in it, in lexical order. Included files can include
+// other files in turn. sources maps the names of the plugins merged so far to
+// the file defining them, so that a plugin can't be defined by multiple files,
+// and included tracks the files already included.
+func includeRegistries(registry *Registry, from string, includes []string, sources map[string]string,
+	included map[string]bool) error {
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(from), include)
+		}
+		fnames, err := registryFiles(include)
+		if err != nil {
+			return fmt.Errorf("unable to include %q from %q: %w", include, from, err)
+		}
+		for _, fname := range fnames {
+			abs, err := filepath.Abs(fname)
+			if err != nil {
+				return err
+			}
+			if included[abs] {
+				return fmt.Errorf("registry file %q is included more than once", fname)
+			}
+			included[abs] = true
+
+			inc, err := loadRegistryFromLocalFile(fname)
+			if err != nil {
+				return fmt.Errorf("unable to load included registry file %q: %w", fname, err)
+			}
+			for _, p := range inc.Plugins {
+				if source, ok := sources[p.Name]; ok {
+					return fmt.Errorf("plugin %q is defined in both %q and %q", p.Name, source, fname)
+				}
+				sources[p.Name] = fname
+			}
+			registry.Plugins = append(registry.Plugins, inc.Plugins...)
+			registry.ReservedSources = append(registry.ReservedSources, inc.ReservedSources...)
+			if err := includeRegistries(registry, fname, inc.Include, sources, included); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// registryFiles returns the registry files at path, which is either a single
+// file or a directory containing .yaml and .yml files.
+func registryFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var fnames []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			fnames = append(fnames, filepath.Join(path, e.Name()))
+		}
+	}
+	return fnames, nil
+}
+
 // load reads from a io.Reader and uses the content to populate and
 // return a new instance of Registry
 func load(r io.Reader) (*Registry, error) {
 	registry := &Registry{}
 	return registry, registry.Decode(r)
 }
+
+// loadRegistryFromURL downloads the registry from a http(s) URL.
+func loadRegistryFromURL(url string) (*Registry, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download registry from %q: %s", url, resp.Status)
+	}
+	return load(resp.Body)
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRegistry = `
+reserved_sources: ["syscall"]
+plugins:
+  - name: dummy
+    description: A dummy plugin
+    authors: The Falco Authors
+    contact: github.com/falcosecurity/plugins
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/dummy
+    license: Apache-2.0
+    capabilities:
+      sourcing:
+        supported: true
+        id: 999
+        source: dummy
+`
+
+func assertTestRegistry(t *testing.T, reg *Registry) {
+	assert.Equal(t, []string{"syscall"}, reg.ReservedSources)
+	if assert.Len(t, reg.Plugins, 1) {
+		assert.Equal(t, "dummy", reg.Plugins[0].Name)
+		assert.Equal(t, uint(999), reg.Plugins[0].Capabilities.Sourcing.ID)
+	}
+}
+
+func TestLoadRegistryFromLocalFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(testRegistry), 0o600))
+
+	reg, err := LoadRegistryFromFile(path)
+	assert.NoError(t, err)
+	assertTestRegistry(t, reg)
+
+	_, err = LoadRegistryFromFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestLoadRegistryFromStdin(t *testing.T) {
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = strings.NewReader(testRegistry)
+
+	reg, err := LoadRegistryFromFile("-")
+	assert.NoError(t, err)
+	assertTestRegistry(t, reg)
+}
+
+func TestLoadRegistryFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/registry.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testRegistry))
+	}))
+	defer server.Close()
+
+	reg, err := LoadRegistryFromFile(server.URL + "/registry.yaml")
+	assert.NoError(t, err)
+	assertTestRegistry(t, reg)
+
+	_, err = LoadRegistryFromFile(server.URL + "/missing.yaml")
+	assert.ErrorContains(t, err, "404")
+}
+
+// testPluginEntry returns the registry entry of a plugin with the given name
+func testPluginEntry(name string) string {
+	return `
+  - name: ` + name + `
+    description: A test plugin
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/` + name + `
+    license: Apache-2.0
+`
+}
+
+func TestLoadRegistryIncludes(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "plugins"), 0o755))
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	write("plugins/beta.yaml", "plugins:"+testPluginEntry("beta"))
+	write("plugins/alpha.yml", "reserved_sources: [\"k8s_audit\"]\nplugins:"+testPluginEntry("alpha"))
+	write("plugins/README.md", "not a registry file")
+	write("gamma.yaml", "include: [\"delta.yaml\"]\nplugins:"+testPluginEntry("gamma"))
+	write("delta.yaml", "plugins:"+testPluginEntry("delta"))
+	path := write("registry.yaml", testRegistry+"include: [\"plugins\", \"gamma.yaml\"]\n")
+
+	reg, err := LoadRegistryFromFile(path)
+	assert.NoError(t, err)
+	var names []string
+	for _, p := range reg.Plugins {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"dummy", "alpha", "beta", "gamma", "delta"}, names)
+	assert.Equal(t, []string{"syscall", "k8s_audit"}, reg.ReservedSources)
+	assert.Empty(t, reg.Include)
+	assert.NoError(t, reg.Validate())
+
+	// the same plugin can't be defined by multiple files
+	write("delta.yaml", "plugins:"+testPluginEntry("alpha"))
+	_, err = LoadRegistryFromFile(path)
+	assert.ErrorContains(t, err, `plugin "alpha" is defined in both`)
+
+	// nor a file be included multiple times
+	write("delta.yaml", "include: [\"registry.yaml\"]")
+	_, err = LoadRegistryFromFile(path)
+	assert.ErrorContains(t, err, "included more than once")
+
+	write("delta.yaml", "include: [\"missing.yaml\"]")
+	_, err = LoadRegistryFromFile(path)
+	assert.ErrorContains(t, err, "missing.yaml")
+}
+
+func TestLoadRegistryWithOverlays(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	base := write("registry.yaml", testRegistry+testPluginEntry("alpha")+testPluginEntry("beta"))
+	staging := write("staging.yaml", `
+reserved_sources: ["syscall", "k8s_audit"]
+plugins:
+  - name: beta
+    description: The staging beta plugin
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/beta
+    license: Apache-2.0
+  - name: dummy-next
+    description: The renamed dummy plugin
+    url: https://github.com/falcosecurity/plugins/tree/main/plugins/dummy
+    license: Apache-2.0
+    capabilities:
+      sourcing:
+        supported: true
+        id: 999
+        source: dummy
+`+testPluginEntry("gamma"))
+	canary := write("canary.yaml", "plugins:"+testPluginEntry("gamma")+testPluginEntry("delta"))
+
+	// without overlays, the base registry is loaded as is
+	reg, err := LoadRegistryWithOverlays(base)
+	assert.NoError(t, err)
+	assert.Len(t, reg.Plugins, 3)
+
+	// overlays replace the plugins with the same name or source ID, in
+	// place, and append the other ones, in order
+	reg, err = LoadRegistryWithOverlays(base, staging, canary)
+	assert.NoError(t, err)
+	var names []string
+	for _, p := range reg.Plugins {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"dummy-next", "alpha", "beta", "gamma", "delta"}, names)
+	assert.Equal(t, "The staging beta plugin", reg.Plugins[2].Description)
+	assert.Equal(t, "A test plugin", reg.Plugins[3].Description)
+	assert.Equal(t, []string{"syscall", "k8s_audit"}, reg.ReservedSources)
+
+	// the merged registry is validated
+	invalid := write("invalid.yaml", `
+reserved_sources: ["dummy"]
+`)
+	_, err = LoadRegistryWithOverlays(base, invalid)
+	assert.ErrorContains(t, err, "invalid registry merged with the overlays")
+
+	_, err = LoadRegistryWithOverlays(base, filepath.Join(dir, "missing.yaml"))
+	assert.ErrorContains(t, err, "missing.yaml")
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2026 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"slices"
+)
+
+// LoadRegistryWithOverlays loads the registry from the base file fname, as
+// LoadRegistryFromFile does, and merges the given overlay registry files into
+// it in order, so that environment-specific registries don't need to repeat
+// the whole base one. The plugins of an overlay replace the merged ones with
+// the same name, or with the same source ID for source plugins, while the
+// other ones are appended, along with the new reserved sources. The merged
+// registry is validated when there are overlays.
+func LoadRegistryWithOverlays(fname string, overlays ...string) (*Registry, error) {
+	registry, err := LoadRegistryFromFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if len(overlays) == 0 {
+		return registry, nil
+	}
+	for _, overlay := range overlays {
+		o, err := LoadRegistryFromFile(overlay)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load overlay registry file %q: %w", overlay, err)
+		}
+		registry.merge(o)
+	}
+	if err := registry.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid registry merged with the overlays: %w", err)
+	}
+	return registry, nil
+}
+
+// merge merges the plugins and reserved sources of an overlay registry into
+// the registry, see LoadRegistryWithOverlays.
+func (r *Registry) merge(overlay *Registry) {
+	for _, p := range overlay.Plugins {
+		if i := r.overriddenPlugin(&p); i >= 0 {
+			r.Plugins[i] = p
+		} else {
+			r.Plugins = append(r.Plugins, p)
+		}
+	}
+	for _, s := range overlay.ReservedSources {
+		if !slices.Contains(r.ReservedSources, s) {
+			r.ReservedSources = append(r.ReservedSources, s)
+		}
+	}
+}
+
+// overriddenPlugin returns the index of the plugin of the registry that an
+// overlay plugin replaces, or -1 if there is none. Plugins are matched by name
+// first, and then by source ID for source plugins, which lets a renamed source
+// plugin replace its base entry.
+func (r *Registry) overriddenPlugin(p *Plugin) int {
+	for i := range r.Plugins {
+		if r.Plugins[i].Name == p.Name {
+			return i
+		}
+	}
+	if p.Capabilities.Sourcing.Supported {
+		for i := range r.Plugins {
+			sourcing := r.Plugins[i].Capabilities.Sourcing
+			if sourcing.Supported && sourcing.ID == p.Capabilities.Sourcing.ID {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -62,6 +62,10 @@ type Plugin struct {
 type Registry struct {
 	Plugins         []Plugin `yaml:"plugins"`
 	ReservedSources []string `yaml:"reserved_sources"`
+	// Include lists the registry files, or the directories of registry files,
+	// whose plugins and reserved sources are merged into this registry. It is
+	// empty once the registry is loaded with LoadRegistryFromFile.
+	Include []string `yaml:"include,omitempty"`
 }
 
 type ArtifactsPushStatus []ArtifactPushMetadata
@@ -76,6 +80,25 @@ type ArtifactPushMetadata struct {
 type ArtifactMetadata struct {
 	Digest string   `json:"digest"`
 	Tags   []string `json:"tags"`
+	// MissingPlatforms are the platforms whose builds could not be pushed
+	// along with the other ones of the artifact.
+	MissingPlatforms []string `json:"missingPlatforms,omitempty"`
+	// Referrers are the digests of the artifacts attached to the artifact
+	// through the OCI Referrers API.
+	Referrers []string `json:"referrers,omitempty"`
+	// Files are the build files pushed as the layers of the artifact, so
+	// that they can be verified against the registry digests.
+	Files []FileMetadata `json:"files,omitempty"`
+}
+
+// FileMetadata identifies a build file pushed as a layer of an artifact.
+type FileMetadata struct {
+	Name string `json:"name"`
+	// Platform is the platform of the plugin builds, and is empty for rulesfiles.
+	Platform string `json:"platform,omitempty"`
+	// SHA256 is the hex-encoded SHA256 of the file content, which is also the
+	// one of the layer digest.
+	SHA256 string `json:"sha256"`
 }
 
 type RepositoryMetadata struct {
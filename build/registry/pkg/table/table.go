@@ -25,8 +25,10 @@ import (
 	"github.com/falcosecurity/plugins/build/registry/pkg/registry"
 )
 
-func DoTable(registryFile, subFile, subTag string) error {
-	r, err := registry.LoadRegistryFromFile(registryFile)
+// DoTable formats the plugins of the registry file, merged with the given
+// overlay files if any, in a MarkDown table.
+func DoTable(registryFile, subFile, subTag string, overlays ...string) error {
+	r, err := registry.LoadRegistryWithOverlays(registryFile, overlays...)
 	if err != nil {
 		return err
 	}
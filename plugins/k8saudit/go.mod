@@ -5,6 +5,8 @@ go 1.15
 require (
 	github.com/alecthomas/jsonschema v0.0.0-20220216202328-9eeeec9d044b
 	github.com/falcosecurity/plugin-sdk-go v0.7.4
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/iancoleman/orderedmap v0.3.0 // indirect
 	github.com/valyala/fastjson v1.6.4
+	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 )
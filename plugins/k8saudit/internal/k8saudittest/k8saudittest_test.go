@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudittest_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/falcosecurity/plugins/plugins/k8saudit/internal/k8saudittest"
+	"github.com/falcosecurity/plugins/plugins/k8saudit/pkg/k8saudit"
+)
+
+const testEvents = `{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Request","auditID":"1","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/default/pods/web/exec?command=sh","verb":"create","user":{"username":"alice"},"objectRef":{"resource":"pods","namespace":"default","name":"web","subresource":"exec","apiVersion":"v1"},"stageTimestamp":"2024-01-01T00:00:00.000000Z"}
+{"kind":"EventList","apiVersion":"audit.k8s.io/v1","items":[{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Request","auditID":"2","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/kube-system/secrets","verb":"list","user":{"username":"bob"},"objectRef":{"resource":"secrets","namespace":"kube-system","apiVersion":"v1"},"stageTimestamp":"2024-01-01T00:00:01.000000Z"}]}
+`
+
+func newTestPlugin(t *testing.T) *k8saudit.Plugin {
+	p := &k8saudit.Plugin{}
+	if err := p.Init(`{"clusterName":"test"}`); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadEvents(t *testing.T) {
+	p := newTestPlugin(t)
+	evts, err := k8saudittest.ReadEvents(p, []byte(testEvents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evts))
+	}
+	if evts[0].EventNum() == evts[1].EventNum() {
+		t.Fatal("expected events to have distinct numbers")
+	}
+	if evts[1].Timestamp() != 1704067201000000000 {
+		t.Fatalf("unexpected timestamp %d", evts[1].Timestamp())
+	}
+}
+
+func TestReadAndExtract(t *testing.T) {
+	p := newTestPlugin(t)
+	tests := []struct {
+		field    string
+		arg      string
+		expected []interface{}
+	}{
+		{"ka.user.name", "", []interface{}{"alice", "bob"}},
+		{"ka.target.subresource", "", []interface{}{"exec", nil}},
+		{"ka.uri.segment", "5", []interface{}{"web", nil}},
+		{"ka.uri.param", "command", []interface{}{"sh", nil}},
+		// set by the event source, so it can't be extracted from the raw JSON
+		{"ka.cluster.name", "", []interface{}{"test", "test"}},
+	}
+	for _, test := range tests {
+		values, err := k8saudittest.ReadAndExtract(p, []byte(testEvents), test.field, test.arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("%s[%s]: expected %v, got %v", test.field, test.arg, test.expected, values)
+		}
+	}
+
+	if _, err := k8saudittest.ReadAndExtract(p, []byte(testEvents), "ka.unknown", ""); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
+
+func ExampleReadAndExtract() {
+	p := &k8saudit.Plugin{}
+	if err := p.Init("{}"); err != nil {
+		panic(err)
+	}
+	values, err := k8saudittest.ReadAndExtract(p, []byte(testEvents), "ka.verb", "")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(values...)
+	// Output: create list
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk/plugins/source"
+)
+
+// backpressureWarningInterval is the minimum time between two warnings
+// about the consumer of the events being slow
+const backpressureWarningInterval = time.Minute
+
+// backpressureWarnings rate-limits the warnings about the consumer of the
+// events being slow, counting the blocked pushes between two warnings
+type backpressureWarnings struct {
+	mu      sync.Mutex
+	last    time.Time
+	blocked uint64
+}
+
+// pushEvent sends an event to the channel of an opened event source. If the
+// send blocks for longer than the configured threshold, the consumer of the
+// events (usually the rule engine) is slower than the event source: this
+// is counted in the metrics and reported by a rate-limited warning, while
+// still waiting for the event to be sent.
+func (k *Plugin) pushEvent(c chan<- source.PushEvent, evt source.PushEvent) {
+	threshold := time.Duration(k.Config.BackpressureThresholdMs) * time.Millisecond
+	if threshold == 0 {
+		c <- evt
+		return
+	}
+	select {
+	case c <- evt:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(threshold)
+	defer timer.Stop()
+	select {
+	case c <- evt:
+		return
+	case <-timer.C:
+	}
+	k.metrics.inc(&k.metrics.eventPushBlocked)
+	k.warnBackpressure(threshold)
+	c <- evt
+}
+
+func (k *Plugin) warnBackpressure(threshold time.Duration) {
+	b := &k.backpressure
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blocked++
+	if now := time.Now(); now.Sub(b.last) >= backpressureWarningInterval {
+		k.logger.Printf("events are consumed slower than they are produced, the rule engine may be the bottleneck: pushing an event blocked for more than %s %d time(s) since the last warning", threshold, b.blocked)
+		b.last = now
+		b.blocked = 0
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk/plugins/source"
+)
+
+func TestBackpressure(t *testing.T) {
+	p := newTestPlugin(t, `{"backpressureThresholdMs":10}`)
+	var logs bytes.Buffer
+	p.logger = log.New(&logs, "", 0)
+
+	// a slow consumer
+	c := make(chan source.PushEvent)
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range c {
+			n++
+			time.Sleep(30 * time.Millisecond)
+		}
+		received <- n
+	}()
+	for i := 0; i < 3; i++ {
+		p.pushEvent(c, source.PushEvent{Data: []byte(testAuditEvent(time.Now()))})
+	}
+	close(c)
+	if n := <-received; n != 3 {
+		t.Fatalf("expected 3 events to be received, got %d", n)
+	}
+
+	// the first push is not expected to block, and only one warning is logged
+	blocked := p.metrics.eventPushBlocked
+	if blocked < 2 {
+		t.Fatalf("expected at least 2 blocked pushes, got %d", blocked)
+	}
+	if n := strings.Count(logs.String(), "rule engine may be the bottleneck"); n != 1 {
+		t.Fatalf("expected 1 warning, got %d: %s", n, logs.String())
+	}
+	rec := httptest.NewRecorder()
+	p.metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), fmt.Sprintf("k8saudit_event_push_blocked_total %d\n", blocked)) {
+		t.Fatalf("unexpected metrics: %s", rec.Body.String())
+	}
+
+	// a fast consumer
+	p = newTestPlugin(t, `{"backpressureThresholdMs":1000}`)
+	c = make(chan source.PushEvent, 1)
+	p.pushEvent(c, source.PushEvent{})
+	go func() { <-c; <-c }()
+	p.pushEvent(c, source.PushEvent{})
+	if p.metrics.eventPushBlocked != 0 {
+		t.Fatalf("expected no blocked pushes, got %d", p.metrics.eventPushBlocked)
+	}
+}
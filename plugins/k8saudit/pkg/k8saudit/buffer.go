@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// payloadBuffer bounds the total size of the webhook payloads buffered in a
+// channel while waiting to be parsed. The size of each payload is reserved
+// before sending it to the channel, and released once it is received from
+// it. A payload larger than the maximum size is accepted when the buffer is
+// empty, so that it can't be blocked forever.
+type payloadBuffer struct {
+	c       chan []byte
+	max     uint64
+	metrics *sourceMetrics
+
+	mu   sync.Mutex
+	size uint64
+	// released is closed and replaced each time some size is released
+	released chan struct{}
+}
+
+// newPayloadBuffer returns a payloadBuffer for the payloads of c, whose total
+// size is limited to max bytes, or not limited if zero. The buffered size is
+// reported by the given metrics.
+func newPayloadBuffer(c chan []byte, max uint64, metrics *sourceMetrics) *payloadBuffer {
+	return &payloadBuffer{c: c, max: max, metrics: metrics, released: make(chan struct{})}
+}
+
+func (b *payloadBuffer) fits(n uint64) bool {
+	return b.max == 0 || b.size == 0 || b.size+n <= b.max
+}
+
+func (b *payloadBuffer) setSize(size uint64) {
+	b.size = size
+	atomic.StoreUint64(&b.metrics.bufferedBytes, size)
+}
+
+// reserve waits until a payload of n bytes fits in the buffer, and reserves
+// its size. Returns false without reserving anything if done or timeout
+// (either of which may be nil) is signaled before.
+func (b *payloadBuffer) reserve(n uint64, done <-chan struct{}, timeout <-chan time.Time) bool {
+	for {
+		b.mu.Lock()
+		if b.fits(n) {
+			b.setSize(b.size + n)
+			b.mu.Unlock()
+			return true
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-done:
+			return false
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// reserveDroppingOldest reserves the size of a payload of n bytes, removing
+// the oldest payloads from the channel until it fits in the buffer, and
+// returns the removed ones.
+func (b *payloadBuffer) reserveDroppingOldest(n uint64) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var dropped [][]byte
+	for !b.fits(n) {
+		var old []byte
+		var ok bool
+		select {
+		case old, ok = <-b.c:
+		default:
+		}
+		if !ok {
+			// the remaining size is the one of the payloads being received
+			break
+		}
+		dropped = append(dropped, old)
+		b.setSize(b.size - uint64(len(old)))
+	}
+	b.setSize(b.size + n)
+	return dropped
+}
+
+// full returns true if no more payloads can be buffered until some size is
+// released, either because the maximum size or the channel capacity is reached
+func (b *payloadBuffer) full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return (b.max > 0 && b.size > 0 && b.size >= b.max) || len(b.c) == cap(b.c)
+}
+
+// release releases the size of a payload of n bytes
+func (b *payloadBuffer) release(n uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setSize(b.size - n)
+	close(b.released)
+	b.released = make(chan struct{})
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPayloadBufferBlock(t *testing.T) {
+	var metrics sourceMetrics
+	b := newPayloadBuffer(make(chan []byte, 10), 100, &metrics)
+
+	if !b.reserve(60, nil, nil) {
+		t.Fatal("expected the payload to fit in the buffer")
+	}
+	if !b.reserve(40, nil, nil) {
+		t.Fatal("expected the payload to fit in the buffer")
+	}
+	if metrics.bufferedBytes != 100 {
+		t.Fatalf("expected 100 buffered bytes, got %d", metrics.bufferedBytes)
+	}
+
+	// the buffer is full until some size is released
+	if b.reserve(10, nil, time.After(10*time.Millisecond)) {
+		t.Fatal("expected the payload not to fit in the full buffer")
+	}
+	done := make(chan struct{})
+	close(done)
+	if b.reserve(10, done, nil) {
+		t.Fatal("expected the reservation to be canceled")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		b.release(60)
+	}()
+	if !b.reserve(50, nil, time.After(5*time.Second)) {
+		t.Fatal("expected the payload to fit in the buffer once released")
+	}
+	if metrics.bufferedBytes != 90 {
+		t.Fatalf("expected 90 buffered bytes, got %d", metrics.bufferedBytes)
+	}
+
+	// payloads larger than the buffer are accepted alone
+	b.release(90)
+	if !b.reserve(1000, nil, nil) {
+		t.Fatal("expected a large payload to fit in the empty buffer")
+	}
+	if b.reserve(1, nil, time.After(10*time.Millisecond)) {
+		t.Fatal("expected the payload not to fit in the full buffer")
+	}
+
+	// no limit
+	b = newPayloadBuffer(make(chan []byte, 10), 0, &metrics)
+	for i := 0; i < 10; i++ {
+		if !b.reserve(1000, nil, nil) {
+			t.Fatal("expected payloads to always fit with no limit")
+		}
+	}
+}
+
+func TestPayloadBufferDropOldest(t *testing.T) {
+	var metrics sourceMetrics
+	c := make(chan []byte, 10)
+	b := newPayloadBuffer(c, 100, &metrics)
+	for i := 0; i < 5; i++ {
+		payload := []byte(strings.Repeat(fmt.Sprint(i), 40))
+		dropped := b.reserveDroppingOldest(uint64(len(payload)))
+		c <- payload
+		if i >= 2 {
+			// the payload doesn't fit with the two previous ones
+			if len(dropped) != 1 || dropped[0][0] != byte('0'+i-2) {
+				t.Fatalf("expected the oldest payload to be dropped, got %q", dropped)
+			}
+		} else if len(dropped) != 0 {
+			t.Fatalf("expected no dropped payloads, got %q", dropped)
+		}
+	}
+	if metrics.bufferedBytes != 80 || len(c) != 2 {
+		t.Fatalf("expected 2 buffered payloads of 80 bytes, got %d of %d bytes", len(c), metrics.bufferedBytes)
+	}
+	if first := <-c; first[0] != '3' {
+		t.Fatalf("expected the newest payloads to be kept, got %q", first)
+	}
+}
+
+func TestWebhookMaxBufferedBytes(t *testing.T) {
+	event := testAuditEvent(time.Now())
+	maxBufferedBytes := 2*len(event) + 1
+	for _, policy := range []string{bufferFullBlock, bufferFullDropOldest} {
+		p := newTestPlugin(t, fmt.Sprintf(`{"maxBufferedBytes":%d,"bufferFullPolicy":%q,"webhookEnqueueTimeoutMs":50}`, maxBufferedBytes, policy))
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		producer, err := p.startWebServer(addr, "/k8s-audit", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		postTestEvent(t, "http://"+addr+"/k8s-audit")
+
+		// events are not consumed, so that the buffer fills up well before
+		// its maximum number of payloads
+		var statusCodes []int
+		for i := 0; i < 10; i++ {
+			res, err := http.Post("http://"+addr+"/k8s-audit", "application/json", strings.NewReader(event))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			statusCodes = append(statusCodes, res.StatusCode)
+			if buffered := atomic.LoadUint64(&p.metrics.bufferedBytes); buffered > uint64(maxBufferedBytes) {
+				t.Fatalf("expected at most %d buffered bytes, got %d", maxBufferedBytes, buffered)
+			}
+		}
+		last := statusCodes[len(statusCodes)-1]
+		switch policy {
+		case bufferFullBlock:
+			if last != http.StatusServiceUnavailable || p.metrics.webhookEnqueueTimeouts == 0 {
+				t.Fatalf("expected requests to be rejected with a full buffer, got %v", statusCodes)
+			}
+		case bufferFullDropOldest:
+			if last != http.StatusOK || p.metrics.bufferDroppedPayloads == 0 {
+				t.Fatalf("expected payloads to be dropped with a full buffer, got %v", statusCodes)
+			}
+		}
+		go discardEvents(producer.events)
+		producer.close()
+	}
+
+	if err := newTestPlugin(t, "{}").Init(`{"bufferFullPolicy":"dropNewest"}`); err == nil {
+		t.Error("expected an error for an invalid buffer full policy")
+	}
+}
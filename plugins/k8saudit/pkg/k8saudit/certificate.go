@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloader provides the TLS certificate of the webhook server, and
+// reloads it from disk whenever the certificate file changes. This allows
+// rotating the certificate without restarting the listener.
+type certReloader struct {
+	path    string
+	target  string
+	logger  *log.Logger
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// newCertReloader loads the certificate at the given path and starts watching
+// it for changes. If watching is not available, the certificate is loaded once
+// and never reloaded.
+func newCertReloader(path string, logger *log.Logger) (*certReloader, error) {
+	c := &certReloader{path: filepath.Clean(path), logger: logger, done: make(chan struct{})}
+	c.target, _ = filepath.EvalSymlinks(c.path)
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	// note: the parent directory is watched rather than the file itself,
+	// because rotated files are usually replaced atomically (e.g. K8S secrets
+	// are mounted through symlinks that are swapped on update), and a watch on
+	// the old file would be lost
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(path))
+		if err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		logger.Printf("can't watch certificate file, changes won't be reloaded: %s", err.Error())
+		return c, nil
+	}
+	c.watcher = watcher
+	go c.watch()
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	// note: the legacy K8S Audit implementation concatenated the key and cert PEM
+	// files, however this seems to be unusual. Here we use the same concatenated files
+	// for both key and cert, but we may want to split them (this seems to work though).
+	cert, err := tls.LoadX509KeyPair(c.path, c.path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+func (c *certReloader) watch() {
+	for {
+		select {
+		case ev, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			if !c.changed(ev) {
+				continue
+			}
+			// a file being written may be temporarily invalid, in which
+			// case the last valid certificate keeps being used
+			if err := c.reload(); err != nil {
+				c.logger.Printf("can't reload certificate: %s", err.Error())
+			}
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			c.logger.Printf("error while watching certificate file: %s", err.Error())
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// changed returns true if the given event of the watched directory may have
+// changed the certificate file. Events of other files are ignored, except
+// when they change the file the certificate path resolves to, which is what
+// happens when the symlinks of a mounted K8S secret get swapped.
+func (c *certReloader) changed(ev fsnotify.Event) bool {
+	if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return false
+	}
+	if target, err := filepath.EvalSymlinks(c.path); err == nil && target != c.target {
+		c.target = target
+		return true
+	}
+	return filepath.Clean(ev.Name) == c.path
+}
+
+// GetCertificate returns the last loaded certificate, and is meant to be
+// used as tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Close stops watching the certificate file.
+func (c *certReloader) Close() {
+	if c.watcher != nil {
+		close(c.done)
+		c.watcher.Close()
+	}
+}
+
+// sniCertificates provides the TLS certificate of the webhook server matching
+// the server name requested by each client (SNI), so that the same listener
+// can serve multiple hostnames. A default certificate is used when the server
+// name does not match any of the configured ones. All the certificates are
+// reloaded when their file changes, see certReloader.
+type sniCertificates struct {
+	fallback *certReloader
+	hosts    map[string]*certReloader
+}
+
+// newSNICertificates loads the default certificate and the ones of the given
+// hostnames, which can start with a "*." wildcard label.
+func newSNICertificates(defaultPath string, hostPaths map[string]string, logger *log.Logger) (*sniCertificates, error) {
+	fallback, err := newCertReloader(defaultPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	c := &sniCertificates{fallback: fallback, hosts: make(map[string]*certReloader)}
+	for host, path := range hostPaths {
+		certs, err := newCertReloader(path, logger)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("can't load certificate of host %s: %s", host, err.Error())
+		}
+		c.hosts[strings.ToLower(host)] = certs
+	}
+	return c, nil
+}
+
+// GetCertificate returns the certificate matching the server name of the
+// client hello, and is meant to be used as tls.Config.GetCertificate.
+func (c *sniCertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if len(name) > 0 {
+		if certs, ok := c.hosts[name]; ok {
+			return certs.GetCertificate(hello)
+		}
+		if i := strings.Index(name, "."); i > 0 {
+			if certs, ok := c.hosts["*"+name[i:]]; ok {
+				return certs.GetCertificate(hello)
+			}
+		}
+	}
+	return c.fallback.GetCertificate(hello)
+}
+
+// Close stops watching all the certificate files.
+func (c *sniCertificates) Close() {
+	c.fallback.Close()
+	for _, certs := range c.hosts {
+		certs.Close()
+	}
+}
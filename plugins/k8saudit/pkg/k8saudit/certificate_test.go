@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// writeTestCertificate atomically writes a self-signed certificate and its
+// key, concatenated in the same PEM file, with the given serial number
+func writeTestCertificate(t *testing.T, path string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "k8saudit-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTestTLS starts a TLS listener that completes the handshake of each
+// connection with the certificate provided by getCert, and returns its address
+func serveTestTLS(t *testing.T, getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)) string {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: getCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dialTestCertificateSerial returns the serial number of the certificate
+// served at the given address for the given server name, if any
+func dialTestCertificateSerial(t *testing.T, address, serverName string) int64 {
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k8saudit-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cert.pem")
+	writeTestCertificate(t, path, 1)
+
+	certs, err := newCertReloader(path, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certs.Close()
+
+	address := serveTestTLS(t, certs.GetCertificate)
+	if serial := dialTestCertificateSerial(t, address, ""); serial != 1 {
+		t.Fatalf("expected certificate serial 1, got %d", serial)
+	}
+
+	// swap the certificate file, new connections should use the new one
+	writeTestCertificate(t, path, 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		serial := dialTestCertificateSerial(t, address, "")
+		if serial == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected certificate serial 2 after rotation, got %d", serial)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertReloaderSymlinkSwap(t *testing.T) {
+	// mimic the layout of a mounted K8S secret, whose files are symlinks to
+	// a "..data" symlink that gets atomically swapped on update
+	dir, err := ioutil.TempDir("", "k8saudit-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestCertificate(t, filepath.Join(dir, "..v1", "cert.pem"), 1)
+	writeTestCertificate(t, filepath.Join(dir, "..v2", "cert.pem"), 2)
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "cert.pem")
+	if err := os.Symlink(filepath.Join("..data", "cert.pem"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := newCertReloader(path, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certs.Close()
+
+	// events of unrelated files must not cause a reload
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwatched := &certReloader{path: path, target: target}
+	if unwatched.changed(fsnotify.Event{Name: filepath.Join(dir, "other.pem"), Op: fsnotify.Create}) {
+		t.Fatal("expected events of unrelated files to be ignored")
+	}
+
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, _ := certs.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaf.SerialNumber.Int64() == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected certificate serial 2 after the symlink swap, got %d", leaf.SerialNumber.Int64())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCertReloaderInvalidFile(t *testing.T) {
+	_, err := newCertReloader(filepath.Join(os.TempDir(), "k8saudit-missing.pem"), log.New(ioutil.Discard, "", 0))
+	if err == nil {
+		t.Fatal("expected an error for a missing certificate file")
+	}
+}
+
+func TestSNICertificates(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "default.pem"), filepath.Join(dir, "prod.pem"), filepath.Join(dir, "staging.pem")}
+	for i, path := range paths {
+		writeTestCertificate(t, path, int64(i+1))
+	}
+
+	certs, err := newSNICertificates(paths[0], map[string]string{
+		"prod.audit.example.com": paths[1],
+		"*.staging.example.com":  paths[2],
+	}, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer certs.Close()
+
+	address := serveTestTLS(t, certs.GetCertificate)
+	for serverName, expected := range map[string]int64{
+		"":                          1,
+		"unknown.example.com":       1,
+		"prod.audit.example.com":    2,
+		"PROD.Audit.example.com":    2,
+		"audit.staging.example.com": 3,
+		"staging.example.com":       1,
+	} {
+		if serial := dialTestCertificateSerial(t, address, serverName); serial != expected {
+			t.Errorf("expected certificate serial %d for server name %q, got %d", expected, serverName, serial)
+		}
+	}
+
+	// all certificates must be valid
+	_, err = newSNICertificates(paths[0], map[string]string{"prod.audit.example.com": filepath.Join(dir, "missing.pem")}, log.New(ioutil.Discard, "", 0))
+	if err == nil {
+		t.Fatal("expected an error for a missing certificate file")
+	}
+}
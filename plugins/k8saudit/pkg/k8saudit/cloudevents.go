@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+const (
+	// eventFormatRaw and eventFormatCloudEvents are the supported values of
+	// the eventFormat config
+	eventFormatRaw         = "raw"
+	eventFormatCloudEvents = "cloudevents"
+
+	// cloudEventType is the type attribute of the CloudEvents envelopes
+	cloudEventType = "io.k8s.audit"
+
+	// cloudEventDefaultSource is the source attribute of the CloudEvents
+	// envelopes of the events with no cluster name
+	cloudEventDefaultSource = pluginName
+)
+
+// wrapCloudEvent wraps a single audit event in a CloudEvents v1.0 JSON
+// envelope (see https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/formats/json-format.md).
+// The source of the envelope is the cluster name of the event, and the
+// subject is the path of the resource it refers to, if any. Since all the
+// stages of a request share the same audit ID, the id of the envelope
+// includes the stage as well.
+func wrapCloudEvent(value *fastjson.Value) *fastjson.Value {
+	var arena fastjson.Arena
+	envelope := arena.NewObject()
+	envelope.Set("specversion", arena.NewString("1.0"))
+
+	id := string(value.GetStringBytes("auditID"))
+	if stage := value.GetStringBytes("stage"); len(stage) > 0 {
+		id += "-" + string(stage)
+	}
+	envelope.Set("id", arena.NewString(id))
+
+	source := string(value.GetStringBytes("annotations", annotationClusterName))
+	if len(source) == 0 {
+		source = cloudEventDefaultSource
+	}
+	envelope.Set("source", arena.NewString(source))
+	envelope.Set("type", arena.NewString(cloudEventType))
+	if subject := cloudEventSubject(value); len(subject) > 0 {
+		envelope.Set("subject", arena.NewString(subject))
+	}
+	envelope.Set("time", arena.NewStringBytes(value.GetStringBytes("stageTimestamp")))
+	envelope.Set("datacontenttype", arena.NewString("application/json"))
+	envelope.Set("data", value)
+	return envelope
+}
+
+// cloudEventSubject returns the path of the resource an audit event refers
+// to, in the form of the K8S API paths (e.g. namespaces/default/pods/nginx/exec),
+// or an empty string if the event has no resource
+func cloudEventSubject(value *fastjson.Value) string {
+	objectRef := value.Get("objectRef")
+	if objectRef == nil {
+		return ""
+	}
+	var parts []string
+	if namespace := objectRef.GetStringBytes("namespace"); len(namespace) > 0 {
+		parts = append(parts, "namespaces", string(namespace))
+	}
+	for _, key := range []string{"resource", "name", "subresource"} {
+		if v := objectRef.GetStringBytes(key); len(v) > 0 {
+			parts = append(parts, string(v))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// unwrapCloudEvent returns the audit event contained in a CloudEvents
+// envelope, or the given value if it is not an envelope
+func unwrapCloudEvent(value *fastjson.Value) *fastjson.Value {
+	if value.Get("specversion") == nil {
+		return value
+	}
+	if data := value.Get("data"); data != nil && data.Type() == fastjson.TypeObject {
+		return data
+	}
+	return value
+}
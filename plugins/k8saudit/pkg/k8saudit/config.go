@@ -19,11 +19,70 @@ package k8saudit
 
 import "github.com/falcosecurity/plugin-sdk-go/pkg/sdk"
 
+const (
+	// fileFormatJSONL and fileFormatConcatenated are the supported values of
+	// the fileFormat config
+	fileFormatJSONL        = "jsonl"
+	fileFormatConcatenated = "concatenated"
+
+	// fileSinceInclude and fileSinceDrop are the supported values of the
+	// fileSinceInvalidTimestamp config
+	fileSinceInclude = "include"
+	fileSinceDrop    = "drop"
+
+	// parsingStrict and parsingLenient are the supported values of the
+	// parsing config
+	parsingStrict  = "strict"
+	parsingLenient = "lenient"
+
+	// bufferFullBlock and bufferFullDropOldest are the supported values of
+	// the bufferFullPolicy config
+	bufferFullBlock      = "block"
+	bufferFullDropOldest = "dropOldest"
+)
+
 type PluginConfig struct {
-	SSLCertificate      string `json:"sslCertificate"       jsonschema:"title=SSL certificate,description=The SSL Certificate to be used with the HTTPS Webhook endpoint (Default: /etc/falco/falco.pem),default=/etc/falco/falco.pem"`
-	UseAsync            bool   `json:"useAsync"             jsonschema:"title=Use async extraction,description=If true then async extraction optimization is enabled (Default: true),default=true"`
-	MaxEventSize        uint64 `json:"maxEventSize"         jsonschema:"title=Maximum event size,description=Maximum size of single audit event (Default: 262144),default=262144"`
-	WebhookMaxBatchSize uint64 `json:"webhookMaxBatchSize"  jsonschema:"title=Maximum webhook request size,description=Maximum size of incoming webhook POST request bodies (Default: 12582912),default=12582912"`
+	SSLCertificate               string            `json:"sslCertificate"               jsonschema:"title=SSL certificate,description=The SSL Certificate to be used with the HTTPS Webhook endpoint (Default: /etc/falco/falco.pem),default=/etc/falco/falco.pem"`
+	SSLCertificates              map[string]string `json:"sslCertificates"              jsonschema:"title=SSL certificates by hostname,description=Additional SSL Certificates to be used with the HTTPS Webhook endpoint mapping hostnames to certificate files; the certificate is selected by the server name requested by clients (SNI) and hostnames can start with a *. wildcard. The sslCertificate one is used when no hostname matches (Default: empty)"`
+	UseAsync                     bool              `json:"useAsync"                     jsonschema:"title=Use async extraction,description=If true then async extraction optimization is enabled (Default: true),default=true"`
+	MaxEventSize                 uint64            `json:"maxEventSize"                 jsonschema:"title=Maximum event size,description=Maximum size of single audit event (Default: 262144),default=262144"`
+	WebhookMaxBatchSize          uint64            `json:"webhookMaxBatchSize"          jsonschema:"title=Maximum webhook request size,description=Maximum size of incoming webhook POST request bodies; larger requests are rejected with 413 Request Entity Too Large (Default: 12582912),default=12582912"`
+	ClusterName                  string            `json:"clusterName"                  jsonschema:"title=Cluster name,description=If not empty the cluster name attached to all the events read by the event source that don't specify one already; exposed through the ka.cluster.name field (Default: empty),default="`
+	WebhookRateLimit             uint64            `json:"webhookRateLimit"             jsonschema:"title=Webhook rate limit,description=Maximum number of webhook requests accepted per second; exceeding requests are rejected with 429 Too Many Requests. Zero means no limit (Default: 0),default=0"`
+	WebhookRateLimitBurst        uint64            `json:"webhookRateLimitBurst"        jsonschema:"title=Webhook rate limit burst,description=Maximum number of webhook requests accepted in a single burst when rate limiting is enabled. Zero means the same as webhookRateLimit (Default: 0),default=0"`
+	ClientKinds                  map[string]string `json:"clientKinds"                  jsonschema:"title=Client kinds,description=Additional rules for the ka.client.kind field mapping user agent prefixes to client kinds; they are checked before the default ones (Default: empty)"`
+	WebhookMetricsPath           string            `json:"webhookMetricsPath"           jsonschema:"title=Webhook metrics path,description=The HTTP path on which the webhook server exposes metrics in the Prometheus text format; disabled if empty (Default: empty),default="`
+	WebhookHealthzPath           string            `json:"webhookHealthzPath"           jsonschema:"title=Webhook liveness probe path,description=The HTTP path on which the webhook server exposes a liveness probe; returning 503 once the server failed or its event parser stopped or the event source is closing and 200 otherwise; disabled if empty (Default: empty),default="`
+	WebhookReadyzPath            string            `json:"webhookReadyzPath"            jsonschema:"title=Webhook readiness probe path,description=The HTTP path on which the webhook server exposes a readiness probe; returning 200 only once the listener is bound and as long as the liveness probe succeeds and the payload buffer is not full; disabled if empty (Default: empty),default="`
+	DeadLetterPath               string            `json:"deadLetterPath"               jsonschema:"title=Dead-letter file path,description=The path of a file where the payloads that are rejected or that can't be parsed are appended for later inspection; disabled if empty (Default: empty),default="`
+	DeadLetterMaxSize            uint64            `json:"deadLetterMaxSize"            jsonschema:"title=Dead-letter file maximum size,description=Maximum size in bytes of the dead-letter file; when exceeded the file is rotated keeping a single backup with the .1 suffix. Zero means no limit (Default: 10485760),default=10485760"`
+	DebugSink                    string            `json:"debugSink"                    jsonschema:"title=Debug sink,description=Where to write a copy of each raw payload received by the event source as is and followed by a newline for troubleshooting; either a file path or stderr; disabled if empty (Default: empty),default="`
+	DebugSinkMaxSize             uint64            `json:"debugSinkMaxSize"             jsonschema:"title=Debug sink maximum size,description=Maximum size in bytes of the debug sink file; when exceeded the file is rotated keeping a single backup with the .1 suffix. Zero means no limit (Default: 104857600),default=104857600"`
+	FileFormat                   string            `json:"fileFormat"                   jsonschema:"title=File format,description=The format of the audit events read from files; either jsonl for one JSON object per line or concatenated for JSON objects concatenated with no separator (Default: jsonl),enum=jsonl,enum=concatenated,default=jsonl"`
+	FileSince                    string            `json:"fileSince"                    jsonschema:"title=File events maximum age,description=Only the events read from files whose stageTimestamp is within this duration from their ingestion (e.g. 6h or 30m) are pushed while the older ones are skipped; disabled if empty (Default: empty),default="`
+	FileSinceInvalidTimestamp    string            `json:"fileSinceInvalidTimestamp"    jsonschema:"title=File events with invalid timestamps,description=What to do with the events read from files whose stageTimestamp can't be parsed when fileSince is set; either include to report them as errors as usual or drop to skip them silently (Default: include),enum=include,enum=drop,default=include"`
+	EventFormat                  string            `json:"eventFormat"                  jsonschema:"title=Event format,description=The format of the events pushed by the event source; either raw for the audit events as received or cloudevents for the audit events wrapped in CloudEvents v1.0 JSON envelopes (Default: raw),enum=raw,enum=cloudevents,default=raw"`
+	Parsing                      string            `json:"parsing"                      jsonschema:"title=Parsing strictness,description=How timestamps and numbers are parsed; either strict to only accept RFC 3339 timestamps and integer numbers or numeric strings or lenient to accept timestamps without time zone or with a space separator or in the RFC 1123 format and numbers with surrounding spaces or in decimal or exponent notation when their value is an integer (Default: strict),enum=strict,enum=lenient,default=strict"`
+	BackpressureThresholdMs      uint64            `json:"backpressureThresholdMs"      jsonschema:"title=Backpressure threshold,description=Time in milliseconds after which pushing an event to a slow consumer is reported with a rate-limited warning and a metric. Zero means no reporting (Default: 1000),default=1000"`
+	WebhookReadHeaderTimeout     uint64            `json:"webhookReadHeaderTimeout"     jsonschema:"title=Webhook read header timeout,description=Maximum time in seconds allowed to read the headers of a webhook request. Zero means no timeout (Default: 10),default=10"`
+	WebhookReadTimeout           uint64            `json:"webhookReadTimeout"           jsonschema:"title=Webhook read timeout,description=Maximum time in seconds allowed to read a whole webhook request including its body. Zero means no timeout (Default: 60),default=60"`
+	WebhookIdleTimeout           uint64            `json:"webhookIdleTimeout"           jsonschema:"title=Webhook idle timeout,description=Maximum time in seconds a keep-alive connection to the webhook can stay idle. Zero means no timeout (Default: 120),default=120"`
+	WebhookEnqueueTimeoutMs      uint64            `json:"webhookEnqueueTimeoutMs"      jsonschema:"title=Webhook enqueue timeout,description=If set the webhook acknowledges each request only once its payload is enqueued and rejects it with 503 Service Unavailable so that the K8S API server retries it when it can't be enqueued within this time in milliseconds. Zero means acknowledging each request right away and waiting for its payload to be enqueued (Default: 0),default=0"`
+	WebhookLogSuccessEvery       uint64            `json:"webhookLogSuccessEvery"       jsonschema:"title=Webhook success logging rate,description=Log one of every this number of successful webhook requests; errors are always logged. Zero means no logging based on the number of requests (Default: 0),default=0"`
+	WebhookLogSuccessInterval    uint64            `json:"webhookLogSuccessInterval"    jsonschema:"title=Webhook success logging interval,description=Log a successful webhook request once this number of seconds has elapsed since the last logged one; errors are always logged. Zero means no logging based on time (Default: 0),default=0"`
+	MaxBufferedBytes             uint64            `json:"maxBufferedBytes"             jsonschema:"title=Maximum buffered bytes,description=Maximum total size in bytes of the webhook payloads buffered while waiting to be parsed when the events are consumed slower than they are received; a single payload larger than this is still buffered alone. Zero means no limit (Default: 0),default=0"`
+	BufferFullPolicy             string            `json:"bufferFullPolicy"             jsonschema:"title=Buffer full policy,description=What to do with a webhook payload that exceeds maxBufferedBytes; either block to wait until it fits in the buffer or dropOldest to drop the oldest buffered payloads to make room for it (Default: block),enum=block,enum=dropOldest,default=block"`
+	WebhookTLSNextProtos         []string          `json:"webhookTLSNextProtos"         jsonschema:"title=Webhook TLS ALPN protocols,description=The ALPN protocols advertised by the HTTPS webhook server in order of preference among h2 and http/1.1; HTTP/2 is disabled if h2 is not listed and http/1.1 is always accepted as a fallback. Empty means h2 and http/1.1 (Default: empty)"`
+	ForwardURL                   string            `json:"forwardURL"                   jsonschema:"title=Forward URL,description=The HTTP or HTTPS URL of the webhook of another instance to which the payloads received by the webhook are forwarded as received; allowing to chain instances; only one target is supported; disabled if empty (Default: empty),default="`
+	ForwardOnly                  bool              `json:"forwardOnly"                  jsonschema:"title=Forward only,description=If true the payloads received by the webhook are only forwarded to forwardURL and their events are not pushed by the event source; payloads that can't wait to be forwarded are rejected with 503 so that the sender retries them (Default: false),default=false"`
+	ForwardTimeout               uint64            `json:"forwardTimeout"               jsonschema:"title=Forward timeout,description=Maximum time in seconds allowed for each request to forwardURL. Zero means no timeout (Default: 10),default=10"`
+	ForwardMaxRetries            uint64            `json:"forwardMaxRetries"            jsonschema:"title=Forward maximum retries,description=Maximum number of times a request to forwardURL is retried when it fails with a network error or a 429 or 5xx response status (Default: 3),default=3"`
+	ForwardRetryBackoffMs        uint64            `json:"forwardRetryBackoffMs"        jsonschema:"title=Forward retry backoff,description=Time in milliseconds waited before the first retry of a request to forwardURL; doubled at each following retry (Default: 500),default=500"`
+	ForwardTLSCACertificate      string            `json:"forwardTLSCACertificate"      jsonschema:"title=Forward TLS CA certificate,description=The PEM file of the CA certificates used to verify the certificate of an HTTPS forwardURL; the system ones are used if empty (Default: empty),default="`
+	ForwardTLSClientCertificate  string            `json:"forwardTLSClientCertificate"  jsonschema:"title=Forward TLS client certificate,description=The PEM file containing both the certificate and the key presented to an HTTPS forwardURL requiring client authentication; disabled if empty (Default: empty),default="`
+	ForwardTLSInsecureSkipVerify bool              `json:"forwardTLSInsecureSkipVerify" jsonschema:"title=Forward TLS insecure skip verify,description=If true the certificate of an HTTPS forwardURL is not verified; only meant for testing (Default: false),default=false"`
+	ShutdownGracePeriodMs        uint64            `json:"shutdownGracePeriodMs"        jsonschema:"title=Shutdown grace period,description=Maximum time in milliseconds the event source waits on close for its pending events to be consumed and its in-flight webhook requests to complete; after which they are dropped with a warning. Zero means dropping them right away (Default: 5000),default=5000"`
+	SensitiveHostPaths           []string          `json:"sensitiveHostPaths"           jsonschema:"title=Sensitive host paths,description=The absolute host paths whose mount by a pod is reported by the ka.req.pod.mounts_sensitive_path field; mounting a path below or above one of them counts as well (Default: /proc /sys /dev /etc /root /boot /var/lib/kubelet and the Docker socket and the containerd and CRI-O directories under /run and /var/run)"`
 }
 
 // Resets sets the configuration to its default values
@@ -38,4 +97,59 @@ func (k *PluginConfig) Reset() {
 	// The following values have been chosen by increasing by ~20% the default
 	// values of the K8S docs
 	k.WebhookMaxBatchSize = 12 * 1024 * 1024
+	k.ClusterName = ""
+	k.WebhookRateLimit = 0
+	k.WebhookRateLimitBurst = 0
+	k.WebhookMetricsPath = ""
+	k.WebhookHealthzPath = ""
+	k.WebhookReadyzPath = ""
+	k.DeadLetterPath = ""
+	k.DeadLetterMaxSize = 10 * 1024 * 1024
+	k.DebugSink = ""
+	k.DebugSinkMaxSize = 100 * 1024 * 1024
+	k.FileFormat = fileFormatJSONL
+	k.FileSince = ""
+	k.FileSinceInvalidTimestamp = fileSinceInclude
+	k.EventFormat = eventFormatRaw
+	k.Parsing = parsingStrict
+	k.BackpressureThresholdMs = 1000
+
+	// The timeouts of the webhook server protect it from slow or hung clients.
+	// The read timeout leaves enough time to receive the largest allowed
+	// batches from the K8S API server
+	k.WebhookReadHeaderTimeout = 10
+	k.WebhookReadTimeout = 60
+	k.WebhookIdleTimeout = 120
+	k.WebhookEnqueueTimeoutMs = 0
+	k.WebhookLogSuccessEvery = 0
+	k.WebhookLogSuccessInterval = 0
+	k.MaxBufferedBytes = 0
+	k.BufferFullPolicy = bufferFullBlock
+	k.ForwardURL = ""
+	k.ForwardOnly = false
+	k.ForwardTimeout = 10
+	k.ForwardMaxRetries = 3
+	k.ForwardRetryBackoffMs = 500
+	k.ForwardTLSCACertificate = ""
+	k.ForwardTLSClientCertificate = ""
+	k.ForwardTLSInsecureSkipVerify = false
+	k.ShutdownGracePeriodMs = 5000
+
+	// Mounting these host paths allows reading secrets of the node, or
+	// escaping the container through the container runtime or the kernel
+	k.SensitiveHostPaths = []string{
+		"/proc",
+		"/sys",
+		"/dev",
+		"/etc",
+		"/root",
+		"/boot",
+		"/var/lib/kubelet",
+		"/run/docker.sock",
+		"/var/run/docker.sock",
+		"/run/containerd",
+		"/var/run/containerd",
+		"/run/crio",
+		"/var/run/crio",
+	}
 }
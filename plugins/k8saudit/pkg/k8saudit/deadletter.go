@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deadLetterFile stores the payloads that have been rejected or that
+// failed to be parsed, so that they can be inspected later. Each payload
+// is appended after a header line containing a timestamp and the reason
+// of the failure. When the file would grow beyond maxSize, it is rotated
+// to a single backup file with the ".1" suffix.
+type deadLetterFile struct {
+	file *rotatingFile
+}
+
+func newDeadLetterFile(path string, maxSize uint64) *deadLetterFile {
+	return &deadLetterFile{file: newRotatingFile(path, maxSize)}
+}
+
+func (d *deadLetterFile) write(reason string, payload []byte) error {
+	// the reason is kept on the header line, whatever it contains
+	reason = strings.ReplaceAll(reason, "\n", " ")
+	record := fmt.Sprintf("--- %s %s\n%s\n", time.Now().UTC().Format(time.RFC3339Nano), reason, payload)
+	return d.file.append([]byte(record))
+}
+
+func (d *deadLetterFile) close() error {
+	return d.file.close()
+}
+
+// rotatingFile is a file to which records are appended. When the file would
+// grow beyond maxSize, it is rotated to a single backup file with the ".1"
+// suffix. A zero maxSize means no limit. The file is kept open between the
+// records, and its size is only read when opening it.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+func newRotatingFile(path string, maxSize uint64) *rotatingFile {
+	return &rotatingFile{path: path, maxSize: int64(maxSize)}
+}
+
+func (r *rotatingFile) append(record []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(record)) > r.maxSize {
+		r.f.Close()
+		r.f = nil
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	n, err := r.f.Write(record)
+	r.size += int64(n)
+	return err
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// writeDeadLetter records a payload in the dead-letter file, if one
+// is configured
+func (k *Plugin) writeDeadLetter(reason string, payload []byte) {
+	if k.deadLetters == nil {
+		return
+	}
+	if err := k.deadLetters.write(reason, payload); err != nil {
+		k.logger.Printf("can't write to dead-letter file: %s", err.Error())
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package k8saudit
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// debugSinkStderr is the debug sink target writing to the standard error
+const debugSinkStderr = "stderr"
+
+// debugSink receives a copy of each raw payload received by the event
+// sources, as is and followed by a newline, for troubleshooting. Payloads are
+// written either to the standard error or to a file, which is rotated like
+// the dead-letter one.
+type debugSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *rotatingFile
+}
+
+func newDebugSink(target string, maxSize uint64) *debugSink {
+	if target == debugSinkStderr {
+		return &debugSink{w: os.Stderr}
+	}
+	return &debugSink{file: newRotatingFile(target, maxSize)}
+}
+
+func (d *debugSink) write(data []byte) error {
+	record := make([]byte, 0, len(data)+1)
+	record = append(append(record, data...), '\n')
+	if d.file != nil {
+		return d.file.append(record)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.w.Write(record)
+	return err
+}
+
+func (d *debugSink) close() error {
+	if d.file != nil {
+		return d.file.close()
+	}
+	return nil
+}
+
+// writeDebugSink tees a payload to the debug sink, if one is configured
+func (k *Plugin) writeDebugSink(data []byte) {
+	if k.debugSink == nil {
+		return
+	}
+	if err := k.debugSink.write(data); err != nil {
+		k.logger.Printf("can't write to debug sink: %s", err.Error())
+	}
+}
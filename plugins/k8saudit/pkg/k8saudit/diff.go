@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/valyala/fastjson"
+)
+
+// serverManagedPaths are the paths of the object metadata set by the API
+// server, which are ignored when looking for changed fields
+var serverManagedPaths = map[string]bool{
+	"metadata.managedFields":     true,
+	"metadata.resourceVersion":   true,
+	"metadata.generation":        true,
+	"metadata.uid":               true,
+	"metadata.creationTimestamp": true,
+}
+
+// changedFields returns the sorted JSON paths of the values that differ
+// between two objects. Objects are compared key by key and arrays index
+// by index, and a path is returned for each differing leaf value. Values
+// that exist in only one of the objects are considered changed.
+func changedFields(a, b *fastjson.Value) []string {
+	var res []string
+	diffJSON(a, b, "", &res)
+	sort.Strings(res)
+	return res
+}
+
+func diffJSON(a, b *fastjson.Value, path string, res *[]string) {
+	if serverManagedPaths[path] {
+		return
+	}
+	// objects existing on one side only are visited anyway, so that the
+	// server managed paths they contain are still ignored
+	if a == nil && b != nil && b.Type() == fastjson.TypeObject {
+		a, b = b, a
+	}
+	if a != nil && b == nil && a.Type() == fastjson.TypeObject {
+		obj, _ := a.Object()
+		obj.Visit(func(key []byte, v *fastjson.Value) {
+			diffJSON(v, nil, joinJSONPath(path, string(key)), res)
+		})
+		return
+	}
+	if a == nil || b == nil || a.Type() != b.Type() {
+		*res = append(*res, path)
+		return
+	}
+	switch a.Type() {
+	case fastjson.TypeObject:
+		aObj, _ := a.Object()
+		bObj, _ := b.Object()
+		aObj.Visit(func(key []byte, v *fastjson.Value) {
+			k := string(key)
+			diffJSON(v, bObj.Get(k), joinJSONPath(path, k), res)
+		})
+		bObj.Visit(func(key []byte, v *fastjson.Value) {
+			k := string(key)
+			if aObj.Get(k) == nil {
+				diffJSON(nil, v, joinJSONPath(path, k), res)
+			}
+		})
+	case fastjson.TypeArray:
+		aArr, _ := a.Array()
+		bArr, _ := b.Array()
+		for i := 0; i < len(aArr) || i < len(bArr); i++ {
+			var aVal, bVal *fastjson.Value
+			if i < len(aArr) {
+				aVal = aArr[i]
+			}
+			if i < len(bArr) {
+				bVal = bArr[i]
+			}
+			diffJSON(aVal, bVal, path+"["+strconv.Itoa(i)+"]", res)
+		}
+	default:
+		if a.String() != b.String() {
+			*res = append(*res, path)
+		}
+	}
+}
+
+func joinJSONPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
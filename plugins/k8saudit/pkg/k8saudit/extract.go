@@ -22,6 +22,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk"
@@ -116,6 +118,8 @@ func (e *Plugin) ExtractFromEvent(req sdk.ExtractRequest, evt sdk.EventReader) e
 // ExtractFromJSON processes a sdk.ExtractRequest and extracts a
 // field by reading data from a jsonValue *fastjson.Value
 func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Value) error {
+	// events pushed with the cloudevents format are wrapped in an envelope
+	jsonValue = unwrapCloudEvent(jsonValue)
 	// discard unrelated JSONs events
 	if jsonValue.Get("auditID") == nil {
 		return ErrExtractNotAvailable
@@ -125,6 +129,8 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 		return e.extractFromKeys(req, jsonValue, "auditID")
 	case "ka.stage":
 		return e.extractFromKeys(req, jsonValue, "stage")
+	case "ka.level":
+		return e.extractFromKeys(req, jsonValue, "level")
 	case "ka.auth.decision":
 		return e.extractFromKeys(req, jsonValue, "annotations", "authorization.k8s.io/decision")
 	case "ka.auth.reason":
@@ -139,20 +145,39 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 		return e.extractFromKeys(req, jsonValue, "user", "groups")
 	case "ka.impuser.name":
 		return e.extractFromKeys(req, jsonValue, "impersonatedUser", "username")
+	case "ka.token.issuer":
+		issuer, _ := e.tokenSubject(jsonValue)
+		if len(issuer) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(issuer)
+	case "ka.token.audiences":
+		var arr []*fastjson.Value
+		switch {
+		case e.isTokenReview(jsonValue):
+			arr = jsonValue.GetArray("responseObject", "status", "audiences")
+			if arr == nil {
+				arr = jsonValue.GetArray("requestObject", "spec", "audiences")
+			}
+		case e.isTokenRequest(jsonValue):
+			arr = jsonValue.GetArray("requestObject", "spec", "audiences")
+		}
+		if arr == nil {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.token.sub":
+		_, sub := e.tokenSubject(jsonValue)
+		if len(sub) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(sub)
 	case "ka.verb":
 		return e.extractFromKeys(req, jsonValue, "verb")
 	case "ka.uri":
 		return e.extractFromKeys(req, jsonValue, "requestURI")
 	case "ka.uri.param":
-		uriValue := jsonValue.Get("requestURI")
-		if uriValue == nil {
-			return ErrExtractNotAvailable
-		}
-		uriString, err := e.jsonValueAsString(uriValue)
-		if err != nil {
-			return err
-		}
-		uri, err := url.Parse(uriString)
+		uri, err := e.readRequestURI(jsonValue)
 		if err != nil {
 			return err
 		}
@@ -164,10 +189,51 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 		if len(param) > 0 {
 			req.SetValue(param[0])
 		}
+	case "ka.uri.query":
+		uri, err := e.readRequestURI(jsonValue)
+		if err != nil {
+			return err
+		}
+		query, err := encodeQuery(uri.RawQuery)
+		if err != nil {
+			return err
+		}
+		req.SetValue(query)
+	case "ka.uri.path":
+		uri, err := e.readRequestURI(jsonValue)
+		if err != nil {
+			return err
+		}
+		// note: segments are escaped again, so that escaped slashes can't
+		// be confused with the ones separating the segments
+		segments := e.uriPathSegments(uri)
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		req.SetValue("/" + strings.Join(segments, "/"))
+	case "ka.uri.segment":
+		uri, err := e.readRequestURI(jsonValue)
+		if err != nil {
+			return err
+		}
+		segments := e.uriPathSegments(uri)
+		if index := int(req.ArgIndex()); index < len(segments) {
+			req.SetValue(segments[index])
+		}
 	case "ka.target.name":
 		return e.extractFromKeys(req, jsonValue, "objectRef", "name")
 	case "ka.target.namespace":
 		return e.extractFromKeys(req, jsonValue, "objectRef", "namespace")
+	case "ka.target.scope":
+		objectRef := jsonValue.Get("objectRef")
+		if objectRef == nil {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(targetScope(objectRef))
+	case "ka.target.namespace.label":
+		return e.extractFromJSONAnnotation(req, jsonValue, annotationNamespaceLabels, req.ArgKey())
+	case "ka.target.namespace.annotation":
+		return e.extractFromJSONAnnotation(req, jsonValue, annotationNamespaceAnnotations, req.ArgKey())
 	case "ka.target.resource":
 		return e.extractFromKeys(req, jsonValue, "objectRef", "resource")
 	case "ka.target.subresource":
@@ -181,6 +247,19 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 				return e.extractFromKeys(req, jsonValue, "responseObject", "metadata", "name")
 			}
 		}
+	case "ka.req.name":
+		return e.extractFromKeys(req, jsonValue, "requestObject", "metadata", "name")
+	case "ka.req.label":
+		return e.extractFromKeys(req, jsonValue, "requestObject", "metadata", "labels", req.ArgKey())
+	case "ka.req.annotation":
+		return e.extractFromKeys(req, jsonValue, "requestObject", "metadata", "annotations", req.ArgKey())
+	case "ka.req.namespace.pss_enforce", "ka.req.namespace.pss_audit", "ka.req.namespace.pss_warn":
+		if string(jsonValue.GetStringBytes("objectRef", "resource")) != "namespaces" {
+			return ErrExtractNotAvailable
+		}
+		// the field names end with the Pod Security Admission mode of the label
+		label := "pod-security.kubernetes.io/" + strings.TrimPrefix(req.Field(), "ka.req.namespace.pss_")
+		req.SetValue(string(jsonValue.GetStringBytes("requestObject", "metadata", "labels", label)))
 	case "ka.req.binding.subjects":
 		return e.extractFromKeys(req, jsonValue, "requestObject", "subjects")
 	case "ka.req.binding.role":
@@ -310,6 +389,12 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 		return e.extractRulesField(req, jsonValue, "verbs")
 	case "ka.req.role.rules.resources":
 		return e.extractRulesField(req, jsonValue, "resources")
+	case "ka.req.role.grants_wildcard":
+		grants, ok := e.roleGrantsWildcard(jsonValue)
+		if !ok {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(strconv.FormatBool(grants))
 	case "ka.req.pod.fs_group":
 		return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "securityContext", "fsGroup")
 	case "ka.req.pod.supplemental_groups":
@@ -320,8 +405,33 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 			return err
 		}
 		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.req.container.seccontext.run_as_user":
+		return e.extractContainerSecurityContext(req, jsonValue, "runAsUser", true, "")
+	case "ka.req.container.seccontext.run_as_non_root":
+		return e.extractContainerSecurityContext(req, jsonValue, "runAsNonRoot", true, "false")
+	case "ka.req.container.seccontext.allow_privilege_escalation":
+		return e.extractContainerSecurityContext(req, jsonValue, "allowPrivilegeEscalation", false, "true")
+	case "ka.req.container.seccontext.read_only_root_filesystem":
+		return e.extractContainerSecurityContext(req, jsonValue, "readOnlyRootFilesystem", false, "false")
+	case "ka.req.container.seccontext.capabilities.add":
+		containers, err := e.requestContainers(jsonValue, e.argIndexFilter(req))
+		if err != nil {
+			return err
+		}
+		var values []string
+		for _, c := range containers {
+			values = append(values, e.arrayAsStringsSkipNil(c.GetArray("securityContext", "capabilities", "add"))...)
+		}
+		req.SetValue(values)
 	case "ka.req.service.type":
-		return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "type")
+		if !e.isRequestObjectOf(jsonValue, "services") {
+			return ErrExtractNotAvailable
+		}
+		serviceType, ok := e.serviceType(jsonValue)
+		if !ok {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(serviceType)
 	case "ka.req.service.ports":
 		indexFilter := e.argIndexFilter(req)
 		arr, err := e.getValuesRecursive(jsonValue, indexFilter, "requestObject", "spec", "ports")
@@ -329,6 +439,324 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 			return err
 		}
 		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.req.service.nodeports":
+		if !e.isRequestObjectOf(jsonValue, "services") {
+			return ErrExtractNotAvailable
+		}
+		arr, err := e.getValuesRecursive(jsonValue, e.argIndexFilter(req), "requestObject", "spec", "ports", "nodePort")
+		if err != nil {
+			return err
+		}
+		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.req.service.loadbalancer":
+		if serviceType, _ := e.serviceType(jsonValue); !e.isRequestObjectOf(jsonValue, "services") || serviceType != "LoadBalancer" {
+			return ErrExtractNotAvailable
+		}
+		arr := jsonValue.GetArray("requestObject", "spec", "loadBalancerSourceRanges")
+		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.req.pvc.storageclass":
+		if !e.isRequestObjectOf(jsonValue, "persistentvolumeclaims") {
+			return ErrExtractNotAvailable
+		}
+		return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "storageClassName")
+	case "ka.req.pvc.size":
+		if !e.isRequestObjectOf(jsonValue, "persistentvolumeclaims") {
+			return ErrExtractNotAvailable
+		}
+		return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "resources", "requests", "storage")
+	case "ka.req.pvc.accessmodes":
+		if !e.isRequestObjectOf(jsonValue, "persistentvolumeclaims") {
+			return ErrExtractNotAvailable
+		}
+		arr := jsonValue.GetArray("requestObject", "spec", "accessModes")
+		if arr == nil {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.req.dryrun":
+		req.SetValue(strconv.FormatBool(e.isDryRun(jsonValue)))
+	case "ka.req.cronjob.schedule":
+		if !e.isRequestObjectOf(jsonValue, "cronjobs") {
+			return ErrExtractNotAvailable
+		}
+		return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "schedule")
+	case "ka.req.job.containers.image":
+		keys := e.jobSpecKeys(jsonValue)
+		if keys == nil {
+			return ErrExtractNotAvailable
+		}
+		keys = append(keys, "template", "spec", "containers", "image")
+		arr, err := e.getValuesRecursive(jsonValue, e.argIndexFilter(req), keys...)
+		if err != nil {
+			return err
+		}
+		images, err := e.arrayAsStrings(arr)
+		if err != nil {
+			return err
+		}
+		req.SetValue(images)
+	case "ka.req.deployment.strategy":
+		if !e.isRequestObjectOf(jsonValue, "deployments") {
+			return ErrExtractNotAvailable
+		}
+		strategy := jsonValue.GetStringBytes("requestObject", "spec", "strategy", "type")
+		if len(strategy) == 0 {
+			// patches only contain the changed parts of the object
+			if string(jsonValue.GetStringBytes("verb")) == "patch" {
+				return ErrExtractNotAvailable
+			}
+			strategy = []byte("RollingUpdate")
+		}
+		req.SetValue(string(strategy))
+	case "ka.req.deployment.images":
+		if !e.isRequestObjectOf(jsonValue, "deployments") {
+			return ErrExtractNotAvailable
+		}
+		// note: the images of the init containers follow the ones of the
+		// containers, and the index applies to the whole list
+		var arr []*fastjson.Value
+		found := false
+		for _, kind := range []string{"containers", "initContainers"} {
+			vals, err := e.getValuesRecursive(jsonValue, noIndexFilter, "requestObject", "spec", "template", "spec", kind, "image")
+			if err == ErrExtractNotAvailable {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			arr = append(arr, vals...)
+			found = true
+		}
+		if !found {
+			return ErrExtractNotAvailable
+		}
+		if index := e.argIndexFilter(req); index != noIndexFilter {
+			if index >= len(arr) {
+				return ErrExtractNotAvailable
+			}
+			arr = arr[index : index+1]
+		}
+		images, err := e.arrayAsStrings(arr)
+		if err != nil {
+			return err
+		}
+		req.SetValue(images)
+	case "ka.req.deployment.image_changed":
+		if string(jsonValue.GetStringBytes("objectRef", "resource")) != "deployments" ||
+			len(jsonValue.GetStringBytes("objectRef", "subresource")) > 0 ||
+			string(jsonValue.GetStringBytes("verb")) != "patch" {
+			return ErrExtractNotAvailable
+		}
+		patch := jsonValue.Get("requestObject")
+		if patch == nil {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(strconv.FormatBool(patchSetsTemplateImage(patch)))
+	case "ka.req.job.backofflimit":
+		keys := e.jobSpecKeys(jsonValue)
+		if keys == nil {
+			return ErrExtractNotAvailable
+		}
+		keys = append(keys, "backoffLimit")
+		// note: the default only applies when creating the object, other
+		// requests such as patches may just not change the limit
+		if jsonValue.Get(keys...) == nil && string(jsonValue.GetStringBytes("verb")) == "create" {
+			req.SetValue(uint64(defaultJobBackoffLimit))
+			return nil
+		}
+		return e.extractFromKeys(req, jsonValue, keys...)
+	case "ka.req.netpol.policytypes":
+		if !e.isRequestObjectOf(jsonValue, "networkpolicies") {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(e.networkPolicyTypes(jsonValue))
+	case "ka.req.netpol.ingress.ports":
+		if !e.isRequestObjectOf(jsonValue, "networkpolicies") {
+			return ErrExtractNotAvailable
+		}
+		var ports []string
+		for _, rule := range jsonValue.GetArray("requestObject", "spec", "ingress") {
+			for _, port := range rule.GetArray("ports") {
+				ports = append(ports, e.networkPolicyPort(port))
+			}
+		}
+		if len(ports) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(ports)
+	case "ka.req.netpol.egress.cidrs":
+		if !e.isRequestObjectOf(jsonValue, "networkpolicies") {
+			return ErrExtractNotAvailable
+		}
+		var cidrs []string
+		for _, rule := range jsonValue.GetArray("requestObject", "spec", "egress") {
+			for _, peer := range rule.GetArray("to") {
+				if cidr := peer.GetStringBytes("ipBlock", "cidr"); len(cidr) > 0 {
+					cidrs = append(cidrs, string(cidr))
+				}
+			}
+		}
+		if len(cidrs) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(cidrs)
+	case "ka.req.ingress.hosts", "ka.req.ingress.tls_secrets", "ka.req.ingress.backend_services":
+		if !e.isRequestObjectOf(jsonValue, "ingresses") {
+			return ErrExtractNotAvailable
+		}
+		var values []string
+		switch req.Field() {
+		case "ka.req.ingress.hosts":
+			for _, rule := range jsonValue.GetArray("requestObject", "spec", "rules") {
+				values = append(values, string(rule.GetStringBytes("host")))
+			}
+		case "ka.req.ingress.tls_secrets":
+			for _, tls := range jsonValue.GetArray("requestObject", "spec", "tls") {
+				values = append(values, string(tls.GetStringBytes("secretName")))
+			}
+		default:
+			values = e.ingressBackendServices(jsonValue)
+		}
+		values = uniqueNonEmpty(values)
+		if len(values) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(values)
+	case "ka.req.endpoints.addresses", "ka.req.endpointslice.addresses":
+		var values []string
+		if req.Field() == "ka.req.endpoints.addresses" {
+			if string(jsonValue.GetStringBytes("objectRef", "resource")) != "endpoints" {
+				return ErrExtractNotAvailable
+			}
+			for _, subset := range jsonValue.GetArray("requestObject", "subsets") {
+				for _, key := range []string{"addresses", "notReadyAddresses"} {
+					for _, address := range subset.GetArray(key) {
+						values = append(values, string(address.GetStringBytes("ip")))
+					}
+				}
+			}
+		} else {
+			if string(jsonValue.GetStringBytes("objectRef", "resource")) != "endpointslices" {
+				return ErrExtractNotAvailable
+			}
+			for _, endpoint := range jsonValue.GetArray("requestObject", "endpoints") {
+				for _, address := range endpoint.GetArray("addresses") {
+					values = append(values, string(address.GetStringBytes()))
+				}
+			}
+		}
+		values = uniqueNonEmpty(values)
+		if len(values) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(values)
+	case "ka.req.quota.hard":
+		if !e.isRequestObjectOf(jsonValue, "resourcequotas") {
+			return ErrExtractNotAvailable
+		}
+		values := keyValueEntries("", jsonValue.Get("requestObject", "spec", "hard"))
+		if len(values) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(values)
+	case "ka.req.limitrange.limits":
+		if !e.isRequestObjectOf(jsonValue, "limitranges") {
+			return ErrExtractNotAvailable
+		}
+		var values []string
+		for _, limit := range jsonValue.GetArray("requestObject", "spec", "limits") {
+			prefix := string(limit.GetStringBytes("type")) + "."
+			for _, constraint := range limitRangeConstraints {
+				values = append(values, keyValueEntries(prefix+constraint+".", limit.Get(constraint))...)
+			}
+		}
+		if len(values) == 0 {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(values)
+	case "ka.req.crd.group", "ka.req.crd.kind", "ka.req.crd.scope":
+		if !e.isRequestObjectOf(jsonValue, "customresourcedefinitions") {
+			return ErrExtractNotAvailable
+		}
+		switch req.Field() {
+		case "ka.req.crd.group":
+			return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "group")
+		case "ka.req.crd.kind":
+			return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "names", "kind")
+		default:
+			return e.extractFromKeys(req, jsonValue, "requestObject", "spec", "scope")
+		}
+	case "ka.req.node.taints", "ka.req.node.labels", "ka.req.node.unschedulable":
+		// note: node updates are often patches that only contain the
+		// changed parts, so the request object may not have a spec. The
+		// status subresource, regularly updated by kubelets, is ignored
+		if string(jsonValue.GetStringBytes("objectRef", "resource")) != "nodes" || len(jsonValue.GetStringBytes("objectRef", "subresource")) > 0 {
+			return ErrExtractNotAvailable
+		}
+		requestObject := jsonValue.Get("requestObject")
+		if requestObject == nil {
+			return ErrExtractNotAvailable
+		}
+		switch req.Field() {
+		case "ka.req.node.taints":
+			var values []string
+			for _, taint := range requestObject.GetArray("spec", "taints") {
+				value := string(taint.GetStringBytes("key"))
+				if v := taint.GetStringBytes("value"); len(v) > 0 {
+					value += "=" + string(v)
+				}
+				values = append(values, value+":"+string(taint.GetStringBytes("effect")))
+			}
+			if len(values) == 0 {
+				return ErrExtractNotAvailable
+			}
+			req.SetValue(values)
+		case "ka.req.node.labels":
+			values := keyValueEntries("", requestObject.Get("metadata", "labels"))
+			if len(values) == 0 {
+				return ErrExtractNotAvailable
+			}
+			req.SetValue(values)
+		default:
+			// note: uncordoning patches set the field to null
+			if requestObject.Get("spec", "unschedulable") == nil {
+				return ErrExtractNotAvailable
+			}
+			req.SetValue(strconv.FormatBool(requestObject.GetBool("spec", "unschedulable")))
+		}
+	case "ka.req.webhook.name", "ka.req.webhook.url", "ka.req.webhook.failurepolicy", "ka.req.webhook.namespaceselector":
+		webhooks := e.requestWebhooks(jsonValue)
+		if indexFilter := e.argIndexFilter(req); indexFilter != noIndexFilter {
+			if indexFilter >= len(webhooks) {
+				return ErrExtractNotAvailable
+			}
+			webhooks = webhooks[indexFilter : indexFilter+1]
+		}
+		if len(webhooks) == 0 {
+			return ErrExtractNotAvailable
+		}
+		var res []string
+		for _, webhook := range webhooks {
+			switch req.Field() {
+			case "ka.req.webhook.name":
+				res = append(res, string(webhook.GetStringBytes("name")))
+			case "ka.req.webhook.url":
+				res = append(res, e.webhookURL(webhook))
+			case "ka.req.webhook.failurepolicy":
+				policy := string(webhook.GetStringBytes("failurePolicy"))
+				if len(policy) == 0 {
+					policy = "Fail"
+				}
+				res = append(res, policy)
+			case "ka.req.webhook.namespaceselector":
+				selector := "{}"
+				if v := webhook.Get("namespaceSelector"); v != nil {
+					selector = string(v.MarshalTo(nil))
+				}
+				res = append(res, selector)
+			}
+		}
+		req.SetValue(res)
 	case "ka.req.volume.hostpath":
 		arr, err := e.getValuesRecursive(jsonValue, e.argIndexFilter(req), "requestObject", "spec", "volumes", "hostPath", "path")
 		if err != nil {
@@ -353,6 +781,19 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 			return err
 		}
 		req.SetValue(e.arrayAsStringsSkipNil(arr))
+	case "ka.req.pod.mounts_sensitive_path":
+		if !e.isRequestObjectOf(jsonValue, "pods") {
+			return ErrExtractNotAvailable
+		}
+		spec := jsonValue.Get("requestObject", "spec")
+		for _, volume := range spec.GetArray("volumes") {
+			hostPath := volume.GetStringBytes("hostPath", "path")
+			if len(hostPath) > 0 && isSensitiveHostPath(string(hostPath), e.Config.SensitiveHostPaths) {
+				req.SetValue("true")
+				return nil
+			}
+		}
+		req.SetValue("false")
 	case "ka.req.pod.volumes.flexvolume_driver":
 		arr, err := e.getValuesRecursive(jsonValue, e.argIndexFilter(req), "requestObject", "spec", "volumes", "flexVolume", "driver")
 		if err != nil {
@@ -382,24 +823,459 @@ func (e *Plugin) ExtractFromJSON(req sdk.ExtractRequest, jsonValue *fastjson.Val
 			}
 		}
 		req.SetValue(values)
+	case "ka.req.changed_fields":
+		reqObj := jsonValue.Get("requestObject")
+		respObj := jsonValue.Get("responseObject")
+		if reqObj == nil || respObj == nil {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(changedFields(reqObj, respObj))
+	case "ka.req.patch.ops":
+		ops, ok := e.requestPatchOps(jsonValue)
+		if !ok {
+			return ErrExtractNotAvailable
+		}
+		values := []string{}
+		for _, op := range ops {
+			values = append(values, op.String())
+		}
+		req.SetValue(values)
+	case "ka.req.patch.touches":
+		ops, ok := e.requestPatchOps(jsonValue)
+		if !ok {
+			return ErrExtractNotAvailable
+		}
+		touches := false
+		for _, op := range ops {
+			if op.touches(req.ArgKey()) {
+				touches = true
+				break
+			}
+		}
+		req.SetValue(strconv.FormatBool(touches))
 	case "ka.resp.name":
 		return e.extractFromKeys(req, jsonValue, "responseObject", "metadata", "name")
+	case "ka.resp.items.count":
+		var count uint64
+		if strings.HasSuffix(string(jsonValue.GetStringBytes("responseObject", "kind")), "List") {
+			count = uint64(len(jsonValue.GetArray("responseObject", "items")))
+		}
+		req.SetValue(count)
 	case "ka.response.code":
 		return e.extractFromKeys(req, jsonValue, "responseStatus", "code")
 	case "ka.response.reason":
 		return e.extractFromKeys(req, jsonValue, "responseStatus", "reason")
 	case "ka.useragent":
 		return e.extractFromKeys(req, jsonValue, "userAgent")
+	case "ka.client.kind":
+		userAgent := jsonValue.Get("userAgent")
+		if userAgent == nil {
+			return ErrExtractNotAvailable
+		}
+		req.SetValue(e.classifyUserAgent(string(userAgent.GetStringBytes())))
 	case "ka.sourceips":
 		return e.extractRulesField(req, jsonValue, "sourceIPs")
+	case "ka.sourceips.count":
+		req.SetValue(uint64(len(jsonValue.GetArray("sourceIPs"))))
 	case "ka.cluster.name":
-		return e.extractFromKeys(req, jsonValue, "annotations", "cluster_name")
+		return e.extractFromKeys(req, jsonValue, "annotations", annotationClusterName)
+	case "ka.ingest.latency_ms":
+		return e.extractFromKeys(req, jsonValue, "annotations", annotationIngestLatency)
+	case "ka.ingest.time":
+		return e.extractFromKeys(req, jsonValue, "annotations", annotationIngestTime)
+	case "ka.source.type":
+		return e.extractFromKeys(req, jsonValue, "annotations", annotationSourceType)
+	case "ka.source.file":
+		return e.extractFromKeys(req, jsonValue, "annotations", annotationSourceFile)
+	case "ka.source.line":
+		return e.extractFromKeys(req, jsonValue, "annotations", annotationSourceLine)
 	default:
 		return fmt.Errorf("unsupported extraction field: %s", req.Field())
 	}
 	return nil
 }
 
+// targetScope returns the scope of the request of an audit event given its
+// objectRef, either namespaced if the request is restricted to a namespace
+// (including the ones on namespaced collections), or cluster otherwise
+// (including the ones on namespaced resources across all namespaces).
+func targetScope(objectRef *fastjson.Value) string {
+	// the namespace of the namespace objects is their name
+	if string(objectRef.GetStringBytes("resource")) == "namespaces" && len(objectRef.GetStringBytes("apiGroup")) == 0 {
+		return "cluster"
+	}
+	if len(objectRef.GetStringBytes("namespace")) > 0 {
+		return "namespaced"
+	}
+	return "cluster"
+}
+
+// isRequestObjectOf returns true if the event targets the given resource
+// (e.g. services) and carries a request object with a spec
+func (e *Plugin) isRequestObjectOf(jsonValue *fastjson.Value, resource string) bool {
+	return string(jsonValue.GetStringBytes("objectRef", "resource")) == resource &&
+		jsonValue.Get("requestObject", "spec") != nil
+}
+
+// serviceType returns the type of the service in the request object, which
+// defaults to ClusterIP when not specified at creation. Other requests, such
+// as patches, may only contain the changed parts of the object, in which case
+// false is returned if the type is not specified
+func (e *Plugin) serviceType(jsonValue *fastjson.Value) (string, bool) {
+	if t := jsonValue.GetStringBytes("requestObject", "spec", "type"); len(t) > 0 {
+		return string(t), true
+	}
+	if string(jsonValue.GetStringBytes("verb")) != "create" {
+		return "", false
+	}
+	return "ClusterIP", true
+}
+
+func (e *Plugin) isTokenReview(jsonValue *fastjson.Value) bool {
+	return string(jsonValue.GetStringBytes("objectRef", "resource")) == "tokenreviews"
+}
+
+func (e *Plugin) isTokenRequest(jsonValue *fastjson.Value) bool {
+	return string(jsonValue.GetStringBytes("objectRef", "resource")) == "serviceaccounts" &&
+		string(jsonValue.GetStringBytes("objectRef", "subresource")) == "token"
+}
+
+// tokenSubject returns the issuer and the subject of the token of a token
+// review or of a service account token request, if known. This only relies
+// on the structured fields of the event, and never decodes the token
+func (e *Plugin) tokenSubject(jsonValue *fastjson.Value) (string, string) {
+	switch {
+	case e.isTokenReview(jsonValue):
+		username := string(jsonValue.GetStringBytes("responseObject", "status", "user", "username"))
+		// usernames mapped from claims other than email are prefixed by
+		// the issuer URL, see https://kubernetes.io/docs/reference/access-authn-authz/authentication/#openid-connect-tokens
+		if i := strings.LastIndex(username, "#"); i > 0 && strings.HasPrefix(username, "https://") {
+			return username[:i], username[i+1:]
+		}
+		return "", username
+	case e.isTokenRequest(jsonValue):
+		namespace := string(jsonValue.GetStringBytes("objectRef", "namespace"))
+		name := string(jsonValue.GetStringBytes("objectRef", "name"))
+		if len(namespace) == 0 || len(name) == 0 {
+			return "", ""
+		}
+		return "", "system:serviceaccount:" + namespace + ":" + name
+	default:
+		return "", ""
+	}
+}
+
+// requestWebhooks returns the webhooks of the validating or mutating
+// admission webhook configuration in the request object, if any
+func (e *Plugin) requestWebhooks(jsonValue *fastjson.Value) []*fastjson.Value {
+	switch string(jsonValue.GetStringBytes("objectRef", "resource")) {
+	case "validatingwebhookconfigurations", "mutatingwebhookconfigurations":
+		return jsonValue.GetArray("requestObject", "webhooks")
+	default:
+		return nil
+	}
+}
+
+// webhookURL returns the URL called by the API server for an admission
+// webhook. Webhooks referring to a service are formatted as
+// https://<service>.<namespace>.svc:<port><path>
+func (e *Plugin) webhookURL(webhook *fastjson.Value) string {
+	if url := webhook.GetStringBytes("clientConfig", "url"); len(url) > 0 {
+		return string(url)
+	}
+	service := webhook.Get("clientConfig", "service")
+	if service == nil {
+		return ""
+	}
+	port := 443
+	if p := service.GetInt("port"); p > 0 {
+		port = p
+	}
+	return fmt.Sprintf("https://%s.%s.svc:%d%s",
+		service.GetStringBytes("name"), service.GetStringBytes("namespace"), port, service.GetStringBytes("path"))
+}
+
+// isDryRun returns true if the request is a dry-run one, as indicated by
+// the dryRun parameter of its URI (e.g. ?dryRun=All), or by the options
+// sent as request object (e.g. the DeleteOptions of delete requests)
+func (e *Plugin) isDryRun(jsonValue *fastjson.Value) bool {
+	if uri, err := e.readRequestURI(jsonValue); err == nil {
+		if query, err := url.ParseQuery(uri.RawQuery); err == nil && len(query.Get("dryRun")) > 0 {
+			return true
+		}
+	}
+	kind := string(jsonValue.GetStringBytes("requestObject", "kind"))
+	return strings.HasSuffix(kind, "Options") && len(jsonValue.GetArray("requestObject", "dryRun")) > 0
+}
+
+// requestPatchOps returns the operations of the patch of a patch request,
+// see patchOps. The second return value is false if the request is not a
+// patch or if its object is not available
+func (e *Plugin) requestPatchOps(jsonValue *fastjson.Value) ([]patchOp, bool) {
+	if string(jsonValue.GetStringBytes("verb")) != "patch" {
+		return nil, false
+	}
+	patch := jsonValue.Get("requestObject")
+	if patch == nil || (patch.Type() != fastjson.TypeArray && patch.Type() != fastjson.TypeObject) {
+		return nil, false
+	}
+	return patchOps(patch), true
+}
+
+// roleGrantsWildcard returns true if any rule of the role or cluster role in
+// the request object has the "*" wildcard among its api groups, resources or
+// verbs. The second return value is false if the request object is not a role
+func (e *Plugin) roleGrantsWildcard(jsonValue *fastjson.Value) (bool, bool) {
+	resource := string(jsonValue.GetStringBytes("objectRef", "resource"))
+	if resource != "roles" && resource != "clusterroles" {
+		return false, false
+	}
+	rules := jsonValue.Get("requestObject", "rules")
+	if rules == nil || rules.Type() != fastjson.TypeArray {
+		return false, false
+	}
+	for _, rule := range rules.GetArray() {
+		for _, key := range []string{"apiGroups", "resources", "verbs"} {
+			for _, v := range e.arrayAsStringsSkipNil(rule.GetArray(key)) {
+				if v == "*" {
+					return true, true
+				}
+			}
+		}
+	}
+	return false, true
+}
+
+// ingressBackendServices returns the names of the services used as backend by
+// the ingress in the request object, supporting both the networking.k8s.io/v1
+// and the legacy v1beta1 formats
+func (e *Plugin) ingressBackendServices(jsonValue *fastjson.Value) []string {
+	serviceName := func(backend *fastjson.Value) string {
+		if name := backend.GetStringBytes("service", "name"); len(name) > 0 {
+			return string(name)
+		}
+		return string(backend.GetStringBytes("serviceName"))
+	}
+	var services []string
+	spec := jsonValue.Get("requestObject", "spec")
+	for _, key := range []string{"defaultBackend", "backend"} {
+		if backend := spec.Get(key); backend != nil {
+			services = append(services, serviceName(backend))
+		}
+	}
+	for _, rule := range spec.GetArray("rules") {
+		for _, path := range rule.GetArray("http", "paths") {
+			if backend := path.Get("backend"); backend != nil {
+				services = append(services, serviceName(backend))
+			}
+		}
+	}
+	return services
+}
+
+// uniqueNonEmpty returns the non-empty values, without duplicates and in
+// order of first appearance
+func uniqueNonEmpty(values []string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if len(v) > 0 && !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// limitRangeConstraints are the constraints that can be set for each
+// type of resource by a limit range
+var limitRangeConstraints = []string{"max", "min", "default", "defaultRequest", "maxLimitRequestRatio"}
+
+// keyValueEntries returns the entries of a JSON object mapping keys to
+// strings, such as a K8S resource list (e.g. the hard limits of a resource
+// quota) or labels, as prefixed key=value strings
+func keyValueEntries(prefix string, object *fastjson.Value) []string {
+	if object == nil {
+		return nil
+	}
+	obj, err := object.Object()
+	if err != nil {
+		return nil
+	}
+	var res []string
+	obj.Visit(func(key []byte, v *fastjson.Value) {
+		// note: null values are the ones removed by merge patches
+		if v.Type() == fastjson.TypeNull {
+			return
+		}
+		value := v.GetStringBytes()
+		if value == nil {
+			// resource quantities are usually strings, but can be sent as numbers
+			value = v.MarshalTo(nil)
+		}
+		res = append(res, prefix+string(key)+"="+string(value))
+	})
+	return res
+}
+
+// networkPolicyTypes returns the policy types of the network policy in the
+// request object. When not specified, Ingress is always implied, and Egress
+// is implied if the policy has egress rules
+func (e *Plugin) networkPolicyTypes(jsonValue *fastjson.Value) []string {
+	if arr := jsonValue.GetArray("requestObject", "spec", "policyTypes"); len(arr) > 0 {
+		return e.arrayAsStringsSkipNil(arr)
+	}
+	types := []string{"Ingress"}
+	if jsonValue.Get("requestObject", "spec", "egress") != nil {
+		types = append(types, "Egress")
+	}
+	return types
+}
+
+// networkPolicyPort formats a port of a network policy rule as <port>/<protocol>
+// (e.g. 443/TCP), or <port>-<endPort>/<protocol> for port ranges. The port can
+// be a named one, and the protocol defaults to TCP
+func (e *Plugin) networkPolicyPort(jsonValue *fastjson.Value) string {
+	port := "*"
+	if v := jsonValue.Get("port"); v != nil {
+		if p, err := e.jsonValueAsString(v); err == nil {
+			port = p
+		}
+	}
+	if endPort := jsonValue.Get("endPort"); endPort != nil {
+		port += "-" + endPort.String()
+	}
+	protocol := "TCP"
+	if p := jsonValue.GetStringBytes("protocol"); len(p) > 0 {
+		protocol = string(p)
+	}
+	return port + "/" + protocol
+}
+
+// defaultJobBackoffLimit is the number of retries of a job when not specified
+const defaultJobBackoffLimit = 6
+
+var (
+	// templateImagePathRegexp matches the JSON pointers of the images of the
+	// containers and init containers of the pod template of a workload
+	templateImagePathRegexp = regexp.MustCompile(`^/spec/template/spec/(containers|initContainers)/[^/]+/image$`)
+	// templateImageParentPathRegexp matches the JSON pointers of the images,
+	// and of all the values containing them
+	templateImageParentPathRegexp = regexp.MustCompile(`^(/spec(/template(/spec(/(containers|initContainers)(/[^/]+(/image)?)?)?)?)?)?$`)
+)
+
+// patchSetsTemplateImage returns true if a patch sets the image of any
+// container or init container of the pod template of a workload. Both JSON
+// patches (see https://www.rfc-editor.org/rfc/rfc6902) and merge patches
+// are supported, see patchOps
+func patchSetsTemplateImage(patch *fastjson.Value) bool {
+	found := false
+	for _, op := range patchOps(patch) {
+		switch op.op {
+		case "add", "replace", "merge":
+			if op.value == nil {
+				continue
+			}
+			visitPatchLeaves(op.value, op.path, true, func(path string, v *fastjson.Value) {
+				if v.Type() == fastjson.TypeString && templateImagePathRegexp.MatchString(path) {
+					found = true
+				}
+			})
+		case "copy", "move":
+			// note: the copied values are not part of the patch, so
+			// they may set an image if they are written where one is
+			if templateImageParentPathRegexp.MatchString(op.path) {
+				found = true
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// jobSpecKeys returns the keys of the job spec in the request object, which
+// is nested in the job template for cronjobs, or nil if the event does not
+// refer to a job or cronjob
+func (e *Plugin) jobSpecKeys(jsonValue *fastjson.Value) []string {
+	switch {
+	case e.isRequestObjectOf(jsonValue, "jobs"):
+		return []string{"requestObject", "spec"}
+	case e.isRequestObjectOf(jsonValue, "cronjobs") && jsonValue.Get("requestObject", "spec", "jobTemplate", "spec") != nil:
+		return []string{"requestObject", "spec", "jobTemplate", "spec"}
+	default:
+		return nil
+	}
+}
+
+func (e *Plugin) readRequestURI(jsonValue *fastjson.Value) (*url.URL, error) {
+	uriValue := jsonValue.Get("requestURI")
+	if uriValue == nil {
+		return nil, ErrExtractNotAvailable
+	}
+	uriString, err := e.jsonValueAsString(uriValue)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(uriString)
+}
+
+// encodeQuery returns the given raw query escaped again in the same form
+// as url.Values.Encode, except that parameters keep the order in which
+// their name first appears. Repeated parameters are grouped together,
+// keeping the order of their values.
+func encodeQuery(rawQuery string) (string, error) {
+	var keys []string
+	values := make(map[string][]string)
+	for _, param := range strings.Split(rawQuery, "&") {
+		if len(param) == 0 {
+			continue
+		}
+		key, value := param, ""
+		if i := strings.Index(param, "="); i >= 0 {
+			key, value = param[:i], param[i+1:]
+		}
+		key, err := url.QueryUnescape(key)
+		if err != nil {
+			return "", err
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], value)
+	}
+	var params []string
+	for _, key := range keys {
+		for _, value := range values[key] {
+			params = append(params, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(params, "&"), nil
+}
+
+// uriPathSegments returns the percent-decoded segments of the URI path,
+// skipping the empty ones caused by leading, trailing, or repeated slashes.
+// The path is split before decoding so that an encoded slash (%2F) does
+// not produce a new segment.
+func (e *Plugin) uriPathSegments(uri *url.URL) []string {
+	var res []string
+	for _, s := range strings.Split(uri.EscapedPath(), "/") {
+		if len(s) == 0 {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
 func (e *Plugin) argIndexFilter(req sdk.ExtractRequest) int {
 	if !req.ArgPresent() {
 		return noIndexFilter
@@ -548,6 +1424,49 @@ func (e *Plugin) readFromContainerEffectively(jsonValue *fastjson.Value, indexFi
 	return e.arrayAsStringsWithDefault(arr, podID), nil
 }
 
+// requestContainers returns the containers of the pod in the request object,
+// or only the one at the given index
+func (e *Plugin) requestContainers(jsonValue *fastjson.Value, indexFilter int) ([]*fastjson.Value, error) {
+	containers := jsonValue.GetArray("requestObject", "spec", "containers")
+	if containers == nil {
+		return nil, ErrExtractNotAvailable
+	}
+	if indexFilter != noIndexFilter {
+		if indexFilter >= len(containers) {
+			return nil, ErrExtractNotAvailable
+		}
+		containers = containers[indexFilter : indexFilter+1]
+	}
+	return containers, nil
+}
+
+// extractContainerSecurityContext extracts a value of the security context of
+// each container of the pod in the request object, one per container. When
+// a container doesn't set it, the value is the one of the pod security
+// context if inherited is true, or defaultValue otherwise
+func (e *Plugin) extractContainerSecurityContext(req sdk.ExtractRequest, jsonValue *fastjson.Value, key string, inherited bool, defaultValue string) error {
+	containers, err := e.requestContainers(jsonValue, e.argIndexFilter(req))
+	if err != nil {
+		return err
+	}
+	podValue := defaultValue
+	if inherited {
+		if v, err := e.jsonValueAsString(jsonValue.Get("requestObject", "spec", "securityContext", key)); err == nil {
+			podValue = v
+		}
+	}
+	values := make([]string, 0, len(containers))
+	for _, c := range containers {
+		value := podValue
+		if v, err := e.jsonValueAsString(c.Get("securityContext", key)); err == nil {
+			value = v
+		}
+		values = append(values, value)
+	}
+	req.SetValue(values)
+	return nil
+}
+
 func (e *Plugin) extractRulesField(req sdk.ExtractRequest, jsonValue *fastjson.Value, keys ...string) error {
 	arr, err := e.getValuesRecursive(jsonValue, e.argIndexFilter(req), append([]string{"requestObject", "rules"}, keys...)...)
 	if err != nil {
@@ -563,6 +1482,21 @@ func (e *Plugin) extractRulesField(req sdk.ExtractRequest, jsonValue *fastjson.V
 	return nil
 }
 
+// extractFromJSONAnnotation extracts a field from the JSON object encoded
+// in the value of one of the event's annotations
+func (e *Plugin) extractFromJSONAnnotation(req sdk.ExtractRequest, jsonValue *fastjson.Value, annotation string, keys ...string) error {
+	data := jsonValue.GetStringBytes("annotations", annotation)
+	if data == nil {
+		return ErrExtractNotAvailable
+	}
+	var parser fastjson.Parser
+	value, err := parser.ParseBytes(data)
+	if err != nil {
+		return err
+	}
+	return e.extractFromKeys(req, value, keys...)
+}
+
 func (e *Plugin) extractFromKeys(req sdk.ExtractRequest, jsonValue *fastjson.Value, keys ...string) error {
 	jsonValue = jsonValue.Get(keys...)
 	if jsonValue == nil {
@@ -594,6 +1528,12 @@ func (e *Plugin) extractFromKeys(req sdk.ExtractRequest, jsonValue *fastjson.Val
 				return err
 			}
 			req.SetValue(val)
+		case sdk.FieldTypeUint64:
+			val, err := e.jsonValueAsUint64(jsonValue)
+			if err != nil {
+				return err
+			}
+			req.SetValue(val)
 		default:
 			return ErrExtractUnsupportedType
 		}
@@ -601,6 +1541,32 @@ func (e *Plugin) extractFromKeys(req sdk.ExtractRequest, jsonValue *fastjson.Val
 	return nil
 }
 
+// note: numbers encoded as JSON strings are accepted too
+func (e *Plugin) jsonValueAsUint64(v *fastjson.Value) (uint64, error) {
+	if v != nil {
+		switch v.Type() {
+		case fastjson.TypeNumber:
+			if e.Config.Parsing == parsingLenient {
+				if res, ok := parseLenientUint64(v); ok {
+					return res, nil
+				}
+			}
+			return v.GetUint64(), nil
+		case fastjson.TypeString:
+			res, err := strconv.ParseUint(string(v.GetStringBytes()), 10, 64)
+			if err == nil {
+				return res, nil
+			}
+			if e.Config.Parsing == parsingLenient {
+				if res, ok := parseLenientUint64(v); ok {
+					return res, nil
+				}
+			}
+		}
+	}
+	return 0, ErrExtractWrongType
+}
+
 func (e *Plugin) jsonValueAsString(v *fastjson.Value) (string, error) {
 	if v != nil {
 		if v.Type() == fastjson.TypeString {
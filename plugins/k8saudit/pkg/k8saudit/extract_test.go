@@ -19,14 +19,18 @@ package k8saudit
 
 import (
 	"bufio"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 	"unsafe"
 
 	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk"
+	"github.com/valyala/fastjson"
 )
 
 type testExtractRequest struct {
@@ -37,6 +41,7 @@ type testExtractRequest struct {
 	argPresent bool
 	argIndex   uint64
 	argKey     string
+	value      interface{}
 }
 
 type jsonData struct {
@@ -73,7 +78,7 @@ func (t *testExtractRequest) IsList() bool {
 }
 
 func (t *testExtractRequest) SetValue(v interface{}) {
-
+	t.value = v
 }
 
 func (t *testExtractRequest) SetPtr(unsafe.Pointer) {
@@ -99,6 +104,40 @@ func fieldEntryToRequest(id uint64, field *sdk.FieldEntry, req *testExtractReque
 	}
 }
 
+// extractTestField extracts a field from a single JSON audit event, and
+// returns the extracted value, or nil if the field is not available. An
+// empty arg means that no argument is passed to the field.
+func extractTestField(t *testing.T, p *Plugin, field, arg, event string) interface{} {
+	req := &testExtractRequest{}
+	found := false
+	for i, f := range p.Fields() {
+		if f.Name == field {
+			fieldEntryToRequest(uint64(i), &f, req)
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("unknown field %s", field)
+	}
+	req.argPresent = len(arg) > 0
+	if req.argPresent {
+		req.argKey = arg
+		if index, err := strconv.ParseUint(arg, 10, 64); err == nil {
+			req.argIndex = index
+		}
+	}
+	jsonValue, err := fastjson.Parse(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.ExtractFromJSON(req, jsonValue)
+	if err != nil && err != ErrExtractNotAvailable {
+		t.Fatalf("extracting field %s: %s", field, err.Error())
+	}
+	return req.value
+}
+
 func readTestFiles(b testing.TB) []*jsonData {
 	path := "../../test_files/"
 	files, err := ioutil.ReadDir(path)
@@ -160,3 +199,1095 @@ func BenchmarkExtractFromJSON(b *testing.B) {
 	b.ReportMetric(exOp, "extractions/op")
 	b.ReportMetric(nsOp/exOp, "ns/extraction/op")
 }
+
+func TestExtractClientKind(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init(`{"clientKinds":{"my-operator/":"operator","kubectl/v1.28":"kubectl-legacy","Terraform":"iac"}}`); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"kubectl/v1.29.2 (linux/amd64) kubernetes/4b8e819":  "kubectl",
+		"kubectl/v1.28.1 (darwin/arm64) kubernetes/8dc49c4": "kubectl-legacy",
+		"Helm/3.14.0": "helm",
+		"kubelet/v1.29.2 (linux/amd64) kubernetes/4b8e819":                                                                         "kubelet",
+		"kube-controller-manager/v1.29.2 (linux/amd64) kubernetes/4b8e819/system:serviceaccount:kube-system:replicaset-controller": "control-plane",
+		"argocd-application-controller/v0.0.0 (linux/amd64) kubernetes/$Format":                                                    "gitops",
+		"manager/v0.0.0 (linux/amd64) kubernetes/$Format":                                                                          "client-go",
+		"my-operator/1.0.0":               "operator",
+		"curl/8.4.0":                      "http-client",
+		"Terraform/1.7.0":                 "iac",
+		"Mozilla/5.0 (X11; Linux x86_64)": "browser",
+		"some-tool":                       "unknown",
+	}
+	for userAgent, expected := range tests {
+		event := fmt.Sprintf(`{"auditID":"1","userAgent":%q}`, userAgent)
+		if v := extractTestField(t, p, "ka.client.kind", "", event); v != expected {
+			t.Errorf("user agent %q: expected %s, got %v", userAgent, expected, v)
+		}
+	}
+	if v := extractTestField(t, p, "ka.client.kind", "", `{"auditID":"1"}`); v != nil {
+		t.Errorf("expected no client kind, got %v", v)
+	}
+}
+
+func TestExtractURIPath(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri      string
+		path     string
+		segments []string
+	}{
+		{"/api/v1/namespaces/ns/pods/name/exec?command=sh&stdin=true", "/api/v1/namespaces/ns/pods/name/exec", []string{"api", "v1", "namespaces", "ns", "pods", "name", "exec"}},
+		{"/apis/apps/v1/namespaces/ns/deployments/", "/apis/apps/v1/namespaces/ns/deployments", []string{"apis", "apps", "v1", "namespaces", "ns", "deployments"}},
+		{"/api/v1/namespaces/ns/configmaps/my%20config", "/api/v1/namespaces/ns/configmaps/my%20config", []string{"api", "v1", "namespaces", "ns", "configmaps", "my config"}},
+		{"/api/v1/namespaces/ns/pods/a%2Fb/log", "/api/v1/namespaces/ns/pods/a%2Fb/log", []string{"api", "v1", "namespaces", "ns", "pods", "a/b", "log"}},
+		{"/api/v1/namespaces/ns/configmaps/my+config%7E", "/api/v1/namespaces/ns/configmaps/my+config~", []string{"api", "v1", "namespaces", "ns", "configmaps", "my+config~"}},
+		{"/?timeout=32s", "/", nil},
+	}
+	for _, test := range tests {
+		event := fmt.Sprintf(`{"auditID":"1","requestURI":%q}`, test.uri)
+		if v := extractTestField(t, p, "ka.uri.path", "", event); v != test.path {
+			t.Errorf("uri %q: expected path %q, got %v", test.uri, test.path, v)
+		}
+		for i, segment := range test.segments {
+			if v := extractTestField(t, p, "ka.uri.segment", strconv.Itoa(i), event); v != segment {
+				t.Errorf("uri %q: expected segment %d to be %q, got %v", test.uri, i, segment, v)
+			}
+		}
+		if v := extractTestField(t, p, "ka.uri.segment", strconv.Itoa(len(test.segments)), event); v != nil {
+			t.Errorf("uri %q: expected no segment %d, got %v", test.uri, len(test.segments), v)
+		}
+	}
+	if v := extractTestField(t, p, "ka.uri.path", "", `{"auditID":"1"}`); v != nil {
+		t.Errorf("expected no uri path, got %v", v)
+	}
+}
+
+func TestExtractURIQuery(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri    string
+		query  string
+		params map[string]interface{}
+	}{
+		{"/api/v1/pods?watch=true&fieldSelector=spec.nodeName%3Dnode-1&resourceVersion=0", "watch=true&fieldSelector=spec.nodeName%3Dnode-1&resourceVersion=0",
+			map[string]interface{}{"watch": "true", "fieldSelector": "spec.nodeName=node-1", "labelSelector": nil}},
+		{"/api/v1/namespaces/ns/pods/name/exec?command=sh&command=-c&command=id+-u&stdin=true", "command=sh&command=-c&command=id+-u&stdin=true",
+			map[string]interface{}{"command": "sh", "stdin": "true"}},
+		{"/api/v1/namespaces/ns/secrets?labelSelector=app+in+%28web%2Capi%29", "labelSelector=app+in+%28web%2Capi%29",
+			map[string]interface{}{"labelSelector": "app in (web,api)"}},
+		{"/api/v1/namespaces/ns/pods?timeout=30s&labelSelector=app%20in%20(web)&limit=500&labelSelector=tier", "timeout=30s&labelSelector=app+in+%28web%29&labelSelector=tier&limit=500",
+			map[string]interface{}{"labelSelector": "app in (web)", "limit": "500"}},
+		{"/api/v1/namespaces", "", map[string]interface{}{"watch": nil}},
+	}
+	for _, test := range tests {
+		event := fmt.Sprintf(`{"auditID":"1","requestURI":%q}`, test.uri)
+		if v := extractTestField(t, p, "ka.uri.query", "", event); v != test.query {
+			t.Errorf("uri %q: expected query %q, got %v", test.uri, test.query, v)
+		}
+		for key, expected := range test.params {
+			if v := extractTestField(t, p, "ka.uri.param", key, event); v != expected {
+				t.Errorf("uri %q: expected param %s to be %v, got %v", test.uri, key, expected, v)
+			}
+		}
+	}
+	if v := extractTestField(t, p, "ka.uri.query", "", `{"auditID":"1"}`); v != nil {
+		t.Errorf("expected no uri query, got %v", v)
+	}
+}
+
+func TestExtractAuthDecision(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		event    string
+		decision interface{}
+		reason   interface{}
+	}{
+		{
+			`{"auditID":"1","annotations":{"authorization.k8s.io/decision":"allow","authorization.k8s.io/reason":"RBAC: allowed by ClusterRoleBinding \"admin\" of ClusterRole \"cluster-admin\" to User \"admin\""}}`,
+			"allow",
+			`RBAC: allowed by ClusterRoleBinding "admin" of ClusterRole "cluster-admin" to User "admin"`,
+		},
+		{
+			`{"auditID":"1","annotations":{"authorization.k8s.io/decision":"forbid","authorization.k8s.io/reason":""}}`,
+			"forbid",
+			"",
+		},
+		{`{"auditID":"1","annotations":{"pod-security.kubernetes.io/enforce-policy":"privileged:latest"}}`, nil, nil},
+		{`{"auditID":"1"}`, nil, nil},
+	}
+	for _, test := range tests {
+		if v := extractTestField(t, p, "ka.auth.decision", "", test.event); v != test.decision {
+			t.Errorf("event %s: expected decision %v, got %v", test.event, test.decision, v)
+		}
+		if v := extractTestField(t, p, "ka.auth.reason", "", test.event); v != test.reason {
+			t.Errorf("event %s: expected reason %v, got %v", test.event, test.reason, v)
+		}
+	}
+}
+
+func TestExtractObjectNames(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a create with generateName, for which the final name is only known
+	// in the response object
+	event := `{"auditID":"1","verb":"create","requestURI":"/api/v1/namespaces/default/pods","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","metadata":{"generateName":"web-"}},"responseObject":{"kind":"Pod","metadata":{"name":"web-x7k2p","generateName":"web-"}}}`
+	if v := extractTestField(t, p, "ka.target.name", "", event); v != nil {
+		t.Errorf("expected no target name, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.name", "", event); v != nil {
+		t.Errorf("expected no request object name, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.resp.name", "", event); v != "web-x7k2p" {
+		t.Errorf("expected response object name web-x7k2p, got %v", v)
+	}
+
+	// an update, for which all the names are known
+	event = `{"auditID":"1","verb":"update","requestURI":"/api/v1/namespaces/default/pods/web","objectRef":{"resource":"pods","namespace":"default","name":"web","apiVersion":"v1"},"requestObject":{"kind":"Pod","metadata":{"name":"web"}},"responseObject":{"kind":"Pod","metadata":{"name":"web"}}}`
+	for _, field := range []string{"ka.target.name", "ka.req.name", "ka.resp.name"} {
+		if v := extractTestField(t, p, field, "", event); v != "web" {
+			t.Errorf("expected %s to be web, got %v", field, v)
+		}
+	}
+}
+
+func TestExtractServiceExposure(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+	serviceEvent := func(spec string) string {
+		return fmt.Sprintf(`{"auditID":"1","verb":"create","objectRef":{"resource":"services","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Service","metadata":{"name":"web"},"spec":%s}}`, spec)
+	}
+
+	tests := []struct {
+		name         string
+		event        string
+		serviceType  interface{}
+		nodePorts    interface{}
+		loadBalancer interface{}
+	}{
+		{
+			"clusterip-default",
+			serviceEvent(`{"ports":[{"port":80}]}`),
+			"ClusterIP", []string(nil), nil,
+		},
+		{
+			"nodeport",
+			serviceEvent(`{"type":"NodePort","ports":[{"port":80,"nodePort":30080},{"port":443,"nodePort":30443}]}`),
+			"NodePort", []string{"30080", "30443"}, nil,
+		},
+		{
+			"loadbalancer-open",
+			serviceEvent(`{"type":"LoadBalancer","ports":[{"port":443,"nodePort":31443}]}`),
+			"LoadBalancer", []string{"31443"}, []string(nil),
+		},
+		{
+			"loadbalancer-restricted",
+			serviceEvent(`{"type":"LoadBalancer","ports":[{"port":443}],"loadBalancerSourceRanges":["10.0.0.0/8","192.168.0.0/16"]}`),
+			"LoadBalancer", []string(nil), []string{"10.0.0.0/8", "192.168.0.0/16"},
+		},
+		{
+			"externalname",
+			serviceEvent(`{"type":"ExternalName","externalName":"example.com"}`),
+			"ExternalName", nil, nil,
+		},
+		{
+			"patch-without-type",
+			`{"auditID":"1","verb":"patch","objectRef":{"resource":"services","namespace":"default","apiVersion":"v1"},"requestObject":{"spec":{"ports":[{"port":8080}]}}}`,
+			nil, []string(nil), nil,
+		},
+		{
+			"non-service",
+			`{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"type":"NodePort","ports":[{"nodePort":30080}]}}}`,
+			nil, nil, nil,
+		},
+	}
+	for _, test := range tests {
+		if v := extractTestField(t, p, "ka.req.service.type", "", test.event); !reflect.DeepEqual(v, test.serviceType) {
+			t.Errorf("%s: expected service type %v, got %v", test.name, test.serviceType, v)
+		}
+		if v := extractTestField(t, p, "ka.req.service.nodeports", "", test.event); !reflect.DeepEqual(v, test.nodePorts) {
+			t.Errorf("%s: expected node ports %v, got %v", test.name, test.nodePorts, v)
+		}
+		if v := extractTestField(t, p, "ka.req.service.loadbalancer", "", test.event); !reflect.DeepEqual(v, test.loadBalancer) {
+			t.Errorf("%s: expected load balancer source ranges %v, got %v", test.name, test.loadBalancer, v)
+		}
+	}
+}
+
+func TestExtractPersistentVolumeClaim(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"persistentvolumeclaims","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"PersistentVolumeClaim","apiVersion":"v1","metadata":{"name":"data"},"spec":{"accessModes":["ReadWriteOnce","ReadOnlyMany"],"resources":{"requests":{"storage":"100Gi"}},"storageClassName":"gp3","volumeMode":"Filesystem"}}}`
+	if v := extractTestField(t, p, "ka.req.pvc.storageclass", "", event); v != "gp3" {
+		t.Errorf("expected storage class gp3, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.pvc.size", "", event); v != "100Gi" {
+		t.Errorf("expected size 100Gi, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.pvc.accessmodes", "", event); !reflect.DeepEqual(v, []string{"ReadWriteOnce", "ReadOnlyMany"}) {
+		t.Errorf("expected access modes [ReadWriteOnce ReadOnlyMany], got %v", v)
+	}
+
+	// missing fields, e.g. when relying on the default storage class
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"persistentvolumeclaims","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"PersistentVolumeClaim","spec":{}}}`
+	for _, field := range []string{"ka.req.pvc.storageclass", "ka.req.pvc.size", "ka.req.pvc.accessmodes"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+
+	// non-PVC events
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"persistentvolumes","apiVersion":"v1"},"requestObject":{"kind":"PersistentVolume","spec":{"accessModes":["ReadWriteOnce"],"storageClassName":"gp3","capacity":{"storage":"100Gi"}}}}`
+	for _, field := range []string{"ka.req.pvc.storageclass", "ka.req.pvc.size", "ka.req.pvc.accessmodes"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+}
+
+func TestExtractJobs(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"cronjobs","namespace":"default","name":"backup","apiGroup":"batch","apiVersion":"v1"},"requestObject":{"kind":"CronJob","apiVersion":"batch/v1","metadata":{"name":"backup"},"spec":{"schedule":"*/5 * * * *","jobTemplate":{"spec":{"backoffLimit":2,"template":{"spec":{"restartPolicy":"OnFailure","containers":[{"name":"backup","image":"busybox:1.36"},{"name":"upload","image":"amazon/aws-cli:2.15"}]}}}}}}}`
+	if v := extractTestField(t, p, "ka.req.cronjob.schedule", "", event); v != "*/5 * * * *" {
+		t.Errorf("expected schedule */5 * * * *, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.job.containers.image", "", event); !reflect.DeepEqual(v, []string{"busybox:1.36", "amazon/aws-cli:2.15"}) {
+		t.Errorf("expected images [busybox:1.36 amazon/aws-cli:2.15], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.job.containers.image", "1", event); !reflect.DeepEqual(v, []string{"amazon/aws-cli:2.15"}) {
+		t.Errorf("expected images [amazon/aws-cli:2.15], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.job.backofflimit", "", event); v != uint64(2) {
+		t.Errorf("expected backoff limit 2, got %v", v)
+	}
+
+	// jobs have no schedule, and a default backoff limit
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"jobs","namespace":"default","name":"migrate","apiGroup":"batch","apiVersion":"v1"},"requestObject":{"kind":"Job","apiVersion":"batch/v1","metadata":{"name":"migrate"},"spec":{"template":{"spec":{"containers":[{"name":"migrate","image":"migrate:4"}]}}}}}`
+	if v := extractTestField(t, p, "ka.req.cronjob.schedule", "", event); v != nil {
+		t.Errorf("expected no schedule, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.job.containers.image", "", event); !reflect.DeepEqual(v, []string{"migrate:4"}) {
+		t.Errorf("expected images [migrate:4], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.job.backofflimit", "", event); v != uint64(6) {
+		t.Errorf("expected default backoff limit 6, got %v", v)
+	}
+
+	// patches that don't change the backoff limit don't get the default
+	event = `{"auditID":"1","verb":"patch","objectRef":{"resource":"jobs","namespace":"default","name":"migrate","apiGroup":"batch","apiVersion":"v1"},"requestObject":{"spec":{"suspend":true}}}`
+	if v := extractTestField(t, p, "ka.req.job.backofflimit", "", event); v != nil {
+		t.Errorf("expected no backoff limit, got %v", v)
+	}
+
+	// non-job events
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"containers":[{"name":"nginx","image":"nginx"}]}}}`
+	for _, field := range []string{"ka.req.cronjob.schedule", "ka.req.job.containers.image", "ka.req.job.backofflimit"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+}
+
+func TestExtractNetworkPolicy(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"networkpolicies","namespace":"default","name":"api","apiGroup":"networking.k8s.io","apiVersion":"v1"},"requestObject":{"kind":"NetworkPolicy","apiVersion":"networking.k8s.io/v1","metadata":{"name":"api"},"spec":{"podSelector":{"matchLabels":{"app":"api"}},"policyTypes":["Ingress","Egress"],
+		"ingress":[{"from":[{"namespaceSelector":{"matchLabels":{"team":"web"}}}],"ports":[{"protocol":"TCP","port":443},{"port":"metrics"}]},{"ports":[{"protocol":"UDP","port":8000,"endPort":9000},{"protocol":"SCTP"}]},{"from":[{"ipBlock":{"cidr":"10.0.0.0/8"}}]}],
+		"egress":[{"to":[{"ipBlock":{"cidr":"0.0.0.0/0","except":["169.254.169.254/32"]}},{"podSelector":{}}]},{"to":[{"ipBlock":{"cidr":"10.1.0.0/16"}}],"ports":[{"port":5432}]}]}}}`
+	if v := extractTestField(t, p, "ka.req.netpol.policytypes", "", event); !reflect.DeepEqual(v, []string{"Ingress", "Egress"}) {
+		t.Errorf("expected policy types [Ingress Egress], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.netpol.ingress.ports", "", event); !reflect.DeepEqual(v, []string{"443/TCP", "metrics/TCP", "8000-9000/UDP", "*/SCTP"}) {
+		t.Errorf("expected ingress ports [443/TCP metrics/TCP 8000-9000/UDP */SCTP], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.netpol.egress.cidrs", "", event); !reflect.DeepEqual(v, []string{"0.0.0.0/0", "10.1.0.0/16"}) {
+		t.Errorf("expected egress cidrs [0.0.0.0/0 10.1.0.0/16], got %v", v)
+	}
+
+	// policy types are implied by the rules when not specified
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"networkpolicies","namespace":"default","name":"deny","apiGroup":"networking.k8s.io","apiVersion":"v1"},"requestObject":{"kind":"NetworkPolicy","spec":{"podSelector":{},"egress":[{}]}}}`
+	if v := extractTestField(t, p, "ka.req.netpol.policytypes", "", event); !reflect.DeepEqual(v, []string{"Ingress", "Egress"}) {
+		t.Errorf("expected policy types [Ingress Egress], got %v", v)
+	}
+	for _, field := range []string{"ka.req.netpol.ingress.ports", "ka.req.netpol.egress.cidrs"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+
+	// non-NetworkPolicy events
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"services","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Service","spec":{"ports":[{"port":443}]}}}`
+	for _, field := range []string{"ka.req.netpol.policytypes", "ka.req.netpol.ingress.ports", "ka.req.netpol.egress.cidrs"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+}
+
+func TestExtractToken(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","user":{"username":"system:kube-proxy"},"objectRef":{"resource":"tokenreviews","apiGroup":"authentication.k8s.io","apiVersion":"v1"},
+		"requestObject":{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","spec":{"token":"eyJhbGciOiJSUzI1NiJ9.e30.c2ln","audiences":["https://kubernetes.default.svc"]}},
+		"responseObject":{"kind":"TokenReview","apiVersion":"authentication.k8s.io/v1","status":{"authenticated":true,"audiences":["https://kubernetes.default.svc","vault"],"user":{"username":"system:serviceaccount:ci:builder","groups":["system:serviceaccounts"]}}}}`
+	if v := extractTestField(t, p, "ka.token.issuer", "", event); v != nil {
+		t.Errorf("expected no issuer, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.token.audiences", "", event); !reflect.DeepEqual(v, []string{"https://kubernetes.default.svc", "vault"}) {
+		t.Errorf("expected audiences [https://kubernetes.default.svc vault], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.token.sub", "", event); v != "system:serviceaccount:ci:builder" {
+		t.Errorf("expected subject system:serviceaccount:ci:builder, got %v", v)
+	}
+
+	// OIDC usernames are prefixed by the issuer, and the requested
+	// audiences are used when the review failed
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"tokenreviews","apiGroup":"authentication.k8s.io","apiVersion":"v1"},
+		"requestObject":{"kind":"TokenReview","spec":{"audiences":["api"]}},
+		"responseObject":{"kind":"TokenReview","status":{"authenticated":false,"user":{"username":"https://accounts.example.com#1234567890"}}}}`
+	if v := extractTestField(t, p, "ka.token.issuer", "", event); v != "https://accounts.example.com" {
+		t.Errorf("expected issuer https://accounts.example.com, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.token.sub", "", event); v != "1234567890" {
+		t.Errorf("expected subject 1234567890, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.token.audiences", "", event); !reflect.DeepEqual(v, []string{"api"}) {
+		t.Errorf("expected audiences [api], got %v", v)
+	}
+
+	// service account token requests
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"serviceaccounts","subresource":"token","namespace":"ci","name":"builder","apiVersion":"v1"},
+		"requestObject":{"kind":"TokenRequest","apiVersion":"authentication.k8s.io/v1","spec":{"audiences":["vault"],"expirationSeconds":600}}}`
+	if v := extractTestField(t, p, "ka.token.sub", "", event); v != "system:serviceaccount:ci:builder" {
+		t.Errorf("expected subject system:serviceaccount:ci:builder, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.token.audiences", "", event); !reflect.DeepEqual(v, []string{"vault"}) {
+		t.Errorf("expected audiences [vault], got %v", v)
+	}
+
+	// unrelated events
+	event = `{"auditID":"1","verb":"get","user":{"username":"alice"},"objectRef":{"resource":"serviceaccounts","namespace":"ci","name":"builder","apiVersion":"v1"}}`
+	for _, field := range []string{"ka.token.issuer", "ka.token.audiences", "ka.token.sub"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+}
+
+func TestExtractAdmissionWebhooks(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"mutatingwebhookconfigurations","name":"injector","apiGroup":"admissionregistration.k8s.io","apiVersion":"v1"},"requestObject":{"kind":"MutatingWebhookConfiguration","apiVersion":"admissionregistration.k8s.io/v1","metadata":{"name":"injector"},"webhooks":[
+		{"name":"inject.example.com","clientConfig":{"url":"https://203.0.113.7:8443/mutate"},"failurePolicy":"Ignore","namespaceSelector":{"matchExpressions":[{"key":"kubernetes.io/metadata.name","operator":"NotIn","values":["kube-system"]}]},"sideEffects":"None","admissionReviewVersions":["v1"]},
+		{"name":"sidecar.example.com","clientConfig":{"service":{"namespace":"mesh","name":"injector","path":"/inject"}},"sideEffects":"None","admissionReviewVersions":["v1"]}]}}`
+	if v := extractTestField(t, p, "ka.req.webhook.name", "", event); !reflect.DeepEqual(v, []string{"inject.example.com", "sidecar.example.com"}) {
+		t.Errorf("expected names [inject.example.com sidecar.example.com], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.webhook.url", "", event); !reflect.DeepEqual(v, []string{"https://203.0.113.7:8443/mutate", "https://injector.mesh.svc:443/inject"}) {
+		t.Errorf("unexpected urls: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.webhook.failurepolicy", "", event); !reflect.DeepEqual(v, []string{"Ignore", "Fail"}) {
+		t.Errorf("expected failure policies [Ignore Fail], got %v", v)
+	}
+	expected := []string{`{"matchExpressions":[{"key":"kubernetes.io/metadata.name","operator":"NotIn","values":["kube-system"]}]}`, `{}`}
+	if v := extractTestField(t, p, "ka.req.webhook.namespaceselector", "", event); !reflect.DeepEqual(v, expected) {
+		t.Errorf("unexpected namespace selectors: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.webhook.url", "1", event); !reflect.DeepEqual(v, []string{"https://injector.mesh.svc:443/inject"}) {
+		t.Errorf("unexpected url of the second webhook: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.webhook.name", "2", event); v != nil {
+		t.Errorf("expected no value for a missing webhook, got %v", v)
+	}
+
+	// validating webhooks, unrelated events
+	event = strings.Replace(strings.Replace(event, "mutatingwebhookconfigurations", "validatingwebhookconfigurations", 1), "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration", 1)
+	if v := extractTestField(t, p, "ka.req.webhook.name", "0", event); !reflect.DeepEqual(v, []string{"inject.example.com"}) {
+		t.Errorf("expected names [inject.example.com], got %v", v)
+	}
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"configmaps","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"ConfigMap","webhooks":[{"name":"fake"}]}}`
+	for _, field := range []string{"ka.req.webhook.name", "ka.req.webhook.url", "ka.req.webhook.failurepolicy", "ka.req.webhook.namespaceselector"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+}
+
+func TestExtractDryRun(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	for event, expected := range map[string]string{
+		`{"auditID":"1","verb":"create","requestURI":"/apis/apps/v1/namespaces/default/deployments?dryRun=All\u0026fieldManager=kubectl-client-side-apply","objectRef":{"resource":"deployments","namespace":"default","apiGroup":"apps","apiVersion":"v1"}}`: "true",
+		`{"auditID":"1","verb":"create","requestURI":"/apis/apps/v1/namespaces/default/deployments?fieldManager=kubectl-client-side-apply","objectRef":{"resource":"deployments","namespace":"default","apiGroup":"apps","apiVersion":"v1"}}`:                 "false",
+		`{"auditID":"1","verb":"delete","requestURI":"/api/v1/namespaces/default/pods/web","objectRef":{"resource":"pods","namespace":"default","name":"web","apiVersion":"v1"},"requestObject":{"kind":"DeleteOptions","apiVersion":"v1","dryRun":["All"]}}`: "true",
+		`{"auditID":"1","verb":"get","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"}}`:                                                                                                                                                "false",
+	} {
+		if v := extractTestField(t, p, "ka.req.dryrun", "", event); v != expected {
+			t.Errorf("expected %s, got %v for event %s", expected, v, event)
+		}
+	}
+}
+
+func TestExtractRoleRules(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"clusterroles","name":"escalate","apiGroup":"rbac.authorization.k8s.io","apiVersion":"v1"},
+		"requestObject":{"kind":"ClusterRole","metadata":{"name":"escalate"},"rules":[{"apiGroups":[""],"resources":["pods","pods/exec"],"verbs":["get","create"]},{"apiGroups":["*"],"resources":["*"],"verbs":["*"]}]}}`
+	if v := extractTestField(t, p, "ka.req.role.rules.verbs", "", event); !reflect.DeepEqual(v, []string{"get", "create", "*"}) {
+		t.Errorf("unexpected verbs: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.role.rules.resources", "", event); !reflect.DeepEqual(v, []string{"pods", "pods/exec", "*"}) {
+		t.Errorf("unexpected resources: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.role.rules.resources", "0", event); !reflect.DeepEqual(v, []string{"pods", "pods/exec"}) {
+		t.Errorf("unexpected resources of the first rule: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.role.grants_wildcard", "", event); v != "true" {
+		t.Errorf("expected a wildcard grant, got %v", v)
+	}
+
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"roles","namespace":"default","name":"reader","apiGroup":"rbac.authorization.k8s.io","apiVersion":"v1"},
+		"requestObject":{"kind":"Role","metadata":{"name":"reader"},"rules":[{"apiGroups":[""],"resources":["configmaps"],"verbs":["get","list"]}]}}`
+	if v := extractTestField(t, p, "ka.req.role.grants_wildcard", "", event); v != "false" {
+		t.Errorf("expected no wildcard grant, got %v", v)
+	}
+
+	// not a role
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{}}}`
+	if v := extractTestField(t, p, "ka.req.role.grants_wildcard", "", event); v != nil {
+		t.Errorf("expected no value, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.role.rules.verbs", "", event); v != nil {
+		t.Errorf("expected no verbs, got %v", v)
+	}
+}
+
+func TestExtractSourceIPsCount(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	for event, expected := range map[string]uint64{
+		`{"auditID":"1","verb":"get","sourceIPs":["10.0.0.1"]}`:                           1,
+		`{"auditID":"1","verb":"get","sourceIPs":["203.0.113.7","10.0.0.20","10.0.0.1"]}`: 3,
+		`{"auditID":"1","verb":"get","sourceIPs":[]}`:                                     0,
+		`{"auditID":"1","verb":"get"}`:                                                    0,
+	} {
+		if v := extractTestField(t, p, "ka.sourceips.count", "", event); v != expected {
+			t.Errorf("expected %d, got %v for event %s", expected, v, event)
+		}
+	}
+}
+
+func TestExtractContainerSecurityContext(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	privileged := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","name":"debug","apiVersion":"v1"},
+		"requestObject":{"kind":"Pod","spec":{"containers":[{"name":"shell","image":"busybox","securityContext":{"privileged":true,"runAsUser":0,"capabilities":{"add":["SYS_ADMIN","NET_ADMIN"]}}},{"name":"sidecar","image":"envoy"}]}}}`
+	hardened := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","name":"web","apiVersion":"v1"},
+		"requestObject":{"kind":"Pod","spec":{"securityContext":{"runAsUser":1000,"runAsNonRoot":true},"containers":[{"name":"web","image":"nginx","securityContext":{"allowPrivilegeEscalation":false,"readOnlyRootFilesystem":true,"capabilities":{"drop":["ALL"],"add":["NET_BIND_SERVICE"]}}},{"name":"metrics","image":"exporter","securityContext":{"runAsUser":2000,"allowPrivilegeEscalation":false,"readOnlyRootFilesystem":true}}]}}}`
+
+	for _, test := range []struct {
+		event    string
+		field    string
+		arg      string
+		expected interface{}
+	}{
+		{privileged, "ka.req.container.seccontext.run_as_user", "", []string{"0", ""}},
+		{privileged, "ka.req.container.seccontext.run_as_non_root", "", []string{"false", "false"}},
+		{privileged, "ka.req.container.seccontext.allow_privilege_escalation", "", []string{"true", "true"}},
+		{privileged, "ka.req.container.seccontext.read_only_root_filesystem", "", []string{"false", "false"}},
+		{privileged, "ka.req.container.seccontext.capabilities.add", "", []string{"SYS_ADMIN", "NET_ADMIN"}},
+		{privileged, "ka.req.container.seccontext.capabilities.add", "1", []string(nil)},
+		{hardened, "ka.req.container.seccontext.run_as_user", "", []string{"1000", "2000"}},
+		{hardened, "ka.req.container.seccontext.run_as_user", "1", []string{"2000"}},
+		{hardened, "ka.req.container.seccontext.run_as_non_root", "", []string{"true", "true"}},
+		{hardened, "ka.req.container.seccontext.allow_privilege_escalation", "", []string{"false", "false"}},
+		{hardened, "ka.req.container.seccontext.read_only_root_filesystem", "0", []string{"true"}},
+		{hardened, "ka.req.container.seccontext.capabilities.add", "0", []string{"NET_BIND_SERVICE"}},
+		{hardened, "ka.req.container.seccontext.run_as_user", "2", nil},
+		{`{"auditID":"1","verb":"get","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"}}`, "ka.req.container.seccontext.allow_privilege_escalation", "", nil},
+	} {
+		if v := extractTestField(t, p, test.field, test.arg, test.event); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected %v, got %v for %s[%s]", test.expected, v, test.field, test.arg)
+		}
+	}
+}
+
+func TestExtractIngress(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"ingresses","namespace":"default","name":"web","apiGroup":"networking.k8s.io","apiVersion":"v1"},
+		"requestObject":{"kind":"Ingress","spec":{"defaultBackend":{"service":{"name":"fallback","port":{"number":80}}},
+		"tls":[{"hosts":["shop.example.com","api.example.com"],"secretName":"example-tls"},{"hosts":["admin.example.com"],"secretName":"admin-tls"}],
+		"rules":[{"host":"shop.example.com","http":{"paths":[{"path":"/","pathType":"Prefix","backend":{"service":{"name":"shop","port":{"number":80}}}},{"path":"/static","pathType":"Prefix","backend":{"service":{"name":"static","port":{"name":"http"}}}}]}},
+		{"host":"api.example.com","http":{"paths":[{"path":"/","pathType":"Prefix","backend":{"service":{"name":"api","port":{"number":8080}}}}]}},
+		{"host":"admin.example.com","http":{"paths":[{"path":"/","pathType":"Prefix","backend":{"service":{"name":"shop","port":{"number":80}}}}]}},
+		{"http":{"paths":[{"path":"/","pathType":"Prefix","backend":{"resource":{"apiGroup":"k8s.example.com","kind":"StorageBucket","name":"assets"}}}]}}]}}}`
+	legacy := `{"auditID":"1","verb":"create","objectRef":{"resource":"ingresses","namespace":"default","name":"web","apiGroup":"extensions","apiVersion":"v1beta1"},
+		"requestObject":{"kind":"Ingress","spec":{"rules":[{"host":"legacy.example.com","http":{"paths":[{"path":"/","backend":{"serviceName":"legacy","servicePort":80}}]}}]}}}`
+	pod := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"containers":[]}}}`
+
+	for _, test := range []struct {
+		event    string
+		field    string
+		expected interface{}
+	}{
+		{event, "ka.req.ingress.hosts", []string{"shop.example.com", "api.example.com", "admin.example.com"}},
+		{event, "ka.req.ingress.tls_secrets", []string{"example-tls", "admin-tls"}},
+		{event, "ka.req.ingress.backend_services", []string{"fallback", "shop", "static", "api"}},
+		{legacy, "ka.req.ingress.hosts", []string{"legacy.example.com"}},
+		{legacy, "ka.req.ingress.tls_secrets", nil},
+		{legacy, "ka.req.ingress.backend_services", []string{"legacy"}},
+		{pod, "ka.req.ingress.hosts", nil},
+		{pod, "ka.req.ingress.backend_services", nil},
+	} {
+		if v := extractTestField(t, p, test.field, "", test.event); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected %v, got %v for %s", test.expected, v, test.field)
+		}
+	}
+}
+
+func TestExtractQuotaAndLimitRange(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	quota := `{"auditID":"1","verb":"create","objectRef":{"resource":"resourcequotas","namespace":"tenant-a","name":"compute","apiVersion":"v1"},
+		"requestObject":{"kind":"ResourceQuota","spec":{"hard":{"requests.cpu":"10","limits.memory":"20Gi","pods":50}}}}`
+	limitRange := `{"auditID":"1","verb":"create","objectRef":{"resource":"limitranges","namespace":"tenant-a","name":"defaults","apiVersion":"v1"},
+		"requestObject":{"kind":"LimitRange","spec":{"limits":[{"type":"Container","max":{"cpu":"2"},"default":{"cpu":"500m","memory":"512Mi"}},{"type":"PersistentVolumeClaim","min":{"storage":"1Gi"}}]}}}`
+	emptyQuota := `{"auditID":"1","verb":"create","objectRef":{"resource":"resourcequotas","namespace":"tenant-a","name":"empty","apiVersion":"v1"},
+		"requestObject":{"kind":"ResourceQuota","spec":{"scopes":["BestEffort"]}}}`
+	pod := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"containers":[]}}}`
+
+	for _, test := range []struct {
+		event    string
+		field    string
+		expected interface{}
+	}{
+		{quota, "ka.req.quota.hard", []string{"requests.cpu=10", "limits.memory=20Gi", "pods=50"}},
+		{quota, "ka.req.limitrange.limits", nil},
+		{limitRange, "ka.req.limitrange.limits", []string{"Container.max.cpu=2", "Container.default.cpu=500m", "Container.default.memory=512Mi", "PersistentVolumeClaim.min.storage=1Gi"}},
+		{limitRange, "ka.req.quota.hard", nil},
+		{emptyQuota, "ka.req.quota.hard", nil},
+		{pod, "ka.req.quota.hard", nil},
+		{pod, "ka.req.limitrange.limits", nil},
+	} {
+		if v := extractTestField(t, p, test.field, "", test.event); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected %v, got %v for %s", test.expected, v, test.field)
+		}
+	}
+}
+
+func TestExtractNode(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	update := `{"auditID":"1","verb":"update","objectRef":{"resource":"nodes","name":"worker-1","apiVersion":"v1"},
+		"requestObject":{"kind":"Node","metadata":{"name":"worker-1","labels":{"kubernetes.io/hostname":"worker-1","node-role.kubernetes.io/ingress":""}},
+		"spec":{"podCIDR":"10.244.1.0/24","taints":[{"key":"dedicated","value":"attacker","effect":"NoSchedule"},{"key":"node.kubernetes.io/unreachable","effect":"NoExecute"}]}}}`
+	cordon := `{"auditID":"1","verb":"patch","objectRef":{"resource":"nodes","name":"worker-1","apiVersion":"v1"},
+		"requestObject":{"spec":{"unschedulable":true}}}`
+	uncordon := `{"auditID":"1","verb":"patch","objectRef":{"resource":"nodes","name":"worker-1","apiVersion":"v1"},
+		"requestObject":{"spec":{"unschedulable":null}}}`
+	labelPatch := `{"auditID":"1","verb":"patch","objectRef":{"resource":"nodes","name":"worker-1","apiVersion":"v1"},
+		"requestObject":{"metadata":{"labels":{"topology.kubernetes.io/zone":"zone-b","old-label":null}}}}`
+	status := `{"auditID":"1","verb":"patch","objectRef":{"resource":"nodes","name":"worker-1","apiVersion":"v1","subresource":"status"},
+		"requestObject":{"metadata":{"labels":{"a":"b"}},"spec":{}}}`
+	pod := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"containers":[]}}}`
+
+	for _, test := range []struct {
+		event    string
+		field    string
+		expected interface{}
+	}{
+		{update, "ka.req.node.taints", []string{"dedicated=attacker:NoSchedule", "node.kubernetes.io/unreachable:NoExecute"}},
+		{update, "ka.req.node.labels", []string{"kubernetes.io/hostname=worker-1", "node-role.kubernetes.io/ingress="}},
+		{update, "ka.req.node.unschedulable", nil},
+		{cordon, "ka.req.node.taints", nil},
+		{cordon, "ka.req.node.labels", nil},
+		{cordon, "ka.req.node.unschedulable", "true"},
+		{uncordon, "ka.req.node.unschedulable", "false"},
+		{labelPatch, "ka.req.node.labels", []string{"topology.kubernetes.io/zone=zone-b"}},
+		{labelPatch, "ka.req.node.unschedulable", nil},
+		{status, "ka.req.node.labels", nil},
+		{status, "ka.req.node.unschedulable", nil},
+		{pod, "ka.req.node.taints", nil},
+		{pod, "ka.req.node.labels", nil},
+		{pod, "ka.req.node.unschedulable", nil},
+	} {
+		if v := extractTestField(t, p, test.field, "", test.event); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected %v, got %v for %s", test.expected, v, test.field)
+		}
+	}
+}
+
+func TestExtractDeployment(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	create := `{"auditID":"1","verb":"create","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"kind":"Deployment","spec":{"replicas":2,"template":{"spec":{"initContainers":[{"name":"init","image":"busybox:1.36"}],"containers":[{"name":"web","image":"nginx:1.25"},{"name":"sidecar","image":"envoy:1.29"}]}}}}}`
+	recreate := `{"auditID":"1","verb":"update","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"kind":"Deployment","spec":{"strategy":{"type":"Recreate"},"template":{"spec":{"containers":[{"name":"web","image":"nginx:1.26"}]}}}}}`
+	setImage := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"spec":{"template":{"spec":{"containers":[{"name":"sidecar","image":"evil.example.com/envoy:1.29"}]}}}}}`
+	setInitImage := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"spec":{"template":{"spec":{"$setElementOrder/initContainers":[{"name":"init"}],"initContainers":[{"name":"init","image":"evil.example.com/busybox"}]}}}}}`
+	scale := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"spec":{"replicas":5,"template":{"metadata":{"annotations":{"kubectl.kubernetes.io/restartedAt":"2024-01-01T00:00:00Z"}}}}}}`
+	jsonPatch := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":[{"op":"replace","path":"/spec/replicas","value":3},{"op":"replace","path":"/spec/template/spec/containers/0/image","value":"nginx:latest"}]}`
+	jsonPatchContainer := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":[{"op":"add","path":"/spec/template/spec/containers/-","value":{"name":"miner","image":"xmrig"}}]}`
+	jsonPatchLabels := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":[{"op":"add","path":"/spec/template/metadata/labels/tier","value":"web"},{"op":"copy","from":"/metadata/labels/app","path":"/spec/template/metadata/labels/app"}]}`
+	jsonPatchCopy := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":[{"op":"copy","from":"/spec/template/spec/initContainers/0","path":"/spec/template/spec/containers/1"}]}`
+	scaleSubresource := `{"auditID":"1","verb":"patch","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1","subresource":"scale"},
+		"requestObject":{"spec":{"replicas":5}}}`
+	pod := `{"auditID":"1","verb":"patch","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"spec":{"template":{"spec":{"containers":[{"name":"web","image":"nginx"}]}}}}}`
+
+	for _, test := range []struct {
+		event    string
+		field    string
+		arg      string
+		expected interface{}
+	}{
+		{create, "ka.req.deployment.strategy", "", "RollingUpdate"},
+		{create, "ka.req.deployment.images", "", []string{"nginx:1.25", "envoy:1.29", "busybox:1.36"}},
+		{create, "ka.req.deployment.images", "1", []string{"envoy:1.29"}},
+		{create, "ka.req.deployment.images", "2", []string{"busybox:1.36"}},
+		{create, "ka.req.deployment.images", "3", nil},
+		{create, "ka.req.deployment.image_changed", "", nil},
+		{recreate, "ka.req.deployment.strategy", "", "Recreate"},
+		{recreate, "ka.req.deployment.images", "", []string{"nginx:1.26"}},
+		{recreate, "ka.req.deployment.image_changed", "", nil},
+		{setImage, "ka.req.deployment.strategy", "", nil},
+		{setImage, "ka.req.deployment.images", "", []string{"evil.example.com/envoy:1.29"}},
+		{setImage, "ka.req.deployment.image_changed", "", "true"},
+		{setInitImage, "ka.req.deployment.images", "", []string{"evil.example.com/busybox"}},
+		{setInitImage, "ka.req.deployment.image_changed", "", "true"},
+		{scale, "ka.req.deployment.image_changed", "", "false"},
+		{jsonPatch, "ka.req.deployment.image_changed", "", "true"},
+		{jsonPatchContainer, "ka.req.deployment.image_changed", "", "true"},
+		{jsonPatchLabels, "ka.req.deployment.image_changed", "", "false"},
+		{jsonPatchCopy, "ka.req.deployment.image_changed", "", "true"},
+		{scaleSubresource, "ka.req.deployment.image_changed", "", nil},
+		{pod, "ka.req.deployment.strategy", "", nil},
+		{pod, "ka.req.deployment.images", "", nil},
+		{pod, "ka.req.deployment.image_changed", "", nil},
+	} {
+		if v := extractTestField(t, p, test.field, test.arg, test.event); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected %v, got %v for %s[%s] of %s", test.expected, v, test.field, test.arg, test.event)
+		}
+	}
+}
+
+func TestExtractChangedFields(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"update","objectRef":{"resource":"deployments","namespace":"default","name":"web","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"kind":"Deployment","metadata":{"name":"web","resourceVersion":"100","labels":{"app":"web"}},"spec":{"replicas":3,"template":{"spec":{"containers":[{"name":"web","image":"nginx:1.25"},{"name":"sidecar","image":"envoy:1.29"}]}}}},
+		"responseObject":{"kind":"Deployment","metadata":{"name":"web","resourceVersion":"101","generation":4,"uid":"7b2f","creationTimestamp":"2024-01-01T00:00:00Z","managedFields":[{"manager":"kubectl"}],"labels":{"app":"web","tier":"frontend"}},"spec":{"replicas":3,"template":{"spec":{"containers":[{"name":"web","image":"nginx:1.25","imagePullPolicy":"IfNotPresent"},{"name":"sidecar","image":"envoy:1.30"}]}}}}}`
+	expected := []string{
+		"metadata.labels.tier",
+		"spec.template.spec.containers[0].imagePullPolicy",
+		"spec.template.spec.containers[1].image",
+	}
+	if v := extractTestField(t, p, "ka.req.changed_fields", "", event); !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected changed fields %v, got %v", expected, v)
+	}
+
+	// identical objects
+	event = `{"auditID":"1","verb":"update","requestObject":{"spec":{"replicas":3}},"responseObject":{"metadata":{"resourceVersion":"2"},"spec":{"replicas":3}}}`
+	if v := extractTestField(t, p, "ka.req.changed_fields", "", event); !reflect.DeepEqual(v, []string(nil)) {
+		t.Errorf("expected no changed fields, got %v", v)
+	}
+
+	// array length and type changes
+	event = `{"auditID":"1","verb":"update","requestObject":{"spec":{"ports":[{"port":80},{"port":443}],"selector":"web"}},"responseObject":{"spec":{"ports":[{"port":80}],"selector":{"app":"web"}}}}`
+	expected = []string{"spec.ports[1].port", "spec.selector"}
+	if v := extractTestField(t, p, "ka.req.changed_fields", "", event); !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected changed fields %v, got %v", expected, v)
+	}
+
+	// the response object is not available at the Request audit level
+	event = `{"auditID":"1","verb":"update","requestObject":{"spec":{"replicas":3}}}`
+	if v := extractTestField(t, p, "ka.req.changed_fields", "", event); v != nil {
+		t.Errorf("expected no value, got %v", v)
+	}
+}
+
+func TestExtractSensitiveHostPath(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	podWithVolumes := func(volumes string) string {
+		return `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},
+			"requestObject":{"kind":"Pod","spec":{"containers":[{"name":"c","image":"docker:cli"}],"volumes":[` + volumes + `]}}}`
+	}
+	dockerSocket := podWithVolumes(`{"name":"cache","emptyDir":{}},{"name":"docker","hostPath":{"path":"/var/run/docker.sock","type":"Socket"}}`)
+	varRun := podWithVolumes(`{"name":"run","hostPath":{"path":"/var/run/"}}`)
+	root := podWithVolumes(`{"name":"root","hostPath":{"path":"/"}}`)
+	procSelf := podWithVolumes(`{"name":"proc","hostPath":{"path":"/proc/1/root"}}`)
+	logs := podWithVolumes(`{"name":"logs","hostPath":{"path":"/var/log/pods"}},{"name":"procfs","hostPath":{"path":"/procfs"}}`)
+	noHostPath := podWithVolumes(`{"name":"cache","emptyDir":{}}`)
+	noVolumes := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"containers":[]}}}`
+	noRequestObject := `{"auditID":"1","verb":"delete","objectRef":{"resource":"pods","namespace":"default","name":"web","apiVersion":"v1"}}`
+	deployment := `{"auditID":"1","verb":"create","objectRef":{"resource":"deployments","namespace":"default","apiGroup":"apps","apiVersion":"v1"},
+		"requestObject":{"kind":"Deployment","spec":{"template":{"spec":{"containers":[]}},"volumes":[{"name":"docker","hostPath":{"path":"/var/run/docker.sock"}}]}}}`
+
+	for _, test := range []struct {
+		event    string
+		field    string
+		expected interface{}
+	}{
+		{dockerSocket, "ka.req.pod.volumes.hostpath", []string{"/var/run/docker.sock"}},
+		{dockerSocket, "ka.req.pod.mounts_sensitive_path", "true"},
+		{varRun, "ka.req.pod.mounts_sensitive_path", "true"},
+		{root, "ka.req.pod.mounts_sensitive_path", "true"},
+		{procSelf, "ka.req.pod.mounts_sensitive_path", "true"},
+		{logs, "ka.req.pod.mounts_sensitive_path", "false"},
+		{noHostPath, "ka.req.pod.mounts_sensitive_path", "false"},
+		{noVolumes, "ka.req.pod.mounts_sensitive_path", "false"},
+		{noRequestObject, "ka.req.pod.mounts_sensitive_path", nil},
+		{deployment, "ka.req.pod.mounts_sensitive_path", nil},
+	} {
+		if v := extractTestField(t, p, test.field, "", test.event); !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("expected %v, got %v for %s", test.expected, v, test.field)
+		}
+	}
+
+	// the sensitive paths are configurable
+	if err := p.Init(`{"sensitiveHostPaths":["/var/log"]}`); err != nil {
+		t.Fatal(err)
+	}
+	if v := extractTestField(t, p, "ka.req.pod.mounts_sensitive_path", "", dockerSocket); v != "false" {
+		t.Errorf("expected false, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.pod.mounts_sensitive_path", "", logs); v != "true" {
+		t.Errorf("expected true, got %v", v)
+	}
+	if err := p.Init(`{"sensitiveHostPaths":["var/log"]}`); err == nil {
+		t.Error("expected an error for a relative sensitive host path")
+	}
+}
+
+func TestExtractTargetScope(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		objectRef string
+		scope     interface{}
+		namespace interface{}
+	}{
+		{`{"resource":"pods","namespace":"default","name":"nginx","apiVersion":"v1"}`, "namespaced", "default"},
+		{`{"resource":"pods","namespace":"default","name":"nginx","apiVersion":"v1","subresource":"exec"}`, "namespaced", "default"},
+		// list of the pods of a namespace, and of all namespaces
+		{`{"resource":"pods","namespace":"default","apiVersion":"v1"}`, "namespaced", "default"},
+		{`{"resource":"pods","apiVersion":"v1"}`, "cluster", nil},
+		{`{"resource":"clusterroles","name":"admin","apiGroup":"rbac.authorization.k8s.io","apiVersion":"v1"}`, "cluster", nil},
+		{`{"resource":"clusterroles","apiGroup":"rbac.authorization.k8s.io","apiVersion":"v1"}`, "cluster", nil},
+		{`{"resource":"namespaces","namespace":"prod","name":"prod","apiVersion":"v1"}`, "cluster", "prod"},
+		{"", nil, nil},
+	} {
+		event := `{"auditID":"1","verb":"get","requestURI":"/healthz"}`
+		if len(test.objectRef) > 0 {
+			event = `{"auditID":"1","verb":"get","objectRef":` + test.objectRef + `}`
+		}
+		if v := extractTestField(t, p, "ka.target.scope", "", event); v != test.scope {
+			t.Errorf("expected scope %v, got %v for %s", test.scope, v, test.objectRef)
+		}
+		if v := extractTestField(t, p, "ka.target.namespace", "", event); v != test.namespace {
+			t.Errorf("expected namespace %v, got %v for %s", test.namespace, v, test.objectRef)
+		}
+	}
+}
+
+func TestExtractRequestLabelsAndAnnotations(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","apiVersion":"v1","metadata":{"name":"nginx","namespace":"default","labels":{"app":"nginx","app.kubernetes.io/name":"web","canary":""},"annotations":{"sidecar.istio.io/inject":"false"}},"spec":{"containers":[{"name":"nginx","image":"nginx"}]}}}`
+	for _, test := range []struct {
+		field    string
+		key      string
+		expected interface{}
+	}{
+		{"ka.req.label", "app", "nginx"},
+		{"ka.req.label", "app.kubernetes.io/name", "web"},
+		{"ka.req.label", "canary", ""},
+		// missing keys are told apart from empty values
+		{"ka.req.label", "tier", nil},
+		{"ka.req.annotation", "sidecar.istio.io/inject", "false"},
+		{"ka.req.annotation", "app", nil},
+	} {
+		if v := extractTestField(t, p, test.field, test.key, event); v != test.expected {
+			t.Errorf("expected %v, got %v for %s[%s]", test.expected, v, test.field, test.key)
+		}
+	}
+
+	// requests without an object
+	event = `{"auditID":"1","verb":"get","objectRef":{"resource":"pods","namespace":"default","name":"nginx","apiVersion":"v1"}}`
+	for _, field := range []string{"ka.req.label", "ka.req.annotation"} {
+		if v := extractTestField(t, p, field, "app", event); v != nil {
+			t.Errorf("expected no value, got %v for %s", v, field)
+		}
+	}
+}
+
+func TestExtractNamespacePodSecurityLevels(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a namespace relabeled to weaken the enforced level
+	event := `{"auditID":"1","verb":"patch","objectRef":{"resource":"namespaces","name":"prod","apiVersion":"v1"},"requestObject":{"metadata":{"labels":{"pod-security.kubernetes.io/enforce":"privileged","pod-security.kubernetes.io/warn":"restricted"}}}}`
+	for field, expected := range map[string]string{
+		"ka.req.namespace.pss_enforce": "privileged",
+		"ka.req.namespace.pss_audit":   "",
+		"ka.req.namespace.pss_warn":    "restricted",
+	} {
+		if v := extractTestField(t, p, field, "", event); v != expected {
+			t.Errorf("expected %q, got %v for %s", expected, v, field)
+		}
+	}
+
+	// a namespace without labels
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"namespaces","name":"dev","apiVersion":"v1"},"requestObject":{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"dev"}}}`
+	if v := extractTestField(t, p, "ka.req.namespace.pss_enforce", "", event); v != "" {
+		t.Errorf("expected an empty value, got %v", v)
+	}
+
+	// other resources
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","apiVersion":"v1","metadata":{"name":"nginx","labels":{"pod-security.kubernetes.io/enforce":"privileged"}}}}`
+	if v := extractTestField(t, p, "ka.req.namespace.pss_enforce", "", event); v != nil {
+		t.Errorf("expected no value, got %v", v)
+	}
+}
+
+func TestExtractResponseItemsCount(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		responseObject string
+		expected       uint64
+	}{
+		{`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[{"metadata":{"name":"a"}},{"metadata":{"name":"b"}},{"metadata":{"name":"c"}}]}`, 3},
+		{`{"kind":"PodList","apiVersion":"v1","metadata":{"resourceVersion":"1"},"items":[]}`, 0},
+		{`{"kind":"Pod","apiVersion":"v1","metadata":{"name":"a"}}`, 0},
+		{`{"kind":"Status","apiVersion":"v1","status":"Success","details":{"kind":"pods"}}`, 0},
+	} {
+		event := `{"auditID":"1","verb":"list","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"responseObject":` + test.responseObject + `}`
+		if v := extractTestField(t, p, "ka.resp.items.count", "", event); v != test.expected {
+			t.Errorf("expected %d, got %v for %s", test.expected, v, test.responseObject)
+		}
+	}
+
+	// responses without body
+	event := `{"auditID":"1","verb":"list","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"}}`
+	if v := extractTestField(t, p, "ka.resp.items.count", "", event); v != uint64(0) {
+		t.Errorf("expected 0, got %v", v)
+	}
+}
+
+func TestExtractCustomResourceDefinition(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := `{"auditID":"1","verb":"create","objectRef":{"resource":"customresourcedefinitions","apiGroup":"apiextensions.k8s.io","apiVersion":"v1"},"requestObject":{"kind":"CustomResourceDefinition","apiVersion":"apiextensions.k8s.io/v1","metadata":{"name":"crontabs.stable.example.com"},"spec":{"group":"stable.example.com","versions":[{"name":"v1","served":true,"storage":true}],"scope":"Namespaced","names":{"plural":"crontabs","singular":"crontab","kind":"CronTab","shortNames":["ct"]}}}}`
+	for field, expected := range map[string]string{
+		"ka.req.crd.group": "stable.example.com",
+		"ka.req.crd.kind":  "CronTab",
+		"ka.req.crd.scope": "Namespaced",
+	} {
+		if v := extractTestField(t, p, field, "", event); v != expected {
+			t.Errorf("expected %q, got %v for %s", expected, v, field)
+		}
+	}
+
+	// other resources, and requests without a definition
+	for _, event := range []string{
+		`{"auditID":"1","verb":"create","objectRef":{"resource":"crontabs","apiGroup":"stable.example.com","apiVersion":"v1"},"requestObject":{"kind":"CronTab","apiVersion":"stable.example.com/v1","metadata":{"name":"cron"},"spec":{"group":"stable.example.com","scope":"Cluster"}}}`,
+		`{"auditID":"1","verb":"delete","objectRef":{"resource":"customresourcedefinitions","name":"crontabs.stable.example.com","apiGroup":"apiextensions.k8s.io","apiVersion":"v1"}}`,
+	} {
+		for _, field := range []string{"ka.req.crd.group", "ka.req.crd.kind", "ka.req.crd.scope"} {
+			if v := extractTestField(t, p, field, "", event); v != nil {
+				t.Errorf("expected no value, got %v for %s", v, field)
+			}
+		}
+	}
+}
+
+func TestExtractRequestPatch(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+	patchEvent := func(patch string) string {
+		return `{"auditID":"1","verb":"patch","objectRef":{"resource":"pods","namespace":"default","name":"nginx","apiVersion":"v1"},"requestObject":` + patch + `}`
+	}
+
+	// JSON patch escalating the privileges of a container
+	event := patchEvent(`[{"op":"replace","path":"/spec/containers/0/securityContext/privileged","value":true},{"op":"move","from":"/metadata/labels/app","path":"/metadata/labels/name"}]`)
+	expected := []string{"replace /spec/containers/0/securityContext/privileged", "move /metadata/labels/name"}
+	if v := extractTestField(t, p, "ka.req.patch.ops", "", event); !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected ops %v, got %v", expected, v)
+	}
+	for pointer, expected := range map[string]string{
+		"/spec/containers/0/securityContext/privileged": "true",
+		"/spec/containers/0/securityContext":            "true",
+		"/spec/containers/0/securityContext/runAsUser":  "false",
+		"/spec/containers/1/securityContext/privileged": "false",
+		"/metadata/labels/app":                          "true",
+		"/metadata/labels/name":                         "true",
+		"/metadata/annotations":                         "false",
+	} {
+		if v := extractTestField(t, p, "ka.req.patch.touches", pointer, event); v != expected {
+			t.Errorf("expected %s for %s, got %v", expected, pointer, v)
+		}
+	}
+
+	// strategic merge patch escalating the privileges of a container, whose
+	// arrays are not expanded
+	event = patchEvent(`{"metadata":{"annotations":{"example.com/owner":null}},"spec":{"hostPID":true,"containers":[{"name":"nginx","securityContext":{"privileged":true}}],"$setElementOrder/containers":[{"name":"nginx"}]}}`)
+	expected = []string{"remove /metadata/annotations/example.com~1owner", "merge /spec/containers", "merge /spec/hostPID"}
+	if v := extractTestField(t, p, "ka.req.patch.ops", "", event); !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected ops %v, got %v", expected, v)
+	}
+	for pointer, expected := range map[string]string{
+		"/spec/containers/0/securityContext/privileged": "true",
+		"/spec/hostPID":     "true",
+		"/spec/hostNetwork": "false",
+		"/metadata/annotations/example.com~1owner": "true",
+		"/metadata/labels":                         "false",
+	} {
+		if v := extractTestField(t, p, "ka.req.patch.touches", pointer, event); v != expected {
+			t.Errorf("expected %s for %s, got %v", expected, pointer, v)
+		}
+	}
+
+	// only available for patch requests
+	event = `{"auditID":"1","verb":"update","objectRef":{"resource":"pods","namespace":"default","name":"nginx","apiVersion":"v1"},"requestObject":{"spec":{"hostPID":true}}}`
+	if v := extractTestField(t, p, "ka.req.patch.ops", "", event); v != nil {
+		t.Errorf("expected no ops, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.patch.touches", "/spec", patchEvent(`"invalid"`)); v != nil {
+		t.Errorf("expected no value, got %v", v)
+	}
+}
+
+func TestExtractLevel(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []string{"None", "Metadata", "Request", "RequestResponse"} {
+		event := `{"auditID":"1","level":"` + level + `","stage":"ResponseComplete","verb":"get"}`
+		if v := extractTestField(t, p, "ka.level", "", event); v != level {
+			t.Errorf("expected level %s, got %v", level, v)
+		}
+	}
+	if v := extractTestField(t, p, "ka.level", "", `{"auditID":"1","verb":"get"}`); v != nil {
+		t.Errorf("expected no level, got %v", v)
+	}
+}
+
+func TestExtractEndpointsAddresses(t *testing.T) {
+	p := &Plugin{}
+	if err := p.Init("{}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// endpoint slice update adding an external address
+	event := `{"auditID":"1","verb":"update","objectRef":{"resource":"endpointslices","namespace":"default","name":"web-abc12","apiGroup":"discovery.k8s.io","apiVersion":"v1"},"requestObject":{"kind":"EndpointSlice","apiVersion":"discovery.k8s.io/v1","metadata":{"name":"web-abc12","namespace":"default","labels":{"kubernetes.io/service-name":"web"}},"addressType":"IPv4","endpoints":[{"addresses":["10.244.1.5"],"conditions":{"ready":true}},{"addresses":["203.0.113.66"],"conditions":{"ready":true}}],"ports":[{"name":"http","port":8080,"protocol":"TCP"}]}}`
+	if v := extractTestField(t, p, "ka.req.endpointslice.addresses", "", event); !reflect.DeepEqual(v, []string{"10.244.1.5", "203.0.113.66"}) {
+		t.Errorf("expected addresses [10.244.1.5 203.0.113.66], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.endpoints.addresses", "", event); v != nil {
+		t.Errorf("expected no endpoints addresses, got %v", v)
+	}
+
+	event = `{"auditID":"1","verb":"update","objectRef":{"resource":"endpoints","namespace":"default","name":"web","apiVersion":"v1"},"requestObject":{"kind":"Endpoints","apiVersion":"v1","metadata":{"name":"web","namespace":"default"},"subsets":[{"addresses":[{"ip":"10.244.1.5"},{"ip":"203.0.113.66"}],"notReadyAddresses":[{"ip":"10.244.2.7"}],"ports":[{"port":8080}]}]}}`
+	if v := extractTestField(t, p, "ka.req.endpoints.addresses", "", event); !reflect.DeepEqual(v, []string{"10.244.1.5", "203.0.113.66", "10.244.2.7"}) {
+		t.Errorf("expected addresses [10.244.1.5 203.0.113.66 10.244.2.7], got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.req.endpointslice.addresses", "", event); v != nil {
+		t.Errorf("expected no endpoint slice addresses, got %v", v)
+	}
+
+	// unrelated events
+	event = `{"auditID":"1","verb":"create","objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"requestObject":{"kind":"Pod","spec":{"containers":[{"name":"nginx","image":"nginx"}]}}}`
+	for _, field := range []string{"ka.req.endpoints.addresses", "ka.req.endpointslice.addresses"} {
+		if v := extractTestField(t, p, field, "", event); v != nil {
+			t.Errorf("expected no value for %s, got %v", field, v)
+		}
+	}
+}
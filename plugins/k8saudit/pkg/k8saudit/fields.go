@@ -34,6 +34,11 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 			Name: "ka.stage",
 			Desc: "Stage of the request (e.g. RequestReceived, ResponseComplete, etc.)",
 		},
+		{
+			Type: "string",
+			Name: "ka.level",
+			Desc: "The audit level assigned to the request by the audit policy (e.g. Metadata, Request, RequestResponse), which determines whether the request and response objects are available",
+		},
 		{
 			Type: "string",
 			Name: "ka.auth.decision",
@@ -70,6 +75,22 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 			Name: "ka.impuser.name",
 			Desc: "The impersonated user name",
 		},
+		{
+			Type: "string",
+			Name: "ka.token.issuer",
+			Desc: "For token reviews, the issuer of the reviewed token when the authenticated username is prefixed by it (e.g. https://issuer.example.com#alice for OIDC tokens). The token itself is never decoded",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.token.audiences",
+			Desc:   "For token reviews and service account token requests, the audiences of the token (the ones of the review result when available; otherwise the requested ones)",
+			IsList: true,
+		},
+		{
+			Type: "string",
+			Name: "ka.token.sub",
+			Desc: "For token reviews, the subject the reviewed token has been authenticated as (e.g. system:serviceaccount:default:builder). For service account token requests, the service account the token is requested for",
+		},
 		{
 			Type: "string",
 			Name: "ka.verb",
@@ -89,16 +110,58 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsKey:      true,
 			},
 		},
+		{
+			Type: "string",
+			Name: "ka.uri.query",
+			Desc: "The query parameters of the request URI, in the name=value form separated by & and percent-encoded in a canonical form (e.g. when uri=/api/v1/pods?watch=true&labelSelector=app%20in%20(web), ka.uri.query is watch=true&labelSelector=app+in+%28web%29). Parameters keep the order in which their name first appears, and repeated parameters are grouped together keeping the order of their values. Empty when the request URI has no query",
+		},
+		{
+			Type: "string",
+			Name: "ka.uri.path",
+			Desc: "The path of the request URI, without the query and any trailing slash, with each segment percent-encoded in a canonical form (e.g. when uri=/api/v1/pods/?watch=true, ka.uri.path is /api/v1/pods, and when uri=/api/v1/namespaces/ns/configmaps/my+config%7E, ka.uri.path is /api/v1/namespaces/ns/configmaps/my+config~).",
+		},
+		{
+			Type: "string",
+			Name: "ka.uri.segment",
+			Desc: "The value of a given segment of the request URI path, percent-decoded and starting from 0 (e.g. when uri=/api/v1/namespaces/ns, ka.uri.segment[3] is ns).",
+			Arg: sdk.FieldEntryArg{
+				IsRequired: true,
+				IsIndex:    true,
+			},
+		},
 		{
 			Type: "string",
 			Name: "ka.target.name",
-			Desc: "The target object name",
+			Desc: "The target object name, as referenced by the request URI. Empty when the name is not known at request time, such as on creation or when using generateName; use ka.resp.name in that case",
 		},
 		{
 			Type: "string",
 			Name: "ka.target.namespace",
 			Desc: "The target object namespace",
 		},
+		{
+			Type: "string",
+			Name: "ka.target.scope",
+			Desc: "The scope of the request, either namespaced when restricted to a namespace (e.g. on a pod, or on the pods of a namespace), or cluster otherwise (e.g. on a clusterrole or a namespace, or on the pods of all namespaces). Not available for requests to non-resource URLs",
+		},
+		{
+			Type: "string",
+			Name: "ka.target.namespace.label",
+			Desc: "The value of a given label of the target object namespace. Only available for events read by the plugin's event source when a namespace lookup is configured",
+			Arg: sdk.FieldEntryArg{
+				IsRequired: true,
+				IsKey:      true,
+			},
+		},
+		{
+			Type: "string",
+			Name: "ka.target.namespace.annotation",
+			Desc: "The value of a given annotation of the target object namespace. Only available for events read by the plugin's event source when a namespace lookup is configured",
+			Arg: sdk.FieldEntryArg{
+				IsRequired: true,
+				IsKey:      true,
+			},
+		},
 		{
 			Type: "string",
 			Name: "ka.target.resource",
@@ -114,6 +177,49 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 			Name: "ka.target.pod.name",
 			Desc: "The target pod name",
 		},
+		{
+			Type: "string",
+			Name: "ka.req.dryrun",
+			Desc: "Return true if the request is a dry-run one (e.g. with the ?dryRun=All parameter), whose changes are not persisted. Return false otherwise",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.name",
+			Desc: "The name of the object sent in the request body. Empty when the request has no body, or when using generateName",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.label",
+			Desc: "The value of a given label of the request object (e.g. ka.req.label[app.kubernetes.io/name]). Not available when the request has no object or when the object has no such label",
+			Arg: sdk.FieldEntryArg{
+				IsRequired: true,
+				IsKey:      true,
+			},
+		},
+		{
+			Type: "string",
+			Name: "ka.req.annotation",
+			Desc: "The value of a given annotation of the request object (e.g. ka.req.annotation[sidecar.istio.io/inject]). Not available when the request has no object or when the object has no such annotation",
+			Arg: sdk.FieldEntryArg{
+				IsRequired: true,
+				IsKey:      true,
+			},
+		},
+		{
+			Type: "string",
+			Name: "ka.req.namespace.pss_enforce",
+			Desc: "When the request object refers to a namespace, the Pod Security Standards level enforced, whose violations are rejected (the pod-security.kubernetes.io/enforce label, e.g. baseline). Empty when the namespace has no such label",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.namespace.pss_audit",
+			Desc: "When the request object refers to a namespace, the Pod Security Standards level audited, whose violations are recorded in the audit events (the pod-security.kubernetes.io/audit label, e.g. baseline). Empty when the namespace has no such label",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.namespace.pss_warn",
+			Desc: "When the request object refers to a namespace, the Pod Security Standards level warned about, whose violations are returned as warnings to the users (the pod-security.kubernetes.io/warn label, e.g. baseline). Empty when the namespace has no such label",
+		},
 		{
 			Type:   "string",
 			Name:   "ka.req.binding.subjects",
@@ -345,6 +451,11 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsIndex:    true,
 			},
 		},
+		{
+			Type: "string",
+			Name: "ka.req.role.grants_wildcard",
+			Desc: "When the request object refers to a role/cluster role, return true if any of its rules grants the \"*\" wildcard as api group, resource or verb. Return false otherwise",
+		},
 		{
 			Type: "string",
 			Name: "ka.req.pod.fs_group",
@@ -366,10 +477,60 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsIndex:    true,
 			},
 		},
+		{
+			Type:   "string",
+			Name:   "ka.req.container.seccontext.run_as_user",
+			Desc:   "When the request object refers to a pod, the effective runAsUser of each container (or of the one at the given index), inherited from the pod security context if not set. Empty if set by neither, in which case the image user is used",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.container.seccontext.run_as_non_root",
+			Desc:   "When the request object refers to a pod, the effective runAsNonRoot flag of each container (or of the one at the given index), inherited from the pod security context if not set. Defaults to false",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.container.seccontext.allow_privilege_escalation",
+			Desc:   "When the request object refers to a pod, the allowPrivilegeEscalation flag of each container (or of the one at the given index). Defaults to true, as in Kubernetes",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.container.seccontext.capabilities.add",
+			Desc:   "When the request object refers to a pod, the capabilities added to all the containers (or to the one at the given index)",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.container.seccontext.read_only_root_filesystem",
+			Desc:   "When the request object refers to a pod, the readOnlyRootFilesystem flag of each container (or of the one at the given index). Defaults to false",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
 		{
 			Type: "string",
 			Name: "ka.req.service.type",
-			Desc: "When the request object refers to a service, the service type",
+			Desc: "When the request object refers to a service, the service type (ClusterIP if not specified at creation)",
 		},
 		{
 			Type:   "string",
@@ -381,6 +542,210 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsIndex:    true,
 			},
 		},
+		{
+			Type:   "string",
+			Name:   "ka.req.service.nodeports",
+			Desc:   "When the request object refers to a service, the node ports requested for the service's ports",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.service.loadbalancer",
+			Desc:   "When the request object refers to a LoadBalancer service, the source IP ranges allowed to reach the load balancer. Empty if the load balancer is reachable from any address",
+			IsList: true,
+		},
+		{
+			Type: "string",
+			Name: "ka.req.pvc.storageclass",
+			Desc: "When the request object refers to a persistent volume claim, the requested storage class",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.pvc.size",
+			Desc: "When the request object refers to a persistent volume claim, the requested storage size (e.g. 10Gi)",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.pvc.accessmodes",
+			Desc:   "When the request object refers to a persistent volume claim, the requested access modes (e.g. ReadWriteOnce)",
+			IsList: true,
+		},
+		{
+			Type: "string",
+			Name: "ka.req.cronjob.schedule",
+			Desc: "When the request object refers to a cronjob, its schedule in cron format",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.job.containers.image",
+			Desc:   "When the request object refers to a job or cronjob, the images of the containers of its pod template",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type: "uint64",
+			Name: "ka.req.job.backofflimit",
+			Desc: "When the request object refers to a job or cronjob, the number of retries before marking the job as failed (6 if not specified at creation)",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.deployment.strategy",
+			Desc: "When the request object refers to a deployment, its strategy type (e.g. RollingUpdate or Recreate). Defaults to RollingUpdate when not specified, except for patches that don't set it",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.deployment.images",
+			Desc:   "When the request object refers to a deployment, the images of the containers of its pod template, followed by the ones of its init containers",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type: "string",
+			Name: "ka.req.deployment.image_changed",
+			Desc: "When the request patches a deployment, return true if the patch sets the image of any container or init container of its pod template (e.g. kubectl set image). Return false otherwise. Not available for other verbs, since audit events don't contain the previous version of the objects",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.netpol.policytypes",
+			Desc:   "When the request object refers to a network policy, its policy types (Ingress and/or Egress). When not specified, Ingress is always included, and Egress is included if the policy has egress rules",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.netpol.ingress.ports",
+			Desc:   "When the request object refers to a network policy, the ports allowed by all its ingress rules formatted as <port>/<protocol> (e.g. 443/TCP or 8000-9000/TCP). The port is * when only the protocol is restricted. Rules without ports allow all ports and are not included",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.netpol.egress.cidrs",
+			Desc:   "When the request object refers to a network policy, the IP blocks (CIDRs) allowed as destination by all its egress rules",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.ingress.hosts",
+			Desc:   "When the request object refers to an ingress, the hostnames of all its rules",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.ingress.tls_secrets",
+			Desc:   "When the request object refers to an ingress, the names of the secrets of its TLS configurations",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.ingress.backend_services",
+			Desc:   "When the request object refers to an ingress, the names of the services used as backend by its default backend and by all the paths of its rules",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.endpoints.addresses",
+			Desc:   "When the request object refers to an endpoints object, the IP addresses of all its subsets, including the ones not ready",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.endpointslice.addresses",
+			Desc:   "When the request object refers to an endpoint slice, the addresses of all its endpoints, which are IP addresses or FQDNs depending on its address type",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.quota.hard",
+			Desc:   "When the request object refers to a resource quota, its hard limits as resource=limit pairs (e.g. requests.cpu=10)",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.limitrange.limits",
+			Desc:   "When the request object refers to a limit range, its limits as type.constraint.resource=value entries (e.g. Container.max.cpu=2)",
+			IsList: true,
+		},
+		{
+			Type: "string",
+			Name: "ka.req.crd.group",
+			Desc: "When the request object refers to a custom resource definition, the API group of the custom resources it defines (e.g. stable.example.com)",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.crd.kind",
+			Desc: "When the request object refers to a custom resource definition, the kind of the custom resources it defines (e.g. CronTab)",
+		},
+		{
+			Type: "string",
+			Name: "ka.req.crd.scope",
+			Desc: "When the request object refers to a custom resource definition, the scope of the custom resources it defines, either Namespaced or Cluster",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.node.taints",
+			Desc:   "When the request object refers to a node, its taints in the key=value:effect form, or key:effect for taints with no value (e.g. dedicated=gpu:NoSchedule)",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.node.labels",
+			Desc:   "When the request object refers to a node, its labels as key=value pairs (e.g. node-role.kubernetes.io/control-plane=)",
+			IsList: true,
+		},
+		{
+			Type: "string",
+			Name: "ka.req.node.unschedulable",
+			Desc: "When the request object refers to a node, return true if it is marked as unschedulable (e.g. when cordoned) and false if it is marked as schedulable. Not available if the request object does not set it",
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.webhook.name",
+			Desc:   "When the request object refers to a validating or mutating admission webhook configuration, the names of its webhooks",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.webhook.url",
+			Desc:   "When the request object refers to a validating or mutating admission webhook configuration, the URLs called by its webhooks. Webhooks referring to a service are formatted as https://<service>.<namespace>.svc:<port><path>",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.webhook.failurepolicy",
+			Desc:   "When the request object refers to a validating or mutating admission webhook configuration, the failure policies of its webhooks (Ignore or Fail; defaults to Fail)",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.webhook.namespaceselector",
+			Desc:   "When the request object refers to a validating or mutating admission webhook configuration, the namespace selectors of its webhooks in JSON format ({} when matching all namespaces)",
+			IsList: true,
+			Arg: sdk.FieldEntryArg{
+				IsRequired: false,
+				IsIndex:    true,
+			},
+		},
 		{
 			Type:   "string",
 			Name:   "ka.req.pod.volumes.hostpath",
@@ -391,6 +756,11 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsIndex:    true,
 			},
 		},
+		{
+			Type: "string",
+			Name: "ka.req.pod.mounts_sensitive_path",
+			Desc: "When the request object refers to a pod, return true if any of its hostPath volumes mounts one of the host paths of the sensitiveHostPaths init config (e.g. /var/run/docker.sock), a path below one of them, or a directory containing one of them. Return false otherwise",
+		},
 		{
 			Type: "string",
 			Name: "ka.req.volume.hostpath",
@@ -420,10 +790,36 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsIndex:    true,
 			},
 		},
+		{
+			Type:   "string",
+			Name:   "ka.req.changed_fields",
+			Desc:   "The JSON paths (e.g. spec.template.spec.containers[0].image) whose value differs between the request and the response objects, ignoring the metadata managed by the API server such as managedFields and resourceVersion. Only available with the RequestResponse audit level",
+			IsList: true,
+		},
+		{
+			Type:   "string",
+			Name:   "ka.req.patch.ops",
+			Desc:   "The operations of the patch of a patch request, in the op path form where path is a JSON pointer (e.g. replace /spec/containers/0/securityContext/privileged). The operations of JSON patches are returned as they are. Merge patches, either JSON or strategic ones, are returned as a merge operation for each value they set and as a remove operation for each null value; their arrays are not expanded since their elements are merged by key or replaced as a whole depending on the patch, and the directives of strategic merge patches are ignored. Only available for patch requests with the Request or RequestResponse audit levels",
+			IsList: true,
+		},
+		{
+			Type: "string",
+			Name: "ka.req.patch.touches",
+			Desc: "Return true if the patch of a patch request modifies the value at a given JSON pointer (e.g. ka.req.patch.touches[/spec/containers/0/securityContext/privileged]), either directly or by modifying a value containing it or a value below it. Return false otherwise. Since the arrays of merge patches are not expanded, any change to an array of a merge patch is considered to touch all its elements. Only available for patch requests with the Request or RequestResponse audit levels",
+			Arg: sdk.FieldEntryArg{
+				IsRequired: true,
+				IsKey:      true,
+			},
+		},
 		{
 			Type: "string",
 			Name: "ka.resp.name",
-			Desc: "The response object name",
+			Desc: "The name of the object returned in the response body, which is the final name assigned by the API server (e.g. when using generateName). Only available with the RequestResponse audit level",
+		},
+		{
+			Type: "uint64",
+			Name: "ka.resp.items.count",
+			Desc: "The number of items of the list returned in the response body (e.g. a PodList), such as the objects read by a list or deleted by a deletecollection request. Only known with the RequestResponse audit level. Return 0 if not available or if the response is not a list",
 		},
 		{
 			Type: "string",
@@ -440,6 +836,11 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 			Name: "ka.useragent",
 			Desc: "The useragent of the client who made the request to the apiserver",
 		},
+		{
+			Type: "string",
+			Name: "ka.client.kind",
+			Desc: "The kind of client who made the request to the apiserver, classified from its useragent (e.g. kubectl, helm, kubelet, control-plane, gitops, client-go, http-client, browser, unknown)",
+		},
 		{
 			Type:   "string",
 			Name:   "ka.sourceips",
@@ -450,10 +851,40 @@ func (k *Plugin) Fields() []sdk.FieldEntry {
 				IsIndex:    true,
 			},
 		},
+		{
+			Type: "uint64",
+			Name: "ka.sourceips.count",
+			Desc: "The number of IP addresses of the client who made the request to the apiserver, including the intermediate proxies. Return 0 if not available",
+		},
 		{
 			Type: "string",
 			Name: "ka.cluster.name",
-			Desc: "The name of the k8s cluster",
+			Desc: "The name of the k8s cluster. For events read by the plugin's event source, defaults to the clusterName init config value if the event doesn't specify one",
+		},
+		{
+			Type: "uint64",
+			Name: "ka.ingest.latency_ms",
+			Desc: "The time elapsed in milliseconds between the event stage timestamp and its ingestion by the plugin. Only available for events read by the plugin's event source",
+		},
+		{
+			Type: "uint64",
+			Name: "ka.ingest.time",
+			Desc: "The time at which the event has been ingested by the plugin, in nanoseconds since epoch. Only available for events read by the plugin's event source",
+		},
+		{
+			Type: "string",
+			Name: "ka.source.type",
+			Desc: "The type of the source the event has been read from (file, http, https or reader). Only available for events read by the plugin's event source",
+		},
+		{
+			Type: "string",
+			Name: "ka.source.file",
+			Desc: "The path of the file the event has been read from. Only available for events read by the plugin's event source from local files",
+		},
+		{
+			Type: "uint64",
+			Name: "ka.source.line",
+			Desc: "The line number of the event within the file it has been read from, starting from 1. Only available for events read by the plugin's event source from local files",
 		},
 	}
 }
@@ -0,0 +1,216 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// forwardQueueSize is the number of webhook payloads that can wait to be
+// forwarded, after which the new ones are dropped
+const forwardQueueSize = 1000
+
+// forwardQueueFullLogInterval is the minimum time between two logs of the
+// payloads dropped from the full forward queue
+const forwardQueueFullLogInterval = time.Minute
+
+// forwarder relays the webhook payloads received by the event source to the
+// webhook of a single other instance, so that instances can be chained. Payloads
+// are forwarded intact and in order by a single goroutine, so that a slow or
+// unreachable target never blocks the event source. Failed requests are
+// retried with an exponential backoff, and the payloads that still can't be
+// forwarded are written to the dead-letter file if configured.
+type forwarder struct {
+	plugin  *Plugin
+	url     string
+	client  *http.Client
+	retries uint64
+	backoff time.Duration
+	fullLog *successLogSampler
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan []byte
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// newForwarder returns a forwarder to the target of the forwardURL config,
+// which must be started with start
+func (k *Plugin) newForwarder() (*forwarder, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: k.Config.ForwardTLSInsecureSkipVerify}
+	if len(k.Config.ForwardTLSCACertificate) > 0 {
+		pem, err := ioutil.ReadFile(k.Config.ForwardTLSCACertificate)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in forward TLS CA certificate: %s", k.Config.ForwardTLSCACertificate)
+		}
+	}
+	if len(k.Config.ForwardTLSClientCertificate) > 0 {
+		// note: the key and the certificate are concatenated in the same file,
+		// like for the sslCertificate config
+		cert, err := tls.LoadX509KeyPair(k.Config.ForwardTLSClientCertificate, k.Config.ForwardTLSClientCertificate)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	ctx, cancel := context.WithCancel(context.Background())
+	return &forwarder{
+		plugin: k,
+		url:    k.Config.ForwardURL,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(k.Config.ForwardTimeout) * time.Second,
+		},
+		retries: k.Config.ForwardMaxRetries,
+		backoff: time.Duration(k.Config.ForwardRetryBackoffMs) * time.Millisecond,
+		fullLog: newSuccessLogSampler(0, forwardQueueFullLogInterval),
+		queue:   make(chan []byte, forwardQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (f *forwarder) start() {
+	go func() {
+		defer close(f.done)
+		for payload := range f.queue {
+			if f.ctx.Err() != nil {
+				f.fail("payload dropped while shutting down forwarder", payload)
+				continue
+			}
+			if err := f.send(payload); err != nil {
+				f.plugin.logger.Printf("can't forward payload to %s: %s", f.url, err.Error())
+				f.fail("payload could not be forwarded", payload)
+				continue
+			}
+			f.plugin.metrics.inc(&f.plugin.metrics.forwardedPayloads)
+		}
+	}()
+}
+
+// forward enqueues a payload to be forwarded, and returns false if it can't
+// because the queue is full or the forwarder is closed
+func (f *forwarder) forward(payload []byte) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return false
+	}
+	select {
+	case f.queue <- payload:
+		return true
+	default:
+		// note: the dropped payloads are counted by the callers, so that
+		// the log is only a periodic summary
+		if ok, n := f.fullLog.sample(); ok {
+			f.plugin.logger.Printf("forward queue full, %d payload(s) not forwarded since the last log", n)
+		}
+		return false
+	}
+}
+
+// close waits for the queued payloads to be forwarded within the given
+// timeout, after which the in-flight request and the remaining payloads
+// are dropped
+func (f *forwarder) close(timeout time.Duration) {
+	f.mu.Lock()
+	f.closed = true
+	close(f.queue)
+	f.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+	case <-timer.C:
+		f.cancel()
+		<-f.done
+	}
+	f.cancel()
+}
+
+func (f *forwarder) fail(reason string, payload []byte) {
+	f.plugin.metrics.inc(&f.plugin.metrics.forwardFailedPayloads)
+	f.plugin.writeDeadLetter(reason, payload)
+}
+
+// send forwards a payload, and retries up to the configured number of times
+// on network errors and on the responses that are worth retrying
+func (f *forwarder) send(payload []byte) error {
+	backoff := f.backoff
+	for attempt := uint64(0); ; attempt++ {
+		retry, err := f.post(payload)
+		if err == nil || !retry || attempt >= f.retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-f.ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single request to the target, and returns whether it's worth
+// retrying if it failed
+func (f *forwarder) post(payload []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(f.ctx, "POST", f.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := f.client.Do(req)
+	if err != nil {
+		return f.ctx.Err() == nil, err
+	}
+	// note: the body is drained so that the connection can be reused
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected response status: %s", res.Status)
+}
+
+// validateForwardURL returns an error if u is not an absolute HTTP or HTTPS URL
+func validateForwardURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return fmt.Errorf("invalid forward URL: %s", u)
+	}
+	return nil
+}
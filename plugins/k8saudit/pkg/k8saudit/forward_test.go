@@ -0,0 +1,290 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// forwardTestTarget is a stub of the webhook of the next instance, which
+// records the received payloads and fails the first given requests
+type forwardTestTarget struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	requests int
+	payloads chan string
+}
+
+func newForwardTestTarget(failures, status int) *forwardTestTarget {
+	return &forwardTestTarget{failures: failures, status: status, payloads: make(chan string, 100)}
+}
+
+func (f *forwardTestTarget) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.requests <= f.failures {
+		w.WriteHeader(f.status)
+		return
+	}
+	if req.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.payloads <- string(body)
+}
+
+func (f *forwardTestTarget) receive(t *testing.T) string {
+	select {
+	case payload := <-f.payloads:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a forwarded payload")
+		return ""
+	}
+}
+
+func TestForward(t *testing.T) {
+	event := testAuditEvent(time.Now())
+	for _, only := range []bool{false, true} {
+		target := newForwardTestTarget(1, http.StatusServiceUnavailable)
+		srv := httptest.NewServer(target)
+		p := newTestPlugin(t, fmt.Sprintf(`{"forwardURL":%q,"forwardOnly":%t,"forwardRetryBackoffMs":1}`, srv.URL, only))
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		producer, err := p.startWebServer(addr, "/k8s-audit", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		postTestEvent(t, "http://"+addr+"/k8s-audit")
+		res, err := http.Post("http://"+addr+"/k8s-audit", "application/json", strings.NewReader(event))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		// payloads are forwarded intact and in order, after retrying the
+		// failed request
+		target.receive(t)
+		if payload := target.receive(t); payload != event {
+			t.Fatalf("expected payload %q to be forwarded, got %q", event, payload)
+		}
+
+		// events are only pushed in addition to being forwarded
+		if !only {
+			receiveTestEvents(t, producer, 2)
+		}
+		go func() {
+			for evt := range producer.events {
+				if evt.Err == nil {
+					t.Errorf("unexpected event pushed with forwardOnly")
+				}
+			}
+		}()
+		producer.close()
+		srv.Close()
+		if p.metrics.forwardedPayloads != 2 || p.metrics.forwardFailedPayloads != 0 {
+			t.Fatalf("expected 2 forwarded and 0 failed payloads, got %d and %d", p.metrics.forwardedPayloads, p.metrics.forwardFailedPayloads)
+		}
+	}
+}
+
+func TestForwardRetries(t *testing.T) {
+	event := testAuditEvent(time.Now())
+	for _, test := range []struct {
+		status   int
+		requests int
+	}{
+		// client errors are not retried
+		{http.StatusBadRequest, 1},
+		// server errors are retried up to forwardMaxRetries times
+		{http.StatusInternalServerError, 3},
+	} {
+		target := newForwardTestTarget(100, test.status)
+		srv := httptest.NewServer(target)
+		deadLetterPath := filepath.Join(t.TempDir(), "dead-letter.log")
+		p := newTestPlugin(t, fmt.Sprintf(`{"forwardURL":%q,"forwardMaxRetries":2,"forwardRetryBackoffMs":1,"deadLetterPath":%q}`, srv.URL, deadLetterPath))
+		f, err := p.newForwarder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.start()
+		f.forward([]byte(event))
+		f.close(5 * time.Second)
+		srv.Close()
+		if target.requests != test.requests {
+			t.Errorf("expected %d requests with status %d, got %d", test.requests, test.status, target.requests)
+		}
+		if p.metrics.forwardFailedPayloads != 1 {
+			t.Errorf("expected 1 failed payload, got %d", p.metrics.forwardFailedPayloads)
+		}
+		deadLetters, err := ioutil.ReadFile(deadLetterPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(deadLetters), event) {
+			t.Errorf("expected the payload to be written to the dead-letter file, got %q", deadLetters)
+		}
+	}
+}
+
+func TestForwardTLS(t *testing.T) {
+	target := newForwardTestTarget(0, 0)
+	srv := httptest.NewTLSServer(target)
+	defer srv.Close()
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caPath, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		config    string
+		forwarded bool
+	}{
+		{`{"forwardURL":%q}`, false},
+		{`{"forwardURL":%q,"forwardMaxRetries":0,"forwardTLSInsecureSkipVerify":true}`, true},
+		{`{"forwardURL":%q,"forwardMaxRetries":0,"forwardTLSCACertificate":"` + caPath + `"}`, true},
+	} {
+		p := newTestPlugin(t, fmt.Sprintf(test.config, srv.URL))
+		f, err := p.newForwarder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.retries = 0
+		f.start()
+		f.forward([]byte(testAuditEvent(time.Now())))
+		f.close(5 * time.Second)
+		if forwarded := p.metrics.forwardedPayloads == 1; forwarded != test.forwarded {
+			t.Errorf("expected forwarded to be %t with config %s", test.forwarded, test.config)
+		}
+	}
+}
+
+func TestForwardConfig(t *testing.T) {
+	for _, cfg := range []string{
+		`{"forwardURL":"localhost:8080"}`,
+		`{"forwardURL":"ftp://localhost/k8s-audit"}`,
+		`{"forwardOnly":true}`,
+	} {
+		if err := newTestPlugin(t, "{}").Init(cfg); err == nil {
+			t.Errorf("expected an error with config %s", cfg)
+		}
+	}
+	p := newTestPlugin(t, `{"forwardTLSCACertificate":"/nonexistent/ca.pem","forwardURL":"https://localhost/k8s-audit"}`)
+	if _, err := p.newForwarder(); err == nil {
+		t.Error("expected an error with a missing CA certificate")
+	}
+}
+
+func TestForwardQueueFull(t *testing.T) {
+	target := newForwardTestTarget(0, 0)
+	srv := httptest.NewServer(target)
+	defer srv.Close()
+	p := newTestPlugin(t, fmt.Sprintf(`{"forwardURL":%q,"forwardOnly":true}`, srv.URL))
+	f, err := p.newForwarder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// payloads that can't be queued are reported, so that the webhook
+	// rejects them in forwardOnly mode
+	for i := 0; i < forwardQueueSize; i++ {
+		if !f.forward([]byte("{}")) {
+			t.Fatalf("expected payload %d to be queued", i)
+		}
+	}
+	var logs bytes.Buffer
+	p.logger = log.New(&logs, "", 0)
+	for i := 0; i < 10; i++ {
+		if f.forward([]byte("{}")) {
+			t.Fatal("expected payload not to be queued in the full queue")
+		}
+	}
+	// the dropped payloads are only logged periodically
+	if n := strings.Count(logs.String(), "forward queue full"); n != 1 {
+		t.Fatalf("expected 1 log of the full queue, got %d: %s", n, logs.String())
+	}
+	f.start()
+	f.close(0)
+	if f.forward([]byte("{}")) {
+		t.Fatal("expected payload not to be queued by the closed forwarder")
+	}
+
+	// the webhook waits for the payload to be queued even without
+	// webhookEnqueueTimeoutMs, so that the rejection reaches the sender
+	h := p.newWebhookHandler(func(b []byte) error {
+		if !f.forward(b) {
+			return errWebhookForwardFailed
+		}
+		return nil
+	})
+	if code := serveTestWebhook(h, testAuditEvent(time.Now())); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, code)
+	}
+}
+
+func TestForwardShutdownGracePeriod(t *testing.T) {
+	// the target never responds, and the events are not consumed
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-unblock:
+		case <-req.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(unblock)
+	p := newTestPlugin(t, fmt.Sprintf(`{"forwardURL":%q,"shutdownGracePeriodMs":500}`, srv.URL))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err := p.startWebServer(addr, "/k8s-audit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postTestEvent(t, "http://"+addr+"/k8s-audit")
+
+	// draining the webhook and forwarding the payloads share the same deadline
+	start := time.Now()
+	producer.close()
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Fatalf("expected the shutdown to complete within the grace period, took %s", elapsed)
+	}
+}
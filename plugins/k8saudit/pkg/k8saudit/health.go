@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// webServerHealth tracks the state of the webhook server of an opened event
+// source, and serves it as HTTP probes. The server is live until it fails,
+// its event parser stops, or it gets closed, and ready once its listener is
+// bound and as long as it is live and its payload buffer is not full.
+type webServerHealth struct {
+	mu    sync.RWMutex
+	bound bool
+	err   error
+	// full, if not nil, returns true when the payloads received can't be
+	// buffered for now, in which case the server is not ready
+	full func() bool
+}
+
+// setBound marks the listener of the webhook server as bound
+func (h *webServerHealth) setBound() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bound = true
+}
+
+// setFailed marks the webhook server as not live anymore, keeping the first
+// reported error
+func (h *webServerHealth) setFailed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		h.err = err
+	}
+}
+
+// check returns nil if the webhook server is live, and also bound with a
+// payload buffer that is not full if readiness is true
+func (h *webServerHealth) check(readiness bool) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.err != nil {
+		return h.err
+	}
+	if readiness && !h.bound {
+		return fmt.Errorf("listener not bound yet")
+	}
+	if readiness && h.full != nil && h.full() {
+		return fmt.Errorf("payload buffer full")
+	}
+	return nil
+}
+
+// livenessHandler returns the handler of the liveness probe
+func (h *webServerHealth) livenessHandler() http.Handler {
+	return h.handler(false)
+}
+
+// readinessHandler returns the handler of the readiness probe
+func (h *webServerHealth) readinessHandler() http.Handler {
+	return h.handler(true)
+}
+
+func (h *webServerHealth) handler(readiness bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := h.check(readiness); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebServerHealth(t *testing.T) {
+	var health webServerHealth
+	probe := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec.Code
+	}
+	liveness, readiness := health.livenessHandler(), health.readinessHandler()
+
+	if probe(liveness) != http.StatusOK || probe(readiness) != http.StatusServiceUnavailable {
+		t.Fatal("expected a live server not ready before being bound")
+	}
+	health.setBound()
+	if probe(liveness) != http.StatusOK || probe(readiness) != http.StatusOK {
+		t.Fatal("expected a live and ready server once bound")
+	}
+	full := true
+	health.full = func() bool { return full }
+	if probe(liveness) != http.StatusOK || probe(readiness) != http.StatusServiceUnavailable {
+		t.Fatal("expected a live server not ready with a full buffer")
+	}
+	full = false
+	if probe(liveness) != http.StatusOK || probe(readiness) != http.StatusOK {
+		t.Fatal("expected a live and ready server once the buffer is not full")
+	}
+	health.setFailed(errors.New("listener failed"))
+	health.setFailed(errors.New("event source closed"))
+	if probe(liveness) != http.StatusServiceUnavailable || probe(readiness) != http.StatusServiceUnavailable {
+		t.Fatal("expected a failed server to be neither live nor ready")
+	}
+	rec := httptest.NewRecorder()
+	liveness.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if body := rec.Body.String(); body != "listener failed\n" {
+		t.Fatalf("unexpected liveness response: %q", body)
+	}
+}
+
+func TestWebServerHealthEndpoints(t *testing.T) {
+	p := newTestPlugin(t, `{"webhookHealthzPath":"/healthz","webhookReadyzPath":"/readyz"}`)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err := p.startWebServer(addr, "/k8s-audit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, path := range []string{"/readyz", "/healthz"} {
+		for {
+			res, err := http.Get(fmt.Sprintf("http://%s%s", addr, path))
+			if err == nil {
+				res.Body.Close()
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("unexpected status code for %s: %d", path, res.StatusCode)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestWebServerHealthBufferFull(t *testing.T) {
+	// note: a single payload larger than the maximum size fills the buffer
+	p := newTestPlugin(t, `{"webhookReadyzPath":"/readyz","maxBufferedBytes":1,"webhookEnqueueTimeoutMs":50}`)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err := p.startWebServer(addr, "/k8s-audit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.close()
+	waitReadiness := func(expected int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			res, err := http.Get(fmt.Sprintf("http://%s/readyz", addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode == expected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected readiness status code %d, got %d", expected, res.StatusCode)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// the first payload is received by the event parser, which then waits
+	// for its events to be consumed, while the second one fills the buffer
+	postTestEvent(t, "http://"+addr+"/k8s-audit")
+	waitReadiness(http.StatusOK)
+	postTestEvent(t, "http://"+addr+"/k8s-audit")
+	waitReadiness(http.StatusServiceUnavailable)
+
+	// the server is ready again once the events are consumed
+	go discardEvents(producer.events)
+	waitReadiness(http.StatusOK)
+}
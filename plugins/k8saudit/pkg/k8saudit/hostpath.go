@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"path"
+	"strings"
+)
+
+// isSensitiveHostPath returns true if mounting the given host path exposes
+// any of the given sensitive ones, which happens when it is either one of
+// them, a path below one of them, or a directory containing one of them
+// (e.g. /var/run exposes /var/run/docker.sock). All paths are expected to
+// be absolute.
+func isSensitiveHostPath(hostPath string, sensitivePaths []string) bool {
+	hostPath = path.Clean(hostPath)
+	for _, p := range sensitivePaths {
+		p = path.Clean(p)
+		if isPathWithin(hostPath, p) || isPathWithin(p, hostPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathWithin returns true if p is the same as dir or a path below it. Both
+// paths are expected to be clean.
+func isPathWithin(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
@@ -19,8 +19,13 @@ package k8saudit
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/alecthomas/jsonschema"
 	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk"
@@ -46,6 +51,15 @@ type Plugin struct {
 	jparser     fastjson.Parser
 	jdata       *fastjson.Value
 	jdataEvtnum uint64
+
+	clockSkewWarnOnce sync.Once
+	namespaces        atomic.Value // *namespaceCache, see SetNamespaceLookup
+	metrics           sourceMetrics
+	backpressure      backpressureWarnings
+	clientKindRules   []clientKindRule
+	fileSince         time.Duration
+	deadLetters       *deadLetterFile
+	debugSink         *debugSink
 }
 
 func (k *Plugin) Info() *plugins.Info {
@@ -67,6 +81,62 @@ func (k *Plugin) Init(cfg string) error {
 		return err
 	}
 
+	if k.Config.FileFormat != fileFormatJSONL && k.Config.FileFormat != fileFormatConcatenated {
+		return fmt.Errorf("invalid file format: %s", k.Config.FileFormat)
+	}
+
+	k.fileSince = 0
+	if len(k.Config.FileSince) > 0 {
+		k.fileSince, err = time.ParseDuration(k.Config.FileSince)
+		if err != nil || k.fileSince <= 0 {
+			return fmt.Errorf("invalid file since duration: %s", k.Config.FileSince)
+		}
+	}
+
+	if k.Config.FileSinceInvalidTimestamp != fileSinceInclude && k.Config.FileSinceInvalidTimestamp != fileSinceDrop {
+		return fmt.Errorf("invalid file since invalid timestamp policy: %s", k.Config.FileSinceInvalidTimestamp)
+	}
+
+	if k.Config.EventFormat != eventFormatRaw && k.Config.EventFormat != eventFormatCloudEvents {
+		return fmt.Errorf("invalid event format: %s", k.Config.EventFormat)
+	}
+
+	if k.Config.Parsing != parsingStrict && k.Config.Parsing != parsingLenient {
+		return fmt.Errorf("invalid parsing strictness: %s", k.Config.Parsing)
+	}
+
+	if k.Config.BufferFullPolicy != bufferFullBlock && k.Config.BufferFullPolicy != bufferFullDropOldest {
+		return fmt.Errorf("invalid buffer full policy: %s", k.Config.BufferFullPolicy)
+	}
+
+	for _, proto := range k.Config.WebhookTLSNextProtos {
+		if proto != tlsNextProtoHTTP2 && proto != tlsNextProtoHTTP1 {
+			return fmt.Errorf("invalid webhook TLS ALPN protocol: %s", proto)
+		}
+	}
+
+	if len(k.Config.ForwardURL) > 0 {
+		if err := validateForwardURL(k.Config.ForwardURL); err != nil {
+			return err
+		}
+	} else if k.Config.ForwardOnly {
+		return fmt.Errorf("invalid forward only: forwardURL is not set")
+	}
+
+	for _, p := range k.Config.SensitiveHostPaths {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("invalid sensitive host path, must be absolute: %s", p)
+		}
+	}
+
+	k.clientKindRules = newClientKindRules(k.Config.ClientKinds)
+	if len(k.Config.DeadLetterPath) > 0 {
+		k.deadLetters = newDeadLetterFile(k.Config.DeadLetterPath, k.Config.DeadLetterMaxSize)
+	}
+	if len(k.Config.DebugSink) > 0 {
+		k.debugSink = newDebugSink(k.Config.DebugSink, k.Config.DebugSinkMaxSize)
+	}
+
 	// setup optional async extraction optimization
 	extract.SetAsync(k.Config.UseAsync)
 
@@ -75,6 +145,16 @@ func (k *Plugin) Init(cfg string) error {
 	return nil
 }
 
+// Destroy closes the dead-letter and debug sink files
+func (k *Plugin) Destroy() {
+	if k.deadLetters != nil {
+		k.deadLetters.close()
+	}
+	if k.debugSink != nil {
+		k.debugSink.close()
+	}
+}
+
 func (p *Plugin) InitSchema() *sdk.SchemaInfo {
 	reflector := jsonschema.Reflector{
 		// all properties are optional by default
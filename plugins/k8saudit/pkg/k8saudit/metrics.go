@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// sourceMetrics contains the counters and gauges reported by the plugin's
+// event sources
+type sourceMetrics struct {
+	webhookRateLimited     uint64
+	webhookEmptyRequests   uint64
+	webhookEnqueueTimeouts uint64
+	webhookShutdownDropped uint64
+	eventPushBlocked       uint64
+	bufferDroppedPayloads  uint64
+	forwardedPayloads      uint64
+	forwardFailedPayloads  uint64
+
+	// bufferedBytes is a gauge, see payloadBuffer
+	bufferedBytes uint64
+}
+
+func (m *sourceMetrics) inc(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *sourceMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeCounter := func(name, help string, counter *uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, atomic.LoadUint64(counter))
+	}
+	writeGauge := func(name, help string, gauge *uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, atomic.LoadUint64(gauge))
+	}
+	writeCounter("k8saudit_webhook_rate_limited_requests_total", "Number of webhook requests rejected due to rate limiting.", &m.webhookRateLimited)
+	writeCounter("k8saudit_webhook_empty_requests_total", "Number of webhook requests with an empty body, acknowledged without being parsed.", &m.webhookEmptyRequests)
+	writeCounter("k8saudit_webhook_enqueue_timeouts_total", "Number of webhook requests rejected because their payload could not be enqueued within the enqueue timeout.", &m.webhookEnqueueTimeouts)
+	writeCounter("k8saudit_webhook_shutdown_dropped_payloads_total", "Number of webhook payloads dropped because they were received while the event source was closing.", &m.webhookShutdownDropped)
+	writeCounter("k8saudit_event_push_blocked_total", "Number of events whose push blocked for longer than the backpressure threshold.", &m.eventPushBlocked)
+	writeCounter("k8saudit_webhook_buffer_dropped_payloads_total", "Number of webhook payloads dropped from the full buffer with the dropOldest buffer full policy.", &m.bufferDroppedPayloads)
+	writeCounter("k8saudit_forwarded_payloads_total", "Number of webhook payloads forwarded to the forward URL.", &m.forwardedPayloads)
+	writeCounter("k8saudit_forward_failed_payloads_total", "Number of webhook payloads that could not be forwarded to the forward URL, including the ones dropped from the full forward queue.", &m.forwardFailedPayloads)
+	writeGauge("k8saudit_webhook_buffered_bytes", "Total size of the webhook payloads buffered while waiting to be parsed.", &m.bufferedBytes)
+}
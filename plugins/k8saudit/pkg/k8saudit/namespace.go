@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+const (
+	// annotationNamespaceLabels and annotationNamespaceAnnotations are the
+	// annotations set on each event pushed by an opened event source when a
+	// NamespaceLookup is configured, containing the JSON-encoded labels and
+	// annotations of the namespace targeted by the event
+	annotationNamespaceLabels      = "k8saudit.falco.org/namespace-labels"
+	annotationNamespaceAnnotations = "k8saudit.falco.org/namespace-annotations"
+)
+
+// NamespaceMetadata contains the metadata of a K8S namespace.
+type NamespaceMetadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// NamespaceLookup looks up the metadata of K8S namespaces, for example
+// by querying the K8S API server. A nil metadata and a nil error are returned
+// if the namespace does not exist.
+type NamespaceLookup interface {
+	LookupNamespace(name string) (*NamespaceMetadata, error)
+}
+
+// StaticNamespaceLookup is a NamespaceLookup that reads the metadata of the
+// namespaces from a static map, keyed by namespace name.
+type StaticNamespaceLookup map[string]NamespaceMetadata
+
+func (s StaticNamespaceLookup) LookupNamespace(name string) (*NamespaceMetadata, error) {
+	if m, ok := s[name]; ok {
+		return &m, nil
+	}
+	return nil, nil
+}
+
+const (
+	// namespaceLookupErrorTTL is the maximum time failed namespace lookups
+	// are cached, so that an unavailable K8S API server is not queried
+	// for every event
+	namespaceLookupErrorTTL = 5 * time.Second
+
+	// namespaceLookupErrorLogInterval is the minimum time between two logs
+	// of failed namespace lookups
+	namespaceLookupErrorLogInterval = time.Minute
+)
+
+type namespaceCacheEntry struct {
+	metadata *NamespaceMetadata
+	err      error
+	expiry   time.Time
+}
+
+// namespaceLookupCall is a lookup in progress, shared by all the callers
+// asking for the same namespace meanwhile
+type namespaceLookupCall struct {
+	done     chan struct{}
+	metadata *NamespaceMetadata
+	err      error
+}
+
+// namespaceCache caches the results of a NamespaceLookup for a given TTL,
+// so that the lookup is not performed for every event
+type namespaceCache struct {
+	lookup  NamespaceLookup
+	ttl     time.Duration
+	now     func() time.Time
+	errLog  *successLogSampler
+	mu      sync.Mutex
+	entries map[string]namespaceCacheEntry
+	calls   map[string]*namespaceLookupCall
+}
+
+func newNamespaceCache(lookup NamespaceLookup, ttl time.Duration) *namespaceCache {
+	return &namespaceCache{
+		lookup:  lookup,
+		ttl:     ttl,
+		now:     time.Now,
+		errLog:  newSuccessLogSampler(0, namespaceLookupErrorLogInterval),
+		entries: make(map[string]namespaceCacheEntry),
+		calls:   make(map[string]*namespaceLookupCall),
+	}
+}
+
+// get returns the metadata of a namespace, either from the cache or by
+// performing a lookup. Missing namespaces are cached too, and failed lookups
+// are cached for up to namespaceLookupErrorTTL. The lookup is performed
+// without holding the cache lock, and only once for concurrent callers
+// asking for the same namespace.
+func (c *namespaceCache) get(name string) (*NamespaceMetadata, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[name]; ok && c.now().Before(e.expiry) {
+		c.mu.Unlock()
+		return e.metadata, e.err
+	}
+	if call, ok := c.calls[name]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.metadata, call.err
+	}
+	call := &namespaceLookupCall{done: make(chan struct{})}
+	c.calls[name] = call
+	c.mu.Unlock()
+
+	call.metadata, call.err = c.lookup.LookupNamespace(name)
+
+	c.mu.Lock()
+	ttl := c.ttl
+	if call.err != nil && ttl > namespaceLookupErrorTTL {
+		ttl = namespaceLookupErrorTTL
+	}
+	c.entries[name] = namespaceCacheEntry{metadata: call.metadata, err: call.err, expiry: c.now().Add(ttl)}
+	delete(c.calls, name)
+	c.mu.Unlock()
+	close(call.done)
+	return call.metadata, call.err
+}
+
+// SetNamespaceLookup configures the event source to enrich each event with
+// the labels and annotations of its target namespace, which are then
+// available through the ka.target.namespace.label and
+// ka.target.namespace.annotation fields. Lookups are cached for the given
+// TTL. A nil lookup disables the enrichment. This can be called while event
+// sources are open, in which case their following events are affected.
+func (k *Plugin) SetNamespaceLookup(lookup NamespaceLookup, ttl time.Duration) {
+	var c *namespaceCache
+	if lookup != nil {
+		c = newNamespaceCache(lookup, ttl)
+	}
+	k.namespaces.Store(c)
+}
+
+// namespaceCache returns the cache of the configured NamespaceLookup, or nil
+// if none is configured, see SetNamespaceLookup
+func (k *Plugin) namespaceCache() *namespaceCache {
+	c, _ := k.namespaces.Load().(*namespaceCache)
+	return c
+}
+
+// annotateNamespaceMetadata attaches the metadata of the namespace
+// targeted by a single audit event, if any
+func (k *Plugin) annotateNamespaceMetadata(c *namespaceCache, value *fastjson.Value) {
+	name := string(value.GetStringBytes("objectRef", "namespace"))
+	if len(name) == 0 {
+		return
+	}
+	m, err := c.get(name)
+	if err != nil {
+		if ok, n := c.errLog.sample(); ok {
+			k.logger.Printf("can't lookup namespace %s: %s (%d failed lookup(s) since the last log)", name, err.Error(), n)
+		}
+		return
+	}
+	if m == nil {
+		return
+	}
+	if b, err := json.Marshal(m.Labels); err == nil {
+		setAuditEventAnnotation(value, annotationNamespaceLabels, string(b))
+	}
+	if b, err := json.Marshal(m.Annotations); err == nil {
+		setAuditEventAnnotation(value, annotationNamespaceAnnotations, string(b))
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+// lenientTimestampLayouts are the layouts tried in order when parsing
+// timestamps in lenient mode. Timestamps without a time zone are in UTC.
+var lenientTimestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseTimestamp parses a timestamp of an audit event, which must be in the
+// RFC 3339 format unless the parsing config is lenient, in which case the
+// lenientTimestampLayouts are tried in order.
+func (k *Plugin) parseTimestamp(v *fastjson.Value) (time.Time, error) {
+	str := string(v.GetStringBytes())
+	if k.Config.Parsing != parsingLenient {
+		return time.Parse(time.RFC3339Nano, str)
+	}
+	str = strings.TrimSpace(str)
+	for _, layout := range lenientTimestampLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse timestamp: %s", str)
+}
+
+// parseLenientUint64 parses an unsigned integer from a JSON number or
+// string, accepting surrounding spaces and the decimal and exponent
+// notations as long as the value is an integer (e.g. " 200 ", 200.0 or 2e2).
+func parseLenientUint64(v *fastjson.Value) (uint64, bool) {
+	var str string
+	switch v.Type() {
+	case fastjson.TypeNumber:
+		str = v.String()
+	case fastjson.TypeString:
+		str = strings.TrimSpace(string(v.GetStringBytes()))
+	default:
+		return 0, false
+	}
+	if res, err := strconv.ParseUint(str, 10, 64); err == nil {
+		return res, true
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil || f < 0 || f != math.Trunc(f) || f > math.MaxUint64 {
+		return 0, false
+	}
+	return uint64(f), true
+}
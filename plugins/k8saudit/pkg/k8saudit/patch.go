@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// patchOp is an operation of the patch of a patch request, modifying the
+// value at path. For move operations, from is the path of the removed value.
+// For the operations writing a value that is part of the patch, value is
+// the written value.
+type patchOp struct {
+	op    string
+	path  string
+	from  string
+	value *fastjson.Value
+}
+
+func (p patchOp) String() string {
+	return p.op + " " + p.path
+}
+
+// touches returns true if the operation modifies the value at the given
+// JSON pointer, which happens when it modifies either the value itself,
+// a value below it, or one containing it.
+func (p patchOp) touches(pointer string) bool {
+	paths := []string{p.path}
+	if p.op == "move" {
+		paths = append(paths, p.from)
+	}
+	for _, path := range paths {
+		if isPointerWithin(path, pointer) || isPointerWithin(pointer, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchOps returns the operations of a patch, given the requestObject of a
+// patch request. JSON patches (an array) are returned as they are. Merge
+// patches (an object), either JSON or strategic ones, are returned as a
+// merge operation on the path of each leaf value, and as a remove
+// operation on the path of each null value. Since the values of arrays of
+// merge patches are merged by key or replaced as a whole depending on the
+// patch type and on the object kind, arrays are considered leaf values, and
+// the directives of strategic merge patches (keys starting with $) are
+// ignored.
+func patchOps(patch *fastjson.Value) []patchOp {
+	var res []patchOp
+	switch patch.Type() {
+	case fastjson.TypeArray:
+		for _, v := range patch.GetArray() {
+			op := patchOp{
+				op:   string(v.GetStringBytes("op")),
+				path: string(v.GetStringBytes("path")),
+			}
+			if len(op.op) == 0 {
+				continue
+			}
+			switch op.op {
+			case "move":
+				op.from = string(v.GetStringBytes("from"))
+			case "add", "replace", "test":
+				op.value = v.Get("value")
+			}
+			res = append(res, op)
+		}
+	case fastjson.TypeObject:
+		mergePatchOps(patch, "", &res)
+		sort.Slice(res, func(i, j int) bool { return res[i].path < res[j].path })
+	}
+	return res
+}
+
+func mergePatchOps(v *fastjson.Value, path string, res *[]patchOp) {
+	visitPatchLeaves(v, path, false, func(path string, v *fastjson.Value) {
+		if v.Type() == fastjson.TypeNull {
+			*res = append(*res, patchOp{op: "remove", path: path})
+			return
+		}
+		*res = append(*res, patchOp{op: "merge", path: path, value: v})
+	})
+}
+
+// visitPatchLeaves calls visit with the JSON pointer of each leaf value
+// contained in v, with path being the pointer of v. Arrays are visited
+// element by element only if arrays is true, and are leaf values
+// otherwise. The directives of strategic merge patches (keys starting
+// with $) are skipped.
+func visitPatchLeaves(v *fastjson.Value, path string, arrays bool, visit func(string, *fastjson.Value)) {
+	switch {
+	case v.Type() == fastjson.TypeObject:
+		obj, _ := v.Object()
+		obj.Visit(func(key []byte, v *fastjson.Value) {
+			if strings.HasPrefix(string(key), "$") {
+				return
+			}
+			visitPatchLeaves(v, path+"/"+escapePointerToken(string(key)), arrays, visit)
+		})
+	case v.Type() == fastjson.TypeArray && arrays:
+		for i, v := range v.GetArray() {
+			visitPatchLeaves(v, path+"/"+strconv.Itoa(i), arrays, visit)
+		}
+	default:
+		visit(path, v)
+	}
+}
+
+// escapePointerToken escapes a key for use in a JSON pointer (RFC 6901)
+func escapePointerToken(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// isPointerWithin returns true if the JSON pointer p is the same as parent
+// or a pointer below it. The empty pointer refers to the whole document.
+func isPointerWithin(p, parent string) bool {
+	return p == parent || len(parent) == 0 || strings.HasPrefix(p, parent+"/")
+}
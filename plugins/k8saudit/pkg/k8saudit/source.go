@@ -19,16 +19,22 @@ package k8saudit
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-	"sort"
 
 	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk"
 	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk/plugins/source"
@@ -36,11 +42,108 @@ import (
 )
 
 const (
-	webServerShutdownTimeoutSecs = 5
-	webServerEventChanBufSize    = 50
+	// multiSourcePrefix is the prefix of the open params of an event source
+	// merging the events of multiple ones, see startMultiSource
+	multiSourcePrefix = "multi://"
+)
+
+const (
+	webServerEventChanBufSize = 50
+)
+
+const (
+	// annotationIngestLatency is the annotation set on each event pushed by
+	// an opened event source, reporting the time elapsed in milliseconds
+	// between the event's stageTimestamp and its ingestion by the plugin
+	annotationIngestLatency = "k8saudit.falco.org/ingest-latency-ms"
+	//
+	// annotationSourceFile and annotationSourceLine are the annotations set
+	// on each event read from a local file, reporting the path of the file
+	// and the line the event has been read from
+	annotationSourceFile = "k8saudit.falco.org/source-file"
+	annotationSourceLine = "k8saudit.falco.org/source-line"
+	//
+	// annotationIngestTime and annotationSourceType are the annotations set
+	// on each event read by the event source, reporting when the event has
+	// been ingested (in nanoseconds since epoch) and the type of the source
+	// it has been read from (see the sourceType constants)
+	annotationIngestTime = "k8saudit.falco.org/ingest-time"
+	annotationSourceType = "k8saudit.falco.org/source-type"
+	//
+	// annotationClusterName is the annotation from which the cluster name
+	// of an event is read, see the ka.cluster.name field
+	annotationClusterName = "cluster_name"
+)
+
+const (
+	// sourceTypeFile, sourceTypeHTTP, sourceTypeHTTPS and sourceTypeReader
+	// are the types of the event sources, reported by the ka.source.type
+	// field. The events of OpenReader have the reader type.
+	sourceTypeFile   = "file"
+	sourceTypeHTTP   = "http"
+	sourceTypeHTTPS  = "https"
+	sourceTypeReader = "reader"
 )
 
+// eventMetadata contains information about how an audit event has been
+// ingested, which gets attached to the event in the form of annotations
+type eventMetadata struct {
+	ingestTime time.Time
+	sourceType string
+	sourceFile string
+	sourceLine uint64
+}
+
+// errorString formats an ingestion error, prefixing it with the location
+// of the event in its source file, if any
+func (m *eventMetadata) errorString(err error) string {
+	if len(m.sourceFile) > 0 {
+		return fmt.Sprintf("%s:%d: %s", m.sourceFile, m.sourceLine, err.Error())
+	}
+	return err.Error()
+}
+
+// auditSource is a stream of K8S Audit Events, encoded as set by the
+// fileFormat config, optionally associated to the path of the file it is
+// read from
+type auditSource struct {
+	path   string
+	reader io.ReadCloser
+}
+
+// sourceType returns the type of the event source of an audit source,
+// which is a file one if it has been read from a path
+func (a *auditSource) sourceType() string {
+	if len(a.path) > 0 {
+		return sourceTypeFile
+	}
+	return sourceTypeReader
+}
+
 func (k *Plugin) Open(params string) (source.Instance, error) {
+	return k.OpenContext(context.Background(), params)
+}
+
+// OpenContext is the same as Open, but the opened event stream also ends
+// when ctx is done. In that case, the event source stops producing events,
+// and the ones not consumed yet are discarded.
+func (k *Plugin) OpenContext(ctx context.Context, params string) (source.Instance, error) {
+	var producer *eventProducer
+	var err error
+	if strings.HasPrefix(params, multiSourcePrefix) {
+		producer, err = k.startMultiSource(strings.TrimPrefix(params, multiSourcePrefix))
+	} else {
+		producer, err = k.startSource(params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k.newPushInstance(ctx, producer)
+}
+
+// startSource starts producing the events of the event source opened with
+// the given params, for all the supported schemes except multi://
+func (k *Plugin) startSource(params string) (*eventProducer, error) {
 	u, err := url.Parse(params)
 	if err != nil {
 		return nil, err
@@ -48,81 +151,382 @@ func (k *Plugin) Open(params string) (source.Instance, error) {
 
 	switch u.Scheme {
 	case "http":
-		return k.OpenWebServer(u.Host, u.Path, false)
+		return k.startWebServer(u.Host, u.Path, false)
 	case "https":
-		return k.OpenWebServer(u.Host, u.Path, true)
+		return k.startWebServer(u.Host, u.Path, true)
+	case "file":
+		return k.startLocalFile(u.Host + u.Path)
 	case "": // by default, fallback to opening a filepath
-		trimmed := strings.TrimSpace(params)
+		return k.startLocalFile(strings.TrimSpace(params))
+	}
+
+	return nil, fmt.Errorf(`scheme "%s" is not supported`, u.Scheme)
+}
+
+// eventProducer is a running producer of the events of an event source. The
+// events channel is closed when there are no more events to produce, which
+// may never happen before close is called (e.g. for webhooks). Once close
+// returns, all the goroutines of the producer are done, and the events that
+// were not consumed are discarded. Close returns within the shutdown grace
+// period, after which the goroutines still blocked are abandoned.
+type eventProducer struct {
+	events <-chan source.PushEvent
+	close  func()
+}
+
+// discardEvents receives and discards the events of a channel until it gets
+// closed, so that the goroutines sending them are not blocked once the
+// events are not consumed anymore
+func discardEvents(c <-chan source.PushEvent) {
+	for range c {
+	}
+}
+
+// newPushInstance opens a source.Instance event stream pushing the events
+// of the given producer, which is closed with the instance or when ctx is done
+func (k *Plugin) newPushInstance(ctx context.Context, producer *eventProducer) (source.Instance, error) {
+	var closeProducer, closeInstance sync.Once
+	instanceClosed := make(chan struct{})
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				closeProducer.Do(producer.close)
+			case <-instanceClosed:
+			}
+		}()
+	}
+	return source.NewPushInstance(
+		producer.events,
+		source.WithInstanceContext(ctx),
+		source.WithInstanceClose(func() {
+			closeInstance.Do(func() { close(instanceClosed) })
+			closeProducer.Do(producer.close)
+		}),
+		source.WithInstanceEventSize(uint32(k.Config.MaxEventSize)))
+}
 
-		fileInfo, err := os.Stat(trimmed)
+// startMultiSource starts producing the events of multiple event sources at
+// once, given the comma-separated list of their open params (e.g.
+// file:///var/log/old.json,http://:9765/k8s-audit). The events are pushed
+// as they are produced, regardless of the source they come from, until all
+// the sources are done.
+func (k *Plugin) startMultiSource(params string) (*eventProducer, error) {
+	var producers []*eventProducer
+	for _, p := range strings.Split(params, ",") {
+		p = strings.TrimSpace(p)
+		var err error
+		var producer *eventProducer
+		if len(p) == 0 || strings.HasPrefix(p, multiSourcePrefix) {
+			err = fmt.Errorf("invalid source in multi source params: %q", p)
+		} else {
+			producer, err = k.startSource(p)
+		}
 		if err != nil {
+			for _, producer := range producers {
+				producer.close()
+			}
 			return nil, err
 		}
-		if !fileInfo.IsDir() {
-			file, err := os.Open(trimmed)
-			if err != nil {
-				return nil, err
+		producers = append(producers, producer)
+	}
+	return mergeEventProducers(producers), nil
+}
+
+// mergeEventProducers returns a producer of all the events of the given
+// ones, whose events channel is closed once all of theirs are. Closing it
+// closes all the given producers.
+func mergeEventProducers(producers []*eventProducer) *eventProducer {
+	evtC := make(chan source.PushEvent)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, producer := range producers {
+		wg.Add(1)
+		// note: the events of a producer may never end if it gets
+		// abandoned on close, so done is also waited while receiving them
+		go func(events <-chan source.PushEvent) {
+			defer wg.Done()
+			for {
+				select {
+				case evt, ok := <-events:
+					if !ok {
+						return
+					}
+					select {
+					case evtC <- evt:
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
 			}
-			return k.OpenReader(file)
-		}
+		}(producer.events)
+	}
+	go func() {
+		wg.Wait()
+		close(evtC)
+	}()
+
+	var once sync.Once
+	return &eventProducer{
+		events: evtC,
+		close: func() {
+			once.Do(func() {
+				close(done)
+				for _, producer := range producers {
+					producer.close()
+				}
+				wg.Wait()
+			})
+		},
+	}
+}
 
-		files, err := ioutil.ReadDir(trimmed)
+// startLocalFile starts producing the K8S Audit Events read from a file on
+// the local filesystem. If the path is a directory, all the files it
+// contains are read sorted by their modification time.
+func (k *Plugin) startLocalFile(path string) (*eventProducer, error) {
+	srcs, err := openLocalSources(path)
+	if err != nil {
+		return nil, err
+	}
+	return k.startAuditSources(srcs), nil
+}
+
+// openLocalSources opens the files read by startLocalFile
+func openLocalSources(path string) ([]auditSource, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fileInfo.IsDir() {
+		file, err := openAuditFile(path)
 		if err != nil {
 			return nil, err
 		}
+		return []auditSource{{path: path, reader: file}}, nil
+	}
 
-		sort.Slice(files, func(i, j int) bool {
-			return files[i].ModTime().Before(files[j].ModTime())
-		})
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
 
-		// open all files as reader
-		results := []io.Reader{}
-		for _, f := range files {
-			if !f.IsDir() {
-				auditFile, err := os.Open(trimmed + "/" + f.Name())
-				if err != nil {
-					return nil, err
+	// open all files as reader
+	results := []auditSource{}
+	for _, f := range files {
+		if !f.IsDir() {
+			filePath := path + "/" + f.Name()
+			auditFile, err := openAuditFile(filePath)
+			if err != nil {
+				for _, r := range results {
+					r.reader.Close()
 				}
-				results = append(results, auditFile)
-				results = append(results, strings.NewReader("\n"))
+				return nil, err
 			}
+			results = append(results, auditSource{path: filePath, reader: auditFile})
 		}
-
-		// concat the readers and wrap with a no-op Close method
-		AllAuditFiles := io.NopCloser(io.MultiReader(results...))
-		return k.OpenReader(AllAuditFiles)
 	}
+	return results, nil
+}
 
-	return nil, fmt.Errorf(`scheme "%s" is not supported`, u.Scheme)
+// gzipFile is a io.ReadCloser reading the decompressed content of a
+// gzip-compressed file
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// openAuditFile opens a file containing K8S Audit Events. Files with the
+// .gz extension are decompressed transparently. Gzip files can be made of
+// multiple concatenated gzip members (e.g. when rotated logs get appended
+// to the same archive), in which case all the members are read.
+func openAuditFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("can't read gzip file %s: %s", path, err.Error())
+	}
+	reader.Multistream(true)
+	return &gzipFile{Reader: reader, file: file}, nil
 }
 
 // OpenReader opens a source.Instance event stream that reads K8S Audit
 // Events from a io.ReadCloser. Each Event is a JSON object encoded with
 // JSONL notation (see: https://jsonlines.org/).
 func (k *Plugin) OpenReader(r io.ReadCloser) (source.Instance, error) {
+	return k.newPushInstance(context.Background(), k.startAuditSources([]auditSource{{reader: r}}))
+}
+
+// startAuditSources starts producing the events read from multiple sources,
+// one after the other. The events read from a source with a non-empty path
+// are annotated with their file path and line number. Closing the producer
+// closes the sources, and waits for their pending reads to be interrupted
+// for at most the shutdown grace period.
+func (k *Plugin) startAuditSources(srcs []auditSource) *eventProducer {
 	evtC := make(chan source.PushEvent)
 
 	go func() {
 		defer close(evtC)
-		var parser fastjson.Parser
-		scanner := bufio.NewScanner(r)
-		scanner.Split(bufio.ScanLines)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if len(line) > 0 {
-				k.parseAuditEventsAndPush(&parser, ([]byte)(line), evtC)
+		k.readAuditSources(srcs, evtC)
+	}()
+
+	return &eventProducer{
+		events: evtC,
+		close: func() {
+			for _, src := range srcs {
+				src.reader.Close()
 			}
+			// note: closing a reader doesn't interrupt its pending reads
+			// for every io.ReadCloser (e.g. the ones of OpenReader), in
+			// which case the events keep being discarded in background
+			discarded := make(chan struct{})
+			go func() {
+				defer close(discarded)
+				discardEvents(evtC)
+			}()
+			timer := time.NewTimer(time.Duration(k.Config.ShutdownGracePeriodMs) * time.Millisecond)
+			defer timer.Stop()
+			select {
+			case <-discarded:
+			case <-timer.C:
+				k.logger.Println("event source still reading after the shutdown grace period, abandoning it")
+			}
+		},
+	}
+}
+
+func (k *Plugin) readAuditSources(srcs []auditSource, c chan<- source.PushEvent) {
+	var parser fastjson.Parser
+	for _, src := range srcs {
+		var err error
+		if k.Config.FileFormat == fileFormatConcatenated {
+			err = k.readConcatenatedSource(&parser, src, c)
+		} else {
+			err = k.readJSONLSource(&parser, src, c)
 		}
-		err := scanner.Err()
 		if err != nil {
-			evtC <- source.PushEvent{Err: err}
+			c <- source.PushEvent{Err: err}
+			return
 		}
-	}()
+	}
+}
 
-	return source.NewPushInstance(
-		evtC,
-		source.WithInstanceClose(func() { r.Close() }),
-		source.WithInstanceEventSize(uint32(k.Config.MaxEventSize)))
+// readJSONLSource reads a source containing one JSON payload per line.
+// Lines can end with either LF or CRLF, even mixed in the same source.
+func (k *Plugin) readJSONLSource(parser *fastjson.Parser, src auditSource, c chan<- source.PushEvent) error {
+	var lineNum uint64
+	scanner := bufio.NewScanner(src.reader)
+	scanner.Split(bufio.ScanLines)
+	for scanner.Scan() {
+		lineNum++
+		// note: bufio.ScanLines only drops a single CR before the LF, while
+		// some tools leave more of them, or trailing whitespace, and blank
+		// lines are skipped like empty ones
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if len(line) > 0 {
+			meta := &eventMetadata{
+				ingestTime: time.Now(),
+				sourceType: src.sourceType(),
+				sourceFile: src.path,
+				sourceLine: lineNum,
+			}
+			k.parseAuditEventsAndPush(parser, ([]byte)(line), meta, c)
+		}
+	}
+	return scanner.Err()
+}
+
+// readConcatenatedSource reads a source containing JSON payloads that are
+// concatenated one after the other, with or without whitespace in between
+// (e.g. `{...}{...}`). The source line of each event is the one where
+// its payload starts.
+func (k *Plugin) readConcatenatedSource(parser *fastjson.Parser, src auditSource, c chan<- source.PushEvent) error {
+	lines := &lineCounter{reader: src.reader}
+	decoder := json.NewDecoder(lines)
+	for {
+		var payload json.RawMessage
+		err := decoder.Decode(&payload)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		meta := &eventMetadata{
+			ingestTime: time.Now(),
+			sourceType: src.sourceType(),
+			sourceFile: src.path,
+			sourceLine: lines.lineAt(decoder.InputOffset() - int64(len(payload))),
+		}
+		k.parseAuditEventsAndPush(parser, payload, meta, c)
+	}
+}
+
+// lineCounter is an io.Reader that keeps track of the newlines read, so that
+// the line of an offset of the stream can be retrieved. Only the newlines
+// not yet passed by the requested offsets are retained, which must thus be
+// increasing.
+type lineCounter struct {
+	reader   io.Reader
+	read     int64
+	newlines []int64
+	line     uint64
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.newlines = append(l.newlines, l.read+int64(i))
+		}
+	}
+	l.read += int64(n)
+	return n, err
+}
+
+// lineAt returns the 1-based line of the given offset of the stream
+func (l *lineCounter) lineAt(offset int64) uint64 {
+	for len(l.newlines) > 0 && l.newlines[0] < offset {
+		l.newlines = l.newlines[1:]
+		l.line++
+	}
+	return l.line + 1
+}
+
+// tlsNextProtoHTTP2 and tlsNextProtoHTTP1 are the ALPN protocols supported
+// by the webhook server, see the webhookTLSNextProtos config
+const (
+	tlsNextProtoHTTP2 = "h2"
+	tlsNextProtoHTTP1 = "http/1.1"
+)
+
+// newWebServer returns an HTTP server with the configured webhook timeouts
+func (k *Plugin) newWebServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(k.Config.WebhookReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(k.Config.WebhookReadTimeout) * time.Second,
+		IdleTimeout:       time.Duration(k.Config.WebhookIdleTimeout) * time.Second,
+	}
 }
 
 // OpenWebServer opens a source.Instance event stream that receives K8S Audit
@@ -130,6 +534,15 @@ func (k *Plugin) OpenReader(r io.ReadCloser) (source.Instance, error) {
 // JSON format is the one of K8S API Server webhook backend
 // (see: https://kubernetes.io/docs/tasks/debug/debug-cluster/audit/#webhook-backend).
 func (k *Plugin) OpenWebServer(address, endpoint string, ssl bool) (source.Instance, error) {
+	producer, err := k.startWebServer(address, endpoint, ssl)
+	if err != nil {
+		return nil, err
+	}
+	return k.newPushInstance(context.Background(), producer)
+}
+
+// startWebServer starts producing the events received by OpenWebServer
+func (k *Plugin) startWebServer(address, endpoint string, ssl bool) (*eventProducer, error) {
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	serverEvtChan := make(chan []byte, webServerEventChanBufSize)
 	evtChan := make(chan source.PushEvent)
@@ -138,85 +551,231 @@ func (k *Plugin) OpenWebServer(address, endpoint string, ssl bool) (source.Insta
 	// the k8s api server and sends every valid payload to serverEvtChan so
 	// that an HTTP response can be sent as soon as possible. Each payload is
 	// then parsed to extract the list of audit events contained by the
-	// event-parser goroutine
-	m := http.NewServeMux()
-	s := &http.Server{Addr: address, Handler: m}
-	sendBody := func(b []byte) {
+	// event-parser goroutine. Payloads are forwarded once enqueued, or right
+	// away with the forwardOnly config, so that forwarding doesn't depend on
+	// how fast events are consumed
+	var fwd *forwarder
+	if len(k.Config.ForwardURL) > 0 {
+		var err error
+		if fwd, err = k.newForwarder(); err != nil {
+			cancelCtx()
+			return nil, err
+		}
+	}
+	buffer := newPayloadBuffer(serverEvtChan, k.Config.MaxBufferedBytes, &k.metrics)
+	// dropOnShutdown drops a payload received while closing the server
+	dropOnShutdown := func(b []byte) error {
+		k.metrics.inc(&k.metrics.webhookShutdownDropped)
+		k.logger.Println("request dropped while shutting down server ")
+		k.writeDeadLetter("request dropped while shutting down server", b)
+		return errWebhookShuttingDown
+	}
+	sendBody := func(b []byte) (err error) {
+		size := uint64(len(b))
+		reserved := false
 		defer func() {
+			// note: serverEvtChan is closed on shutdown
 			if r := recover(); r != nil {
-				k.logger.Println("request dropped while shutting down server ")
+				if reserved {
+					buffer.release(size)
+				}
+				err = dropOnShutdown(b)
 			}
 		}()
-		serverEvtChan <- b
-	}
-	m.HandleFunc(endpoint, func(w http.ResponseWriter, req *http.Request) {
-		if req.Method != "POST" {
-			http.Error(w, fmt.Sprintf("%s method not allowed", req.Method), http.StatusMethodNotAllowed)
-			return
+		if k.Config.ForwardOnly {
+			// note: the sender is expected to retry the rejected payloads
+			if !fwd.forward(b) {
+				k.metrics.inc(&k.metrics.forwardFailedPayloads)
+				return errWebhookForwardFailed
+			}
+			return nil
 		}
-		if !strings.Contains(req.Header.Get("Content-Type"), "application/json") {
-			http.Error(w, "wrong Content Type", http.StatusBadRequest)
-			return
+		var timeout <-chan time.Time
+		if k.Config.WebhookEnqueueTimeoutMs > 0 {
+			timer := time.NewTimer(time.Duration(k.Config.WebhookEnqueueTimeoutMs) * time.Millisecond)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		if k.Config.BufferFullPolicy == bufferFullDropOldest {
+			for _, old := range buffer.reserveDroppingOldest(size) {
+				k.metrics.inc(&k.metrics.bufferDroppedPayloads)
+				k.writeDeadLetter("payload dropped from the full buffer", old)
+			}
+		} else if !buffer.reserve(size, ctx.Done(), timeout) {
+			if ctx.Err() != nil {
+				return dropOnShutdown(b)
+			}
+			return errWebhookEnqueueTimeout
+		}
+		reserved = true
+		select {
+		case serverEvtChan <- b:
+			if fwd != nil && !fwd.forward(b) {
+				fwd.fail("payload could not be queued for forwarding", b)
+			}
+			return nil
+		case <-timeout:
+			buffer.release(size)
+			return errWebhookEnqueueTimeout
 		}
-		req.Body = http.MaxBytesReader(w, req.Body, int64(k.Config.WebhookMaxBatchSize))
-		bytes, err := ioutil.ReadAll(req.Body)
+	}
+	var health webServerHealth
+	if !k.Config.ForwardOnly && k.Config.BufferFullPolicy != bufferFullDropOldest {
+		// note: with the other policies, payloads are accepted even when
+		// the buffer is full
+		health.full = buffer.full
+	}
+	m, err := k.newWebServerMux(endpoint, sendBody, &health)
+	if err != nil {
+		cancelCtx()
+		return nil, err
+	}
+	s := k.newWebServer(address, m)
+	var certs *sniCertificates
+	if ssl {
+		certs, err = newSNICertificates(k.Config.SSLCertificate, k.Config.SSLCertificates, k.logger)
 		if err != nil {
-			msg := fmt.Sprintf("bad request: %s", err.Error())
-			k.logger.Println(msg)
-			http.Error(w, msg, http.StatusBadRequest)
-			return
+			cancelCtx()
+			return nil, err
 		}
-		w.WriteHeader(http.StatusOK)
-		sendBody(bytes)
-	})
-	go func() {
-		defer close(serverEvtChan)
-		var err error
-		if ssl {
-			// note: the legacy K8S Audit implementation concatenated the key and cert PEM
-			// files, however this seems to be unusual. Here we use the same concatenated files
-			// for both key and cert, but we may want to split them (this seems to work though).
-			err = s.ListenAndServeTLS(k.Config.SSLCertificate, k.Config.SSLCertificate)
-		} else {
-			err = s.ListenAndServe()
+		s.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		if len(k.Config.WebhookTLSNextProtos) > 0 {
+			http2 := false
+			for _, proto := range k.Config.WebhookTLSNextProtos {
+				s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, proto)
+				http2 = http2 || proto == tlsNextProtoHTTP2
+			}
+			if !http2 {
+				// a non-nil map prevents the server from enabling HTTP/2
+				s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+			}
 		}
+	}
+	// serverEvtChan is closed either when the server fails, or on close once
+	// the in-flight requests are done sending their payloads. The error of
+	// a failed server is then pushed by the event-parser goroutine, which is
+	// the only one sending to evtChan
+	var closeServerEvtChan sync.Once
+	var serveErr error
+	served := make(chan struct{})
+	if fwd != nil {
+		fwd.start()
+	}
+	go func() {
+		defer close(served)
+		err := k.listenAndServe(s, ssl, &health)
 		if err != nil && err != http.ErrServerClosed {
-			evtChan <- source.PushEvent{Err: err}
+			health.setFailed(err)
+			closeServerEvtChan.Do(func() {
+				serveErr = err
+				close(serverEvtChan)
+			})
 		}
 	}()
 
 	// launch event-parser gorountine. This received webhook payloads
 	// and parses their content to extract the list of audit events contained.
 	// Then, events are sent to the Push-mode event source instance channel.
+	drained := make(chan struct{})
+	sourceType := sourceTypeHTTP
+	if ssl {
+		sourceType = sourceTypeHTTPS
+	}
 	go func() {
+		defer close(drained)
 		defer close(evtChan)
+		defer health.setFailed(fmt.Errorf("event parser stopped"))
 		var parser fastjson.Parser
 		for {
 			select {
 			case bytes, ok := <-serverEvtChan:
 				if !ok {
+					if serveErr != nil {
+						evtChan <- source.PushEvent{Err: serveErr}
+					}
 					return
 				}
-				k.parseAuditEventsAndPush(&parser, bytes, evtChan)
+				buffer.release(uint64(len(bytes)))
+				k.parseAuditEventsAndPush(&parser, bytes, &eventMetadata{ingestTime: time.Now(), sourceType: sourceType}, evtChan)
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 
-	// open new instance in with "push" prebuilt
-	return source.NewPushInstance(
-		evtChan,
-		source.WithInstanceContext(ctx),
-		source.WithInstanceClose(func() {
-			// on close, attempt shutting down the webserver gracefully
-			timedCtx, cancelTimeoutCtx := context.WithTimeout(ctx, time.Second*webServerShutdownTimeoutSecs)
+	return &eventProducer{
+		events: evtChan,
+		close: func() {
+			// on close, drain the webserver gracefully: new connections are
+			// refused, while the in-flight requests can still send their
+			// payloads, which are all pushed before closing evtChan. Requests
+			// and payloads that can't be drained within the shutdown grace
+			// period are dropped. The same deadline applies to the whole
+			// shutdown, including forwarding the queued payloads
+			health.setFailed(fmt.Errorf("event source closed"))
+			deadline := time.Now().Add(time.Duration(k.Config.ShutdownGracePeriodMs) * time.Millisecond)
+			timedCtx, cancelTimeoutCtx := context.WithDeadline(ctx, deadline)
 			defer cancelTimeoutCtx()
 			s.Shutdown(timedCtx)
+			closeServerEvtChan.Do(func() { close(serverEvtChan) })
+			select {
+			case <-drained:
+			case <-timedCtx.Done():
+				// the events are not consumed anymore, and are discarded
+				// so that the event-parser goroutine can return, along with
+				// the payloads it didn't parse
+				cancelCtx()
+				events, payloads := 0, 0
+				for evt := range evtChan {
+					if evt.Err == nil {
+						events++
+					}
+				}
+				for b := range serverEvtChan {
+					payloads++
+					buffer.release(uint64(len(b)))
+					k.writeDeadLetter("payload dropped while shutting down server", b)
+				}
+				if events > 0 || payloads > 0 {
+					k.logger.Printf("shutdown grace period elapsed, dropped %d event(s) and %d payload(s) not consumed yet", events, payloads)
+				}
+			}
+			// note: the server returns as soon as Shutdown closes its listener
+			select {
+			case <-served:
+			case <-timedCtx.Done():
+			}
+			if fwd != nil {
+				fwd.close(time.Until(deadline))
+			}
+			if certs != nil {
+				certs.Close()
+			}
 			cancelCtx()
-		}),
-		source.WithInstanceEventSize(uint32(k.Config.MaxEventSize)),
-	)
+		},
+	}, nil
+}
+
+// listenAndServe is the same as s.ListenAndServe (or ListenAndServeTLS if
+// ssl is true), but reports when the listener is bound to health
+func (k *Plugin) listenAndServe(s *http.Server, ssl bool, health *webServerHealth) error {
+	addr := s.Addr
+	if len(addr) == 0 {
+		addr = ":http"
+		if ssl {
+			addr = ":https"
+		}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	health.setBound()
+	if ssl {
+		// the certificate is provided by the TLS config, see sniCertificates
+		return s.ServeTLS(l, "", "")
+	}
+	return s.Serve(l)
 }
 
 // todo: optimize this to cache by event number
@@ -231,23 +790,35 @@ func (k *Plugin) String(evt sdk.EventReader) (string, error) {
 // here we make all errors non-blocking for single events by
 // simply logging them, to ensure consumers don't close the
 // event source with bad or malicious payloads
-func (k *Plugin) parseAuditEventsAndPush(parser *fastjson.Parser, payload []byte, c chan<- source.PushEvent) {
+func (k *Plugin) parseAuditEventsAndPush(parser *fastjson.Parser, payload []byte, meta *eventMetadata, c chan<- source.PushEvent) {
+	k.writeDebugSink(payload)
 	data, err := parser.ParseBytes(payload)
 	if err != nil {
-		k.logger.Println(err.Error())
+		reason := meta.errorString(err)
+		k.logger.Println(reason)
+		k.writeDeadLetter(reason, payload)
 		return
 	}
-	values, err := k.ParseAuditEventsJSON(data)
+	values, err := k.parseAuditEventsJSON(data, meta)
 	if err != nil {
-		k.logger.Println(err.Error())
+		reason := meta.errorString(err)
+		k.logger.Println(reason)
+		k.writeDeadLetter(reason, payload)
 		return
 	}
+	deadLettered := false
 	for _, v := range values {
 		if v.Err != nil {
-			k.logger.Println(v.Err.Error())
+			reason := meta.errorString(v.Err)
+			k.logger.Println(reason)
+			// the whole payload is recorded only once, with the first error
+			if !deadLettered {
+				k.writeDeadLetter(reason, payload)
+				deadLettered = true
+			}
 			continue
 		} else {
-			c <- *v
+			k.pushEvent(c, *v)
 		}
 	}
 }
@@ -273,13 +844,19 @@ func (k *Plugin) ParseAuditEventsPayload(payload []byte) ([]*source.PushEvent, e
 // a pre-parsed JSON as input. The JSON representation is the one of the
 // fastjson library.
 func (k *Plugin) ParseAuditEventsJSON(value *fastjson.Value) ([]*source.PushEvent, error) {
+	return k.parseAuditEventsJSON(value, nil)
+}
+
+// parseAuditEventsJSON is the same as ParseAuditEventsJSON, but also attaches
+// the ingestion metadata to each of the parsed events, if non-nil.
+func (k *Plugin) parseAuditEventsJSON(value *fastjson.Value, meta *eventMetadata) ([]*source.PushEvent, error) {
 	if value == nil {
 		return nil, fmt.Errorf("can't parse nil JSON message")
 	}
 	if value.Type() == fastjson.TypeArray {
 		var res []*source.PushEvent
 		for _, v := range value.GetArray() {
-			values, err := k.ParseAuditEventsJSON(v)
+			values, err := k.parseAuditEventsJSON(v, meta)
 			if err != nil {
 				return res, err
 			}
@@ -293,29 +870,52 @@ func (k *Plugin) ParseAuditEventsJSON(value *fastjson.Value) ([]*source.PushEven
 			if items != nil {
 				var res []*source.PushEvent
 				for _, item := range items {
-					res = append(res, k.parseSingleAuditEventJSON(item))
+					if evt := k.parseSingleAuditEventJSON(item, meta); evt != nil {
+						res = append(res, evt)
+					}
 				}
 				return res, nil
 			}
 		case "Event":
-			return []*source.PushEvent{k.parseSingleAuditEventJSON(value)}, nil
+			if evt := k.parseSingleAuditEventJSON(value, meta); evt != nil {
+				return []*source.PushEvent{evt}, nil
+			}
+			return nil, nil
 		}
 	}
 	return nil, fmt.Errorf("data not recognized as a k8s audit event")
 }
 
-func (k *Plugin) parseSingleAuditEventJSON(value *fastjson.Value) *source.PushEvent {
+// parseSingleAuditEventJSON parses a single audit event, returning nil if
+// the event is skipped because of the fileSince config
+func (k *Plugin) parseSingleAuditEventJSON(value *fastjson.Value, meta *eventMetadata) *source.PushEvent {
 	res := &source.PushEvent{}
+	fileSince := k.fileSince > 0 && meta != nil && meta.sourceType == sourceTypeFile
 	stageTimestamp := value.Get("stageTimestamp")
 	if stageTimestamp == nil {
+		if fileSince && k.Config.FileSinceInvalidTimestamp == fileSinceDrop {
+			return nil
+		}
 		res.Err = fmt.Errorf("can't read stageTimestamp")
 		return res
 	}
-	timestamp, err := time.Parse(time.RFC3339Nano, string(stageTimestamp.GetStringBytes()))
+	timestamp, err := k.parseTimestamp(stageTimestamp)
 	if err != nil {
+		if fileSince && k.Config.FileSinceInvalidTimestamp == fileSinceDrop {
+			return nil
+		}
 		res.Err = err
 		return res
 	}
+	if fileSince && timestamp.Before(meta.ingestTime.Add(-k.fileSince)) {
+		return nil
+	}
+	if meta != nil {
+		k.annotateAuditEvent(value, timestamp, meta)
+	}
+	if k.Config.EventFormat == eventFormatCloudEvents {
+		value = wrapCloudEvent(value)
+	}
 	res.Data = value.MarshalTo(nil)
 	if len(res.Data) > int(k.Config.MaxEventSize) {
 		res.Err = fmt.Errorf("event larger than maxEventSize: size=%d", len(res.Data))
@@ -325,3 +925,40 @@ func (k *Plugin) parseSingleAuditEventJSON(value *fastjson.Value) *source.PushEv
 	res.Timestamp = timestamp
 	return res
 }
+
+// annotateAuditEvent attaches the ingestion metadata to a single audit event
+func (k *Plugin) annotateAuditEvent(value *fastjson.Value, timestamp time.Time, meta *eventMetadata) {
+	latency := meta.ingestTime.Sub(timestamp)
+	if latency < 0 {
+		// stageTimestamp is in the future, likely due to clock skew
+		k.clockSkewWarnOnce.Do(func() {
+			k.logger.Printf("found event with stageTimestamp in the future, ingestion latency will be reported as zero: stageTimestamp=%s", timestamp.Format(time.RFC3339Nano))
+		})
+		latency = 0
+	}
+	setAuditEventAnnotation(value, annotationIngestLatency, strconv.FormatInt(latency.Milliseconds(), 10))
+	setAuditEventAnnotation(value, annotationIngestTime, strconv.FormatInt(meta.ingestTime.UnixNano(), 10))
+	setAuditEventAnnotation(value, annotationSourceType, meta.sourceType)
+	if len(meta.sourceFile) > 0 {
+		setAuditEventAnnotation(value, annotationSourceFile, meta.sourceFile)
+		setAuditEventAnnotation(value, annotationSourceLine, strconv.FormatUint(meta.sourceLine, 10))
+	}
+	if len(k.Config.ClusterName) > 0 && value.Get("annotations", annotationClusterName) == nil {
+		setAuditEventAnnotation(value, annotationClusterName, k.Config.ClusterName)
+	}
+	if c := k.namespaceCache(); c != nil {
+		k.annotateNamespaceMetadata(c, value)
+	}
+}
+
+// setAuditEventAnnotation sets the value of an annotation of a single audit
+// event, creating the annotations object if not already present
+func setAuditEventAnnotation(value *fastjson.Value, key, val string) {
+	var arena fastjson.Arena
+	annotations := value.Get("annotations")
+	if annotations == nil || annotations.Type() != fastjson.TypeObject {
+		annotations = arena.NewObject()
+		value.Set("annotations", annotations)
+	}
+	annotations.Set(key, arena.NewString(val))
+}
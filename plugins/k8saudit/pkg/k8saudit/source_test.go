@@ -0,0 +1,1037 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk"
+	"github.com/falcosecurity/plugin-sdk-go/pkg/sdk/plugins/source"
+	"github.com/valyala/fastjson"
+)
+
+func newTestPlugin(t *testing.T, cfg string) *Plugin {
+	p := &Plugin{}
+	if err := p.Init(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testAuditEvent(stageTimestamp time.Time) string {
+	return fmt.Sprintf(`{"kind":"Event","apiVersion":"audit.k8s.io/v1","level":"Metadata","auditID":"4f5ba7e8-1a0e-4f2c-9a4a-1c9b4dd3a6f0","stage":"ResponseComplete","requestURI":"/api/v1/namespaces/default/pods","verb":"create","user":{"username":"admin"},"sourceIPs":["10.0.0.1"],"objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},"stageTimestamp":%q}`,
+		stageTimestamp.UTC().Format(time.RFC3339Nano))
+}
+
+// pushTestPayload pushes a payload through the same parsing path used by
+// the plugin's event sources, and returns the events produced
+func pushTestPayload(t *testing.T, p *Plugin, payload string) []source.PushEvent {
+	var parser fastjson.Parser
+	c := make(chan source.PushEvent, 64)
+	p.parseAuditEventsAndPush(&parser, []byte(payload), &eventMetadata{ingestTime: time.Now()}, c)
+	close(c)
+	var res []source.PushEvent
+	for evt := range c {
+		if evt.Err != nil {
+			t.Fatal(evt.Err)
+		}
+		res = append(res, evt)
+	}
+	return res
+}
+
+func TestIngestLatency(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+
+	evts := pushTestPayload(t, p, testAuditEvent(time.Now().Add(-2*time.Second)))
+	if len(evts) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evts))
+	}
+	latency, ok := extractTestField(t, p, "ka.ingest.latency_ms", "", string(evts[0].Data)).(uint64)
+	if !ok || latency < 2000 || latency > 60000 {
+		t.Fatalf("unexpected ingestion latency: %v", latency)
+	}
+
+	// stageTimestamp in the future gets clamped to zero
+	evts = pushTestPayload(t, p, testAuditEvent(time.Now().Add(time.Hour)))
+	latency, ok = extractTestField(t, p, "ka.ingest.latency_ms", "", string(evts[0].Data)).(uint64)
+	if !ok || latency != 0 {
+		t.Fatalf("expected zero ingestion latency, got %v", latency)
+	}
+
+	// events not read by an event source have no ingestion latency
+	if v := extractTestField(t, p, "ka.ingest.latency_ms", "", testAuditEvent(time.Now())); v != nil {
+		t.Fatalf("expected no ingestion latency, got %v", v)
+	}
+}
+
+func TestClusterName(t *testing.T) {
+	p := newTestPlugin(t, `{"clusterName":"prod-eu"}`)
+
+	evts := pushTestPayload(t, p, testAuditEvent(time.Now()))
+	if v := extractTestField(t, p, "ka.cluster.name", "", string(evts[0].Data)); v != "prod-eu" {
+		t.Fatalf("expected cluster name from config, got %v", v)
+	}
+
+	// events that already specify a cluster name are left untouched
+	evt := strings.Replace(testAuditEvent(time.Now()), `"level"`, `"annotations":{"cluster_name":"prod-us"},"level"`, 1)
+	evts = pushTestPayload(t, p, evt)
+	if v := extractTestField(t, p, "ka.cluster.name", "", string(evts[0].Data)); v != "prod-us" {
+		t.Fatalf("expected cluster name from event, got %v", v)
+	}
+
+	// no cluster name is attached by default
+	p = newTestPlugin(t, "{}")
+	evts = pushTestPayload(t, p, testAuditEvent(time.Now()))
+	if v := extractTestField(t, p, "ka.cluster.name", "", string(evts[0].Data)); v != nil {
+		t.Fatalf("expected no cluster name, got %v", v)
+	}
+}
+
+func TestCloudEventsFormat(t *testing.T) {
+	p := newTestPlugin(t, `{"clusterName":"prod-eu","eventFormat":"cloudevents"}`)
+
+	now := time.Now()
+	evt := strings.Replace(testAuditEvent(now), `"apiVersion":"v1"}`, `"apiVersion":"v1","name":"nginx","subresource":"exec"}`, 1)
+	evts := pushTestPayload(t, p, evt)
+	envelope, err := fastjson.ParseBytes(evts[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"specversion":     "1.0",
+		"id":              "4f5ba7e8-1a0e-4f2c-9a4a-1c9b4dd3a6f0-ResponseComplete",
+		"source":          "prod-eu",
+		"type":            "io.k8s.audit",
+		"subject":         "namespaces/default/pods/nginx/exec",
+		"time":            now.UTC().Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+	}
+	for key, value := range expected {
+		if v := string(envelope.GetStringBytes(key)); v != value {
+			t.Fatalf("expected %s=%q, got %q", key, value, v)
+		}
+	}
+	if v := string(envelope.GetStringBytes("data", "auditID")); v != "4f5ba7e8-1a0e-4f2c-9a4a-1c9b4dd3a6f0" {
+		t.Fatalf("expected the audit event as data, got auditID %q", v)
+	}
+
+	// fields are extracted from the wrapped audit event
+	if v := extractTestField(t, p, "ka.target.subresource", "", string(evts[0].Data)); v != "exec" {
+		t.Fatalf("expected subresource from wrapped event, got %v", v)
+	}
+	if v := extractTestField(t, p, "ka.cluster.name", "", string(evts[0].Data)); v != "prod-eu" {
+		t.Fatalf("expected cluster name from wrapped event, got %v", v)
+	}
+
+	// events with no cluster name and no resource
+	p = newTestPlugin(t, `{"eventFormat":"cloudevents"}`)
+	evt = strings.Replace(testAuditEvent(now), `"objectRef":{"resource":"pods","namespace":"default","apiVersion":"v1"},`, "", 1)
+	evts = pushTestPayload(t, p, evt)
+	envelope, err = fastjson.ParseBytes(evts[0].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(envelope.GetStringBytes("source")); v != "k8saudit" {
+		t.Fatalf("expected default source, got %q", v)
+	}
+	if envelope.Get("subject") != nil {
+		t.Fatalf("expected no subject, got %s", envelope.Get("subject"))
+	}
+
+	// events are pushed as they are received by default
+	p = newTestPlugin(t, "{}")
+	evts = pushTestPayload(t, p, testAuditEvent(now))
+	if v, err := fastjson.ParseBytes(evts[0].Data); err != nil || v.Get("specversion") != nil {
+		t.Fatalf("expected a raw audit event, got %s", evts[0].Data)
+	}
+
+	if err := (&Plugin{}).Init(`{"eventFormat":"xml"}`); err == nil {
+		t.Fatal("expected an error for an unknown event format")
+	}
+}
+
+func TestOpenAuditFileMultistreamGzip(t *testing.T) {
+	// build a file made of two concatenated gzip members
+	var buf bytes.Buffer
+	lines := []string{testAuditEvent(time.Now()), testAuditEvent(time.Now())}
+	for _, line := range lines {
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "audit.log.gz")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openAuditFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strings.Join(lines, "\n")+"\n" {
+		t.Fatalf("unexpected decompressed content: %s", string(data))
+	}
+}
+
+type countingNamespaceLookup struct {
+	StaticNamespaceLookup
+	calls int
+}
+
+func (c *countingNamespaceLookup) LookupNamespace(name string) (*NamespaceMetadata, error) {
+	c.calls++
+	return c.StaticNamespaceLookup.LookupNamespace(name)
+}
+
+func TestNamespaceLookup(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	lookup := &countingNamespaceLookup{StaticNamespaceLookup: StaticNamespaceLookup{
+		"default": {
+			Labels:      map[string]string{"pod-security.kubernetes.io/enforce": "privileged"},
+			Annotations: map[string]string{"owner": "team-a"},
+		},
+	}}
+	p.SetNamespaceLookup(lookup, time.Minute)
+	now := time.Now()
+	p.namespaceCache().now = func() time.Time { return now }
+
+	evts := pushTestPayload(t, p, testAuditEvent(now))
+	if v := extractTestField(t, p, "ka.target.namespace.label", "pod-security.kubernetes.io/enforce", string(evts[0].Data)); v != "privileged" {
+		t.Fatalf("unexpected namespace label: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.target.namespace.annotation", "owner", string(evts[0].Data)); v != "team-a" {
+		t.Fatalf("unexpected namespace annotation: %v", v)
+	}
+	if v := extractTestField(t, p, "ka.target.namespace.label", "missing", string(evts[0].Data)); v != nil {
+		t.Fatalf("expected no namespace label, got %v", v)
+	}
+
+	// lookups are cached until the TTL expires
+	pushTestPayload(t, p, testAuditEvent(now))
+	if lookup.calls != 1 {
+		t.Fatalf("expected 1 lookup, got %d", lookup.calls)
+	}
+	now = now.Add(2 * time.Minute)
+	pushTestPayload(t, p, testAuditEvent(now))
+	if lookup.calls != 2 {
+		t.Fatalf("expected 2 lookups, got %d", lookup.calls)
+	}
+
+	// unknown namespaces are not enriched
+	evt := strings.Replace(testAuditEvent(now), `"namespace":"default"`, `"namespace":"other"`, 1)
+	evts = pushTestPayload(t, p, evt)
+	if v := extractTestField(t, p, "ka.target.namespace.label", "pod-security.kubernetes.io/enforce", string(evts[0].Data)); v != nil {
+		t.Fatalf("expected no namespace label, got %v", v)
+	}
+}
+
+// failingNamespaceLookup fails the lookups of the default namespace, after
+// waiting for unblock to be closed if not nil, and finds the other ones
+type failingNamespaceLookup struct {
+	mu      sync.Mutex
+	calls   int
+	unblock chan struct{}
+}
+
+func (f *failingNamespaceLookup) LookupNamespace(name string) (*NamespaceMetadata, error) {
+	if name != "default" {
+		return &NamespaceMetadata{}, nil
+	}
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	if f.unblock != nil {
+		<-f.unblock
+	}
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestNamespaceLookupFailure(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	lookup := &failingNamespaceLookup{}
+	p.SetNamespaceLookup(lookup, time.Minute)
+	now := time.Now()
+	p.namespaceCache().now = func() time.Time { return now }
+
+	// failed lookups are cached for a shorter time than the TTL
+	for i := 0; i < 10; i++ {
+		evts := pushTestPayload(t, p, testAuditEvent(now))
+		if v := extractTestField(t, p, "ka.target.namespace.label", "owner", string(evts[0].Data)); v != nil {
+			t.Fatalf("expected no namespace label, got %v", v)
+		}
+	}
+	if lookup.calls != 1 {
+		t.Fatalf("expected 1 lookup, got %d", lookup.calls)
+	}
+	now = now.Add(namespaceLookupErrorTTL)
+	pushTestPayload(t, p, testAuditEvent(now))
+	if lookup.calls != 2 {
+		t.Fatalf("expected 2 lookups, got %d", lookup.calls)
+	}
+
+	// concurrent callers share the same lookup, which doesn't block the
+	// lookups of the other namespaces
+	lookup = &failingNamespaceLookup{unblock: make(chan struct{})}
+	p.SetNamespaceLookup(lookup, time.Minute)
+	c := p.namespaceCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.get("default"); err == nil {
+				t.Error("expected the lookup to fail")
+			}
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := len(c.calls)
+		c.mu.Unlock()
+		if pending > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the lookup to start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if m, err := c.get("other"); err != nil || m == nil {
+		t.Fatalf("expected the other namespace to be found, got %v", err)
+	}
+	close(lookup.unblock)
+	wg.Wait()
+	if lookup.calls != 1 {
+		t.Fatalf("expected 1 lookup, got %d", lookup.calls)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.log")
+	p := newTestPlugin(t, fmt.Sprintf(`{"deadLetterPath":%q,"deadLetterMaxSize":200}`, path))
+
+	// valid payloads are not recorded
+	if evts := pushTestPayload(t, p, testAuditEvent(time.Now())); len(evts) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evts))
+	}
+	if _, err := ioutil.ReadFile(path); err == nil {
+		t.Fatal("expected no dead-letter file for a valid payload")
+	}
+
+	badPayload := `{"kind":"Event","auditID":`
+	if evts := pushTestPayload(t, p, badPayload); len(evts) != 0 {
+		t.Fatalf("expected no events, got %d", len(evts))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "--- ") || !strings.Contains(lines[0], "cannot parse JSON") || lines[1] != badPayload {
+		t.Fatalf("unexpected dead-letter file content: %s", string(data))
+	}
+
+	// the file is rotated when exceeding the maximum size
+	pushTestPayload(t, p, badPayload)
+	pushTestPayload(t, p, badPayload)
+	if _, err := ioutil.ReadFile(path + ".1"); err != nil {
+		t.Fatalf("expected a rotated dead-letter file: %s", err.Error())
+	}
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 200 {
+		t.Fatalf("expected the dead-letter file to be capped, got %d bytes", len(data))
+	}
+}
+
+func TestSourceLine(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+
+	// blank lines are skipped but still counted
+	dir := t.TempDir()
+	content := testAuditEvent(time.Now()) + "\n\n" + testAuditEvent(time.Now()) + "\n"
+	path := filepath.Join(dir, "audit.log")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srcs, err := openLocalSources(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan source.PushEvent, 64)
+	p.readAuditSources(srcs, c)
+	close(c)
+	var lines []uint64
+	for evt := range c {
+		if evt.Err != nil {
+			t.Fatal(evt.Err)
+		}
+		if v := extractTestField(t, p, "ka.source.file", "", string(evt.Data)); v != path {
+			t.Fatalf("unexpected source file: %v", v)
+		}
+		line, ok := extractTestField(t, p, "ka.source.line", "", string(evt.Data)).(uint64)
+		if !ok {
+			t.Fatal("expected a source line")
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0] != 1 || lines[1] != 3 {
+		t.Fatalf("unexpected source lines: %v", lines)
+	}
+
+	// events not read from a file have no source location
+	evts := pushTestPayload(t, p, testAuditEvent(time.Now()))
+	if v := extractTestField(t, p, "ka.source.line", "", string(evts[0].Data)); v != nil {
+		t.Fatalf("expected no source line, got %v", v)
+	}
+}
+
+func TestConcatenatedFileFormat(t *testing.T) {
+	if err := (&Plugin{}).Init(`{"fileFormat":"yaml"}`); err == nil {
+		t.Fatal("expected an error for an invalid file format")
+	}
+
+	p := newTestPlugin(t, `{"fileFormat":"concatenated"}`)
+	path := filepath.Join("testdata", "concatenated.json")
+	srcs, err := openLocalSources(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan source.PushEvent, 64)
+	p.readAuditSources(srcs, c)
+	close(c)
+	var verbs []string
+	var lines []uint64
+	for evt := range c {
+		if evt.Err != nil {
+			t.Fatal(evt.Err)
+		}
+		verbs = append(verbs, extractTestField(t, p, "ka.verb", "", string(evt.Data)).(string))
+		lines = append(lines, extractTestField(t, p, "ka.source.line", "", string(evt.Data)).(uint64))
+	}
+	if !reflect.DeepEqual(verbs, []string{"create", "get", "delete"}) {
+		t.Fatalf("unexpected events: %v", verbs)
+	}
+	if !reflect.DeepEqual(lines, []uint64{1, 1, 2}) {
+		t.Fatalf("unexpected source lines: %v", lines)
+	}
+
+	// a truncated object is reported as an error
+	srcs = []auditSource{{reader: ioutil.NopCloser(strings.NewReader(testAuditEvent(time.Now()) + `{"kind":`))}}
+	c = make(chan source.PushEvent, 64)
+	p.readAuditSources(srcs, c)
+	close(c)
+	if evt := <-c; evt.Err != nil {
+		t.Fatal(evt.Err)
+	}
+	if evt := <-c; evt.Err == nil {
+		t.Fatal("expected an error for a truncated object")
+	}
+}
+
+func TestJSONLFileLineEndings(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	srcs, err := openLocalSources(filepath.Join("testdata", "crlf.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan source.PushEvent, 64)
+	p.readAuditSources(srcs, c)
+	close(c)
+	var verbs []string
+	var lines []uint64
+	for evt := range c {
+		if evt.Err != nil {
+			t.Fatal(evt.Err)
+		}
+		if bytes.ContainsRune(evt.Data, '\r') {
+			t.Fatalf("unexpected carriage return in event: %q", evt.Data)
+		}
+		verbs = append(verbs, extractTestField(t, p, "ka.verb", "", string(evt.Data)).(string))
+		lines = append(lines, extractTestField(t, p, "ka.source.line", "", string(evt.Data)).(uint64))
+	}
+	if !reflect.DeepEqual(verbs, []string{"create", "get", "delete", "update"}) {
+		t.Fatalf("unexpected events: %v", verbs)
+	}
+	if !reflect.DeepEqual(lines, []uint64{1, 3, 4, 5}) {
+		t.Fatalf("unexpected source lines: %v", lines)
+	}
+}
+
+// receiveTestEvents receives n events from a producer, failing the test if
+// they are not produced in a reasonable time
+func receiveTestEvents(t *testing.T, producer *eventProducer, n int) []source.PushEvent {
+	var evts []source.PushEvent
+	for len(evts) < n {
+		select {
+		case evt, ok := <-producer.events:
+			if !ok {
+				t.Fatalf("expected %d events, got %d", n, len(evts))
+			}
+			if evt.Err != nil {
+				t.Fatal(evt.Err)
+			}
+			evts = append(evts, evt)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %d of %d", len(evts), n)
+		}
+	}
+	return evts
+}
+
+// postTestEvent sends an audit event to a webhook, retrying until the web
+// server is listening
+func postTestEvent(t *testing.T, url string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := http.Post(url, "application/json", strings.NewReader(testAuditEvent(time.Now())))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("unexpected webhook status: %d", res.StatusCode)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIngestTimeAndSourceType(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	path := filepath.Join(t.TempDir(), "audit.json")
+	if err := ioutil.WriteFile(path, []byte(testAuditEvent(time.Now())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	for params, expected := range map[string]string{
+		path:                            sourceTypeFile,
+		"file://" + path:                sourceTypeFile,
+		"http://" + addr + "/k8s-audit": sourceTypeHTTP,
+	} {
+		before := time.Now()
+		producer, err := p.startSource(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected == sourceTypeHTTP {
+			postTestEvent(t, params)
+		}
+		evt := receiveTestEvents(t, producer, 1)[0]
+		producer.close()
+		if v := extractTestField(t, p, "ka.source.type", "", string(evt.Data)); v != expected {
+			t.Fatalf("unexpected source type for %q: %v", params, v)
+		}
+		ingestTime, ok := extractTestField(t, p, "ka.ingest.time", "", string(evt.Data)).(uint64)
+		if !ok || ingestTime < uint64(before.UnixNano()) || ingestTime > uint64(time.Now().UnixNano()) {
+			t.Fatalf("unexpected ingestion time for %q: %v", params, ingestTime)
+		}
+	}
+
+	producer := p.startAuditSources([]auditSource{{reader: ioutil.NopCloser(strings.NewReader(testAuditEvent(time.Now())))}})
+	evt := receiveTestEvents(t, producer, 1)[0]
+	producer.close()
+	if v := extractTestField(t, p, "ka.source.type", "", string(evt.Data)); v != sourceTypeReader {
+		t.Fatalf("unexpected source type: %v", v)
+	}
+
+	// events not read by an event source have no source type
+	if v := extractTestField(t, p, "ka.source.type", "", testAuditEvent(time.Now())); v != nil {
+		t.Fatalf("expected no source type, got %v", v)
+	}
+}
+
+func TestMultiSource(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.json")
+	content := testAuditEvent(time.Now()) + "\n" + testAuditEvent(time.Now()) + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// finite sources only
+	producer, err := p.startMultiSource("file://" + path + "," + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveTestEvents(t, producer, 4)
+	if _, ok := <-producer.events; ok {
+		t.Fatal("expected the events to end with the files")
+	}
+	producer.close()
+
+	// a file and a webhook
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err = p.startMultiSource("file://" + path + ",http://" + addr + "/k8s-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.close()
+	for _, evt := range receiveTestEvents(t, producer, 2) {
+		if v := extractTestField(t, p, "ka.source.file", "", string(evt.Data)); v != path {
+			t.Fatalf("unexpected source file: %v", v)
+		}
+	}
+	postTestEvent(t, "http://"+addr+"/k8s-audit")
+	evt := receiveTestEvents(t, producer, 1)[0]
+	if v := extractTestField(t, p, "ka.source.file", "", string(evt.Data)); v != nil {
+		t.Fatalf("expected no source file, got %v", v)
+	}
+
+	// the webhook keeps the events open until closed
+	select {
+	case <-producer.events:
+		t.Fatal("unexpected event")
+	case <-time.After(50 * time.Millisecond):
+	}
+	producer.close()
+	select {
+	case _, ok := <-producer.events:
+		if ok {
+			t.Fatal("unexpected event after closing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the events to end after closing")
+	}
+
+	// invalid sources
+	for _, params := range []string{"multi://" + path + ",ftp://host/file", "multi://" + path + ",", "multi://multi://" + path} {
+		if _, err := p.Open(params); err == nil {
+			t.Errorf("expected an error for %s", params)
+		}
+	}
+}
+
+func TestDebugSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.jsonl")
+	p := newTestPlugin(t, fmt.Sprintf(`{"debugSink":%q}`, path))
+	defer p.Destroy()
+
+	// payloads are recorded as received, even if they can't be parsed
+	payloads := []string{
+		fmt.Sprintf("[%s,\n%s]", testAuditEvent(time.Now()), testAuditEvent(time.Now())),
+		`{"kind":"Event","auditID":`,
+	}
+	if evts := pushTestPayload(t, p, payloads[0]); len(evts) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(evts))
+	}
+	pushTestPayload(t, p, payloads[1])
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := payloads[0] + "\n" + payloads[1] + "\n"; string(data) != expected {
+		t.Fatalf("unexpected debug sink content: %s", string(data))
+	}
+
+	// the file is rotated once it would exceed the maximum size
+	p = newTestPlugin(t, fmt.Sprintf(`{"debugSink":%q,"debugSinkMaxSize":%d}`, path, len(payloads[1])+1))
+	defer p.Destroy()
+	pushTestPayload(t, p, payloads[1])
+	if data, err = ioutil.ReadFile(path); err != nil || string(data) != payloads[1]+"\n" {
+		t.Fatalf("unexpected debug sink content after rotation: %s", string(data))
+	}
+	if data, err = ioutil.ReadFile(path + ".1"); err != nil || !strings.HasPrefix(string(data), payloads[0]) {
+		t.Fatalf("unexpected rotated debug sink content: %s", string(data))
+	}
+
+	// writing to stderr
+	var buf bytes.Buffer
+	p = newTestPlugin(t, `{"debugSink":"stderr"}`)
+	p.debugSink.w = &buf
+	event := testAuditEvent(time.Now())
+	pushTestPayload(t, p, event)
+	if buf.String() != event+"\n" {
+		t.Fatalf("unexpected debug sink content: %s", buf.String())
+	}
+
+	// disabled by default
+	if p = newTestPlugin(t, "{}"); p.debugSink != nil {
+		t.Fatal("expected no debug sink by default")
+	}
+}
+
+// checkGoroutinesDone fails the test if the number of running goroutines
+// doesn't get back to the given one within a few seconds
+func checkGoroutinesDone(t *testing.T, expected int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > expected {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("leaked goroutines: %d running, expected %d\n%s", runtime.NumGoroutine(), expected, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenCloseGoroutineLeaks(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	path := filepath.Join(t.TempDir(), "audit.json")
+	var lines []string
+	for i := 0; i < 10000; i++ {
+		lines = append(lines, testAuditEvent(time.Now()))
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	webhook := "http://" + addr + "/k8s-audit"
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		for _, params := range []string{path, webhook, "multi://" + path + "," + webhook} {
+			// the events of the file are only partially consumed
+			inst, err := p.Open(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := inst.NextBatch(nil, inst.Events()); err != nil && err != sdk.ErrTimeout && err != sdk.ErrEOF {
+				t.Fatal(err)
+			}
+			inst.(sdk.Closer).Close()
+			checkGoroutinesDone(t, before)
+		}
+	}
+
+	// the event source ends once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	inst, err := p.OpenContext(ctx, "multi://"+path+","+webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inst.NextBatch(nil, inst.Events()); err != nil && err != sdk.ErrTimeout && err != sdk.ErrEOF {
+		t.Fatal(err)
+	}
+	cancel()
+	checkGoroutinesDone(t, before)
+	for {
+		_, err := inst.NextBatch(nil, inst.Events())
+		if err == sdk.ErrEOF {
+			break
+		}
+		if err != nil && err != sdk.ErrTimeout {
+			t.Fatal(err)
+		}
+	}
+	inst.(sdk.Closer).Close()
+	checkGoroutinesDone(t, before)
+}
+
+// blockingReader is an io.ReadCloser whose reads block until it's unblocked,
+// even once closed
+type blockingReader struct {
+	unblock chan struct{}
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	<-b.unblock
+	return 0, io.EOF
+}
+
+func (b *blockingReader) Close() error {
+	return nil
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	p := newTestPlugin(t, `{"shutdownGracePeriodMs":200}`)
+	var logs bytes.Buffer
+	p.logger = log.New(&logs, "", 0)
+
+	// the events received by the webhook are not consumed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err := p.startWebServer(addr, "/k8s-audit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		postTestEvent(t, "http://"+addr+"/k8s-audit")
+	}
+	start := time.Now()
+	producer.close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the shutdown to complete within the grace period, took %s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "shutdown grace period elapsed") {
+		t.Fatalf("expected a dropped events warning, got %q", logs.String())
+	}
+
+	// the reads of the reader can't be interrupted
+	r := &blockingReader{unblock: make(chan struct{})}
+	defer close(r.unblock)
+	producer = p.startAuditSources([]auditSource{{reader: r}})
+	start = time.Now()
+	producer.close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the shutdown to complete within the grace period, took %s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "abandoning it") {
+		t.Fatalf("expected an abandoned source warning, got %q", logs.String())
+	}
+
+	// abandoned sources don't block the shutdown of multi sources either
+	producer = mergeEventProducers([]*eventProducer{p.startAuditSources([]auditSource{{reader: r}})})
+	start = time.Now()
+	producer.close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the shutdown to complete within the grace period, took %s", elapsed)
+	}
+}
+
+func TestWebhookTLSNextProtos(t *testing.T) {
+	cert := filepath.Join(t.TempDir(), "cert.pem")
+	writeTestCertificate(t, cert, 1)
+
+	// negotiatedProtocol returns the ALPN protocol negotiated by a client
+	// preferring HTTP/2 with a webhook server started with the given config
+	negotiatedProtocol := func(cfg string) string {
+		p := newTestPlugin(t, cfg)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		producer, err := p.startWebServer(addr, "/k8s-audit", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer producer.close()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+			if err == nil {
+				defer conn.Close()
+				return conn.ConnectionState().NegotiatedProtocol
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if proto := negotiatedProtocol(fmt.Sprintf(`{"sslCertificate":%q}`, cert)); proto != "h2" {
+		t.Errorf("expected h2 by default, got %q", proto)
+	}
+	if proto := negotiatedProtocol(fmt.Sprintf(`{"sslCertificate":%q,"webhookTLSNextProtos":["http/1.1"]}`, cert)); proto != "http/1.1" {
+		t.Errorf("expected http/1.1, got %q", proto)
+	}
+	if proto := negotiatedProtocol(fmt.Sprintf(`{"sslCertificate":%q,"webhookTLSNextProtos":["h2"]}`, cert)); proto != "h2" {
+		t.Errorf("expected h2, got %q", proto)
+	}
+
+	if err := (&Plugin{}).Init(`{"webhookTLSNextProtos":["spdy/3"]}`); err == nil {
+		t.Error("expected an error for an unsupported ALPN protocol")
+	}
+}
+
+func TestFileSince(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.json")
+	now := time.Now()
+	invalid := strings.Replace(testAuditEvent(now), now.UTC().Format(time.RFC3339Nano), "yesterday", 1)
+	content := strings.Join([]string{
+		testAuditEvent(now.Add(-3 * time.Hour)),
+		fmt.Sprintf(`{"kind":"EventList","items":[%s,%s]}`, testAuditEvent(now.Add(-90*time.Minute)), testAuditEvent(now.Add(-10*time.Minute))),
+		testAuditEvent(now.Add(-30 * time.Minute)),
+		invalid,
+		testAuditEvent(now),
+	}, "\n")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// readAllTimestamps returns the timestamps of all the events read from
+	// an event producer
+	readAllTimestamps := func(producer *eventProducer) []time.Time {
+		defer producer.close()
+		var res []time.Time
+		for evt := range producer.events {
+			if evt.Err != nil {
+				t.Fatal(evt.Err)
+			}
+			res = append(res, evt.Timestamp)
+		}
+		return res
+	}
+
+	for _, policy := range []string{fileSinceInclude, fileSinceDrop} {
+		deadLetters := filepath.Join(dir, policy+".log")
+		p := newTestPlugin(t, fmt.Sprintf(`{"fileSince":"1h","fileSinceInvalidTimestamp":%q,"deadLetterPath":%q}`, policy, deadLetters))
+		producer, err := p.startSource(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timestamps := readAllTimestamps(producer)
+		if len(timestamps) != 3 {
+			t.Fatalf("expected 3 events, got %d", len(timestamps))
+		}
+		for _, ts := range timestamps {
+			if ts.Before(now.Add(-time.Hour)) {
+				t.Errorf("unexpected event older than the cutoff: %s", ts)
+			}
+		}
+		// the events with invalid timestamps are reported only if included
+		data, _ := ioutil.ReadFile(deadLetters)
+		if included := strings.Contains(string(data), invalid); included != (policy == fileSinceInclude) {
+			t.Errorf("unexpected dead-letter file content with policy %s: %s", policy, string(data))
+		}
+
+		// the events not read from files are not filtered
+		producer = p.startAuditSources([]auditSource{{reader: ioutil.NopCloser(strings.NewReader(content))}})
+		if timestamps := readAllTimestamps(producer); len(timestamps) != 5 {
+			t.Fatalf("expected 5 events, got %d", len(timestamps))
+		}
+	}
+
+	for _, cfg := range []string{`{"fileSince":"yesterday"}`, `{"fileSince":"-1h"}`, `{"fileSinceInvalidTimestamp":"skip"}`} {
+		if err := (&Plugin{}).Init(cfg); err == nil {
+			t.Errorf("expected an error for config %s", cfg)
+		}
+	}
+}
+
+func TestParsingStrictness(t *testing.T) {
+	strict := newTestPlugin(t, "{}")
+	lenient := newTestPlugin(t, `{"parsing":"lenient"}`)
+	expected := time.Date(2024, 3, 1, 10, 20, 30, 123000000, time.UTC)
+
+	for _, test := range []struct {
+		timestamp string
+		strict    bool
+		lenient   bool
+	}{
+		{`"2024-03-01T10:20:30.123Z"`, true, true},
+		{`"2024-03-01T11:20:30.123+01:00"`, true, true},
+		{`"2024-03-01T11:20:30.123+0100"`, false, true},
+		{`"2024-03-01T10:20:30.123"`, false, true},
+		{`"2024-03-01 10:20:30.123Z"`, false, true},
+		{`"2024-03-01 10:20:30.123"`, false, true},
+		{`" 2024-03-01T10:20:30.123Z "`, false, true},
+		{`"yesterday"`, false, false},
+		{`1709288430`, false, false},
+	} {
+		for _, p := range []*Plugin{strict, lenient} {
+			ok := test.strict
+			if p == lenient {
+				ok = test.lenient
+			}
+			ts, err := p.parseTimestamp(fastjson.MustParse(test.timestamp))
+			if ok && (err != nil || !ts.Equal(expected)) {
+				t.Errorf("expected %s to be parsed in %s mode, got %s (%v)", test.timestamp, p.Config.Parsing, ts, err)
+			}
+			if !ok && err == nil {
+				t.Errorf("expected %s not to be parsed in %s mode", test.timestamp, p.Config.Parsing)
+			}
+		}
+	}
+
+	// RFC 1123 timestamps have a precision of one second
+	if ts, err := lenient.parseTimestamp(fastjson.MustParse(`"Fri, 01 Mar 2024 10:20:30 GMT"`)); err != nil || !ts.Equal(expected.Truncate(time.Second)) {
+		t.Errorf("unexpected RFC 1123 timestamp: %s (%v)", ts, err)
+	}
+
+	for _, test := range []struct {
+		number  string
+		strict  bool
+		lenient bool
+	}{
+		{`200`, true, true},
+		{`"200"`, true, true},
+		{`" 200 "`, false, true},
+		{`"200.0"`, false, true},
+		{`200.0`, false, true},
+		{`2e2`, false, true},
+		{`"2e2"`, false, true},
+		{`"200.5"`, false, false},
+		{`"-200"`, false, false},
+		{`"two hundred"`, false, false},
+		{`true`, false, false},
+	} {
+		for _, p := range []*Plugin{strict, lenient} {
+			ok := test.strict
+			if p == lenient {
+				ok = test.lenient
+			}
+			v, err := p.jsonValueAsUint64(fastjson.MustParse(test.number))
+			if ok && (err != nil || v != 200) {
+				t.Errorf("expected %s to be parsed as 200 in %s mode, got %d (%v)", test.number, p.Config.Parsing, v, err)
+			}
+			if !ok && err == nil && v == 200 {
+				t.Errorf("expected %s not to be parsed in %s mode", test.number, p.Config.Parsing)
+			}
+		}
+	}
+
+	if err := newTestPlugin(t, "{}").Init(`{"parsing":"loose"}`); err == nil {
+		t.Error("expected an error for an invalid parsing strictness")
+	}
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"sort"
+	"strings"
+)
+
+// clientKindUnknown is the client kind of user agents not matching any
+// classification rule
+const clientKindUnknown = "unknown"
+
+// defaultClientKinds maps the prefixes of well-known user agents to the
+// kind of client sending the request. User agents of clients built with
+// client-go are in the form "<binary>/<version> (<os>/<arch>) kubernetes/<commit>".
+var defaultClientKinds = map[string]string{
+	"kubectl/":                       "kubectl",
+	"kubectl.exe/":                   "kubectl",
+	"Helm/":                          "helm",
+	"helm/":                          "helm",
+	"kubelet/":                       "kubelet",
+	"kube-apiserver/":                "control-plane",
+	"kube-controller-manager/":       "control-plane",
+	"kube-scheduler/":                "control-plane",
+	"cloud-controller-manager/":      "control-plane",
+	"kube-proxy/":                    "control-plane",
+	"argocd-application-controller/": "gitops",
+	"argocd-server/":                 "gitops",
+	"kustomize-controller/":          "gitops",
+	"helm-controller/":               "gitops",
+	"source-controller/":             "gitops",
+	"Terraform/":                     "terraform",
+	"HashiCorp/":                     "terraform",
+	"curl/":                          "http-client",
+	"Wget/":                          "http-client",
+	"python-requests/":               "http-client",
+	"Go-http-client/":                "http-client",
+	"Mozilla/":                       "browser",
+}
+
+// clientKindRule associates a user agent prefix to a client kind
+type clientKindRule struct {
+	prefix string
+	kind   string
+}
+
+// newClientKindRules returns the rules used to classify user agents,
+// combining the default rules with the custom ones that take precedence:
+// custom rules are checked first, even if a default rule has a longer
+// matching prefix. Within each set, rules are sorted by decreasing prefix
+// length, so that the most specific prefix wins.
+func newClientKindRules(custom map[string]string) []clientKindRule {
+	var defaults []clientKindRule
+	for prefix, kind := range defaultClientKinds {
+		if _, ok := custom[prefix]; !ok {
+			defaults = append(defaults, clientKindRule{prefix: prefix, kind: kind})
+		}
+	}
+	var rules []clientKindRule
+	for prefix, kind := range custom {
+		rules = append(rules, clientKindRule{prefix: prefix, kind: kind})
+	}
+	sortClientKindRules(rules)
+	sortClientKindRules(defaults)
+	return append(rules, defaults...)
+}
+
+// sortClientKindRules sorts rules by decreasing prefix length
+func sortClientKindRules(rules []clientKindRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].prefix) != len(rules[j].prefix) {
+			return len(rules[i].prefix) > len(rules[j].prefix)
+		}
+		return rules[i].prefix < rules[j].prefix
+	})
+}
+
+// classifyUserAgent returns the kind of client that sent a request given
+// its user agent
+func (k *Plugin) classifyUserAgent(userAgent string) string {
+	for _, r := range k.clientKindRules {
+		if strings.HasPrefix(userAgent, r.prefix) {
+			return r.kind
+		}
+	}
+	if strings.Contains(userAgent, " kubernetes/") {
+		return "client-go"
+	}
+	return clientKindUnknown
+}
@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errors returned by the send callback of webhookHandler, whose message is
+// sent back to the client
+var (
+	errWebhookEnqueueTimeout = errors.New("event buffer full, retry later")
+	errWebhookShuttingDown   = errors.New("server shutting down, retry later")
+	errWebhookForwardFailed  = errors.New("payload could not be forwarded, retry later")
+)
+
+// webhookHandler handles the requests received by the webhook event source,
+// and sends every valid payload through the send callback. The callback
+// returns an error if the payload could not be enqueued, either because the
+// buffer stayed full within the webhookEnqueueTimeoutMs config when set,
+// because the forwarding queue is full with the forwardOnly config, or
+// because the event source is closing.
+type webhookHandler struct {
+	plugin  *Plugin
+	limiter *rateLimiter
+	sampler *successLogSampler
+	send    func([]byte) error
+}
+
+func (k *Plugin) newWebhookHandler(send func([]byte) error) *webhookHandler {
+	h := &webhookHandler{plugin: k, send: send}
+	if k.Config.WebhookRateLimit > 0 {
+		h.limiter = newRateLimiter(k.Config.WebhookRateLimit, k.Config.WebhookRateLimitBurst)
+	}
+	if k.Config.WebhookLogSuccessEvery > 0 || k.Config.WebhookLogSuccessInterval > 0 {
+		h.sampler = newSuccessLogSampler(k.Config.WebhookLogSuccessEvery, time.Duration(k.Config.WebhookLogSuccessInterval)*time.Second)
+	}
+	return h
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, fmt.Sprintf("%s method not allowed", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.Contains(req.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "wrong Content Type", http.StatusBadRequest)
+		return
+	}
+	if h.limiter != nil && !h.limiter.allow() {
+		// the K8S API server retries with a backoff when receiving 429
+		h.plugin.metrics.inc(&h.plugin.metrics.webhookRateLimited)
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	// note: the limit is enforced while reading, because chunked requests
+	// don't specify their length in advance
+	limit := int64(h.plugin.Config.WebhookMaxBatchSize)
+	if req.ContentLength > limit {
+		msg := fmt.Sprintf("request body too large: %d bytes exceed the limit of %d bytes", req.ContentLength, limit)
+		h.plugin.logger.Println(msg)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, limit)
+	bytes, err := ioutil.ReadAll(req.Body)
+	if err != nil && int64(len(bytes)) >= limit {
+		// MaxBytesReader fails after having returned exactly limit bytes
+		msg := fmt.Sprintf("request body too large: exceeds the limit of %d bytes", limit)
+		h.plugin.logger.Println(msg)
+		h.plugin.writeDeadLetter(msg, bytes)
+		http.Error(w, msg, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf("bad request: %s", err.Error())
+		h.plugin.logger.Println(msg)
+		h.plugin.writeDeadLetter(msg, bytes)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if isBlank(bytes) {
+		// health-check pingers may POST empty bodies, which are acknowledged
+		// and counted without being parsed
+		h.plugin.metrics.inc(&h.plugin.metrics.webhookEmptyRequests)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if h.plugin.Config.WebhookEnqueueTimeoutMs > 0 || h.plugin.Config.ForwardOnly {
+		// the payload is acknowledged only once enqueued, or queued for
+		// forwarding, and the K8S API server retries the ones that could
+		// not be
+		if err := h.send(bytes); err != nil {
+			if err == errWebhookEnqueueTimeout {
+				h.plugin.metrics.inc(&h.plugin.metrics.webhookEnqueueTimeouts)
+			}
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		h.logSuccess(req, len(bytes))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	h.logSuccess(req, len(bytes))
+	h.send(bytes)
+}
+
+// logSuccess logs a successful request if sampled, see successLogSampler
+func (h *webhookHandler) logSuccess(req *http.Request, size int) {
+	if h.sampler == nil {
+		return
+	}
+	if ok, n := h.sampler.sample(); ok {
+		h.plugin.logger.Printf("webhook request accepted: remote=%s size=%d, %d request(s) accepted since the last log", req.RemoteAddr, size, n)
+	}
+}
+
+// isBlank returns true if b is empty or only contains JSON whitespace
+func isBlank(b []byte) bool {
+	for _, c := range b {
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebServerMux returns the handler of the webhook server, which receives
+// the audit events on endpoint and serves the metrics and the probes on the
+// configured paths. The rate limiting, and any other restriction of the
+// callers, is only applied by the webhook handler of the audit endpoint, so
+// that the kubelet and Prometheus can always reach the other paths.
+func (k *Plugin) newWebServerMux(endpoint string, send func([]byte) error, health *webServerHealth) (*http.ServeMux, error) {
+	m := http.NewServeMux()
+	m.Handle(endpoint, k.newWebhookHandler(send))
+	paths := map[string]string{endpoint: "the audit endpoint"}
+	for _, p := range []struct {
+		config  string
+		path    string
+		handler http.Handler
+	}{
+		{"webhookMetricsPath", k.Config.WebhookMetricsPath, &k.metrics},
+		{"webhookHealthzPath", k.Config.WebhookHealthzPath, health.livenessHandler()},
+		{"webhookReadyzPath", k.Config.WebhookReadyzPath, health.readinessHandler()},
+	} {
+		if len(p.path) == 0 {
+			continue
+		}
+		// note: http.ServeMux panics when registering the same path twice
+		if other, ok := paths[p.path]; ok {
+			return nil, fmt.Errorf("%s %s is already used by %s", p.config, p.path, other)
+		}
+		paths[p.path] = p.config
+		m.Handle(p.path, p.handler)
+	}
+	return m, nil
+}
+
+// rateLimiter is a token bucket rate limiter, which allows a given number
+// of operations per second with bursts up to a given size
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newRateLimiter(rate, burst uint64) *rateLimiter {
+	if burst == 0 {
+		burst = rate
+	}
+	return &rateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// allow returns true if one operation can happen now
+func (r *rateLimiter) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if !r.last.IsZero() {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+	}
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// successLogSampler samples the successful requests to be logged, so that
+// they are not all logged at high volume. A request is sampled once every
+// given number of requests, or once the given interval has elapsed since
+// the last sampled one, whichever comes first. Either criterion is
+// disabled if zero.
+type successLogSampler struct {
+	mu       sync.Mutex
+	every    uint64
+	interval time.Duration
+	count    uint64
+	last     time.Time
+	now      func() time.Time
+}
+
+func newSuccessLogSampler(every uint64, interval time.Duration) *successLogSampler {
+	return &successLogSampler{
+		every:    every,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// sample returns true if a request happening now is sampled, along with the
+// number of requests since the last sampled one, including this one
+func (s *successLogSampler) sample() (bool, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	now := s.now()
+	if (s.every > 0 && s.count >= s.every) || (s.interval > 0 && now.Sub(s.last) >= s.interval) {
+		n := s.count
+		s.count = 0
+		s.last = now
+		return true, n
+	}
+	return false, 0
+}
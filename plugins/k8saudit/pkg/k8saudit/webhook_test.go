@@ -0,0 +1,422 @@
+// SPDX-License-Identifier: Apache-2.0
+/*
+Copyright (C) 2024 The Falco Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8saudit
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTestWebhook sends a POST request with the given body to a webhook
+// handler, and returns the response status code
+func serveTestWebhook(h http.Handler, body string) int {
+	req := httptest.NewRequest("POST", "/k8s-audit", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWebhookRateLimit(t *testing.T) {
+	p := newTestPlugin(t, `{"webhookRateLimit":1,"webhookRateLimitBurst":2}`)
+	var received int
+	h := p.newWebhookHandler(func([]byte) error { received++; return nil })
+	now := time.Now()
+	h.limiter.now = func() time.Time { return now }
+
+	event := testAuditEvent(now)
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := serveTestWebhook(h, event); code != expected {
+			t.Fatalf("request %d: expected status %d, got %d", i, expected, code)
+		}
+	}
+	if received != 2 {
+		t.Fatalf("expected 2 payloads received, got %d", received)
+	}
+	if p.metrics.webhookRateLimited != 1 {
+		t.Fatalf("expected 1 rate limited request, got %d", p.metrics.webhookRateLimited)
+	}
+
+	// tokens are refilled over time
+	now = now.Add(time.Second)
+	if code := serveTestWebhook(h, event); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "k8saudit_webhook_rate_limited_requests_total 1\n") {
+		t.Fatalf("unexpected metrics: %s", rec.Body.String())
+	}
+}
+
+func TestWebhookEmptyBody(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	var received int
+	h := p.newWebhookHandler(func([]byte) error { received++; return nil })
+
+	for _, body := range []string{"", " \n\t\r\n"} {
+		if code := serveTestWebhook(h, body); code != http.StatusOK {
+			t.Fatalf("body %q: expected status %d, got %d", body, http.StatusOK, code)
+		}
+	}
+	if received != 0 {
+		t.Fatalf("expected no payloads received, got %d", received)
+	}
+	if code := serveTestWebhook(h, testAuditEvent(time.Now())); code != http.StatusOK || received != 1 {
+		t.Fatalf("expected the audit event to be received, got status %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "k8saudit_webhook_empty_requests_total 2\n") {
+		t.Fatalf("unexpected metrics: %s", rec.Body.String())
+	}
+}
+
+func TestWebServerMuxExemptPaths(t *testing.T) {
+	p := newTestPlugin(t, `{"webhookRateLimit":1,"webhookRateLimitBurst":1,"webhookMetricsPath":"/metrics","webhookHealthzPath":"/healthz","webhookReadyzPath":"/readyz"}`)
+	var health webServerHealth
+	health.setBound()
+	m, err := p.newWebServerMux("/", func([]byte) error { return nil }, &health)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method, path string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(testAuditEvent(time.Now())))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the audit endpoint catches all the other paths, and rejects the
+	// caller once the rate limit is exceeded
+	if code := serve("POST", "/"); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if code := serve("POST", "/"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+	for i := 0; i < 3; i++ {
+		for _, path := range []string{"/metrics", "/healthz", "/readyz"} {
+			if code := serve("GET", path); code != http.StatusOK {
+				t.Fatalf("expected status %d for %s, got %d", http.StatusOK, path, code)
+			}
+		}
+	}
+	if p.metrics.webhookRateLimited != 1 {
+		t.Fatalf("expected 1 rate limited request, got %d", p.metrics.webhookRateLimited)
+	}
+
+	// paths can't be shared
+	p = newTestPlugin(t, `{"webhookHealthzPath":"/k8s-audit"}`)
+	if _, err := p.newWebServerMux("/k8s-audit", func([]byte) error { return nil }, &health); err == nil {
+		t.Fatal("expected an error for a probe path equal to the audit endpoint")
+	}
+	p = newTestPlugin(t, `{"webhookHealthzPath":"/health","webhookReadyzPath":"/health"}`)
+	if _, err := p.newWebServerMux("/k8s-audit", func([]byte) error { return nil }, &health); err == nil {
+		t.Fatal("expected an error for probes sharing the same path")
+	}
+}
+
+func TestWebServerReadTimeout(t *testing.T) {
+	p := newTestPlugin(t, `{"webhookReadHeaderTimeout":0,"webhookReadTimeout":1}`)
+	s := p.newWebServer("127.0.0.1:0", p.newWebhookHandler(func([]byte) error { return nil }))
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a slow client sending a request without ever completing it
+	start := time.Now()
+	if _, err := conn.Write([]byte("POST /k8s-audit HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = ioutil.ReadAll(conn)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Fatal("expected the server to close the connection of the slow client")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the connection to be closed after the read timeout, took %s", elapsed)
+	}
+}
+
+func TestWebhookChunkedBody(t *testing.T) {
+	event := testAuditEvent(time.Now())
+	p := newTestPlugin(t, fmt.Sprintf(`{"webhookMaxBatchSize":%d}`, len(event)+10))
+	var received []string
+	h := p.newWebhookHandler(func(b []byte) error { received = append(received, string(b)); return nil })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TransferEncoding) == 0 || req.TransferEncoding[0] != "chunked" {
+			t.Errorf("expected a chunked request, got %v", req.TransferEncoding)
+		}
+		h.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+
+	post := func(body io.Reader) int {
+		req, err := http.NewRequest("POST", srv.URL+"/k8s-audit", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+
+	// a reader of unknown length is sent with chunked encoding, split
+	// across multiple chunks
+	if code := post(io.MultiReader(strings.NewReader(event[:10]), strings.NewReader(event[10:]))); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if len(received) != 1 || received[0] != event {
+		t.Fatalf("unexpected payloads received: %v", received)
+	}
+
+	if code := post(io.MultiReader(strings.NewReader(event), strings.NewReader(event))); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected the large payload to be dropped, got %d payloads", len(received))
+	}
+
+	// requests declaring a large length are rejected upfront
+	if code := serveTestWebhook(p.newWebhookHandler(func([]byte) error { return nil }), event+event); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+}
+
+func TestWebhookShutdownDrain(t *testing.T) {
+	p := newTestPlugin(t, "{}")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err := p.startWebServer(addr, "/k8s-audit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// start a request whose body is still being sent at shutdown
+	body, bodyWriter := io.Pipe()
+	event := testAuditEvent(time.Now())
+	status := make(chan int, 1)
+	go func() {
+		res, err := http.Post("http://"+addr+"/k8s-audit", "application/json", body)
+		if err != nil {
+			status <- 0
+			return
+		}
+		res.Body.Close()
+		status <- res.StatusCode
+	}()
+	if _, err := io.WriteString(bodyWriter, event[:len(event)/2]); err != nil {
+		t.Fatal(err)
+	}
+	// note: requests whose headers are not read yet when shutting down are
+	// dropped, and retried by the K8S API server
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		producer.close()
+		close(closed)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(bodyWriter, event[len(event)/2:])
+	bodyWriter.Close()
+
+	receiveTestEvents(t, producer, 1)
+	if s := <-status; s != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", s)
+	}
+	select {
+	case _, ok := <-producer.events:
+		if ok {
+			t.Fatal("unexpected event after the drain")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the events to end after the drain")
+	}
+	<-closed
+
+	// new connections are refused once shut down
+	if _, err := http.Post("http://"+addr+"/k8s-audit", "application/json", strings.NewReader(event)); err == nil {
+		t.Fatal("expected the connection to be refused")
+	}
+}
+
+func TestWebhookEnqueueTimeout(t *testing.T) {
+	event := testAuditEvent(time.Now())
+
+	// requests are acknowledged only once enqueued
+	p := newTestPlugin(t, `{"webhookEnqueueTimeoutMs":10}`)
+	sendErr := errWebhookEnqueueTimeout
+	h := p.newWebhookHandler(func([]byte) error { return sendErr })
+	if code := serveTestWebhook(h, event); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, code)
+	}
+	if p.metrics.webhookEnqueueTimeouts != 1 {
+		t.Fatalf("expected 1 enqueue timeout, got %d", p.metrics.webhookEnqueueTimeouts)
+	}
+
+	// requests rejected while shutting down are not counted as timeouts
+	sendErr = errWebhookShuttingDown
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/k8s-audit", strings.NewReader(event))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "shutting down") {
+		t.Fatalf("expected a shutting down response, got %d %q", rec.Code, rec.Body.String())
+	}
+	if p.metrics.webhookEnqueueTimeouts != 1 {
+		t.Fatalf("expected 1 enqueue timeout, got %d", p.metrics.webhookEnqueueTimeouts)
+	}
+	sendErr = nil
+	if code := serveTestWebhook(h, event); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	// requests are rejected once the buffer stays full past the timeout
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	producer, err := p.startWebServer(addr, "/k8s-audit", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// events are not consumed, so that the buffer eventually fills up
+	for i := 0; ; i++ {
+		if i > 10*webServerEventChanBufSize {
+			t.Fatal("expected a request to be rejected with a full buffer")
+		}
+		res, err := http.Post("http://"+addr+"/k8s-audit", "application/json", strings.NewReader(event))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusServiceUnavailable {
+			if res.Header.Get("Retry-After") == "" {
+				t.Fatal("expected a Retry-After header")
+			}
+			break
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", res.StatusCode)
+		}
+	}
+	if p.metrics.webhookEnqueueTimeouts != 2 {
+		t.Fatalf("expected 2 enqueue timeouts, got %d", p.metrics.webhookEnqueueTimeouts)
+	}
+}
+
+func TestWebhookLogSuccessSampling(t *testing.T) {
+	event := testAuditEvent(time.Now())
+	countLogs := func(cfg string, requests int, step time.Duration) int {
+		p := newTestPlugin(t, cfg)
+		p.Config.WebhookMaxBatchSize = uint64(len(event) + 10)
+		var logs strings.Builder
+		p.logger = log.New(&logs, "", 0)
+		h := p.newWebhookHandler(func([]byte) error { return nil })
+		now := time.Now()
+		if h.sampler != nil {
+			h.sampler.now = func() time.Time { return now }
+		}
+		for i := 0; i < requests; i++ {
+			if code := serveTestWebhook(h, event); code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+			}
+			now = now.Add(step)
+		}
+		// errors are always logged
+		if code := serveTestWebhook(h, event+event); code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, code)
+		}
+		if !strings.Contains(logs.String(), "request body too large") {
+			t.Fatalf("expected the error to be logged: %s", logs.String())
+		}
+		return strings.Count(logs.String(), "webhook request accepted")
+	}
+
+	if n := countLogs("{}", 100, time.Millisecond); n != 0 {
+		t.Fatalf("expected no success logs by default, got %d", n)
+	}
+	if n := countLogs(`{"webhookLogSuccessEvery":10}`, 1000, time.Millisecond); n < 90 || n > 110 {
+		t.Fatalf("expected about 100 success logs, got %d", n)
+	}
+	// one request every 100ms for 100s
+	if n := countLogs(`{"webhookLogSuccessInterval":10}`, 1000, 100*time.Millisecond); n < 9 || n > 11 {
+		t.Fatalf("expected about 10 success logs, got %d", n)
+	}
+	if n := countLogs(`{"webhookLogSuccessEvery":100,"webhookLogSuccessInterval":10}`, 1000, 100*time.Millisecond); n < 9 || n > 11 {
+		t.Fatalf("expected about 10 success logs, got %d", n)
+	}
+}